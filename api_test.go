@@ -2,23 +2,49 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"taskmanager/authz"
 	"taskmanager/config"
+	"taskmanager/constants"
+	"taskmanager/logging"
+	"taskmanager/migrations"
 	"taskmanager/models"
 	"taskmanager/routes"
 	"taskmanager/utils"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
 )
 
 type testEnv struct {
 	router       *gin.Engine
+	db           *gorm.DB
 	dbCleanupSQL func(t *testing.T)
 
 	admin models.User
@@ -32,22 +58,37 @@ func setupTestEnv(t *testing.T) *testEnv {
 	gin.SetMode(gin.TestMode)
 
 	if os.Getenv("DB_NAME") == "" {
-		_ = os.Setenv("DB_NAME", "testdbgo")
-	}
-	if os.Getenv("JWT_SECRET") == "" {
-		_ = os.Setenv("JWT_SECRET", "test-secret")
+		if os.Getenv("DB_DRIVER") == config.DriverSQLite {
+			// A fresh file per test, removed afterwards
+			t.Setenv("DB_NAME", filepath.Join(t.TempDir(), "testdbgo.db"))
+		} else {
+			_ = os.Setenv("DB_NAME", "testdbgo")
+		}
 	}
 
-	db := config.ConnectDB()
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	db, err := config.ConnectDB(cfg.Database)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
 
-	if err := db.Migrator().DropTable(&models.TaskAudit{}, &models.Task{}, &models.User{}); err != nil {
+	if err := db.Migrator().DropTable(&models.TaskAudit{}, &models.Task{}, &models.User{}, &models.UserToken{}, &models.Invitation{}, &models.LoginAttempt{}, &models.RecoveryCode{}, &models.TwoFactorPolicy{}, &models.OIDCLoginState{}, &models.AccessToken{}, &models.Role{}, &models.Department{}, &models.Team{}, &models.TeamMember{}, &models.IdempotencyRecord{}, &migrations.SchemaMigration{}); err != nil {
 		t.Fatalf("failed to drop tables: %v", err)
 	}
-	if err := db.AutoMigrate(&models.Task{}, &models.TaskAudit{}, &models.User{}); err != nil {
+	if _, err := migrations.Up(db); err != nil {
 		t.Fatalf("failed to migrate tables: %v", err)
 	}
 
-	router := routes.SetupRouter(db)
+	if err := authz.SeedDefaultRoles(db); err != nil {
+		t.Fatalf("failed to seed roles: %v", err)
+	}
+	// Ids are reused after the tables are recreated.
+	utils.InvalidateReportCache()
+
+	router := routes.SetupRouter(db, cfg)
 
 	admin := models.User{Name: "Admin", Email: "admin@example.com", Role: "admin"}
 	mgr := models.User{Name: "Manager", Email: "manager@example.com", Role: "manager"}
@@ -66,9 +107,10 @@ func setupTestEnv(t *testing.T) *testEnv {
 
 	return &testEnv{
 		router: router,
+		db:     db,
 		dbCleanupSQL: func(t *testing.T) {
 			t.Helper()
-			_ = db.Migrator().DropTable(&models.TaskAudit{}, &models.Task{}, &models.User{})
+			_ = db.Migrator().DropTable(&models.TaskAudit{}, &models.Task{}, &models.User{}, &models.UserToken{}, &models.Invitation{}, &models.LoginAttempt{}, &models.RecoveryCode{}, &models.TwoFactorPolicy{}, &models.OIDCLoginState{}, &models.AccessToken{}, &models.Role{}, &models.Department{}, &models.Team{}, &models.TeamMember{}, &models.IdempotencyRecord{})
 		},
 		admin: admin,
 		mgr:   mgr,
@@ -93,6 +135,9 @@ func doRequest(t *testing.T, r http.Handler, method, path string, body any, head
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
+	if host, ok := headers["Host"]; ok {
+		req.Host = host
+	}
 
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
@@ -139,132 +184,2665 @@ func TestAuth_RegisterAndLogin(t *testing.T) {
 	}
 }
 
-func TestUsers_AdminOnly(t *testing.T) {
+func TestAuth_RegistrationIsRestrictedAndVerified(t *testing.T) {
+	mailFile := filepath.Join(t.TempDir(), "mail.log")
+	t.Setenv("MAIL_SENDER", "file")
+	t.Setenv("MAIL_FILE", mailFile)
+	t.Setenv("REQUIRE_EMAIL_VERIFICATION", "true")
+
 	env := setupTestEnv(t)
 	defer env.dbCleanupSQL(t)
 
-	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
-	mgrAuth := map[string]string{"Authorization": bearerFor(t, env.mgr)}
+	w := doRequest(t, env.router, http.MethodPost, "/register", map[string]any{
+		"name": "Weak", "email": "weak@example.com", "password": "short",
+	}, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("register with weak password expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
 
-	w := doRequest(t, env.router, http.MethodGet, "/users", nil, adminAuth)
+	w = doRequest(t, env.router, http.MethodPost, "/register", map[string]any{
+		"name": "Dup", "email": env.mem.Email, "password": "pass1234",
+	}, nil)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("register with taken email expected 409 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/register", map[string]any{
+		"name": "  ", "email": "blank@example.com", "password": "pass1234",
+	}, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("register without a name expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Concurrent registrations of one email: exactly one wins, the others
+	// get 409 even when they pass the existence check before the insert.
+	codes := make([]int, 4)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = doRequest(t, env.router, http.MethodPost, "/register", map[string]any{
+				"name": "Racer", "email": "racer@example.com", "password": "pass1234",
+			}, nil).Code
+		}(i)
+	}
+	wg.Wait()
+	winners := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			winners++
+		case http.StatusConflict:
+		default:
+			t.Fatalf("unexpected concurrent register status %d in %v", code, codes)
+		}
+	}
+	if winners != 1 {
+		t.Fatalf("expected exactly one concurrent registration to succeed: %v", codes)
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/register", map[string]any{
+		"name": "Mallory", "email": "Mallory@Example.com", "password": "pass1234",
+		"role": "admin", "manager_id": env.admin.ID,
+	}, nil)
 	if w.Code != http.StatusOK {
-		t.Fatalf("GET /users as admin status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("register status=%d body=%s", w.Code, w.Body.String())
 	}
 
-	w = doRequest(t, env.router, http.MethodGet, "/users", nil, mgrAuth)
+	loginBody := map[string]any{"email": "mallory@example.com", "password": "pass1234"}
+	w = doRequest(t, env.router, http.MethodPost, "/login", loginBody, nil)
 	if w.Code != http.StatusForbidden {
-		t.Fatalf("GET /users as manager expected 403 got=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("login before verification expected 403 got=%d body=%s", w.Code, w.Body.String())
 	}
 
-	upd := map[string]any{"role": "manager"}
-	w = doRequest(t, env.router, http.MethodPut, "/users/"+itoa(env.mem.ID), upd, adminAuth)
+	token := lastMailedToken(t, mailFile)
+	w = doRequest(t, env.router, http.MethodGet, "/verify-email?token="+token, nil, nil)
 	if w.Code != http.StatusOK {
-		t.Fatalf("PUT /users/:id as admin status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("GET /verify-email status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/verify-email?token="+token, nil, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("reusing verification token expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/login", loginBody, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login after verification status=%d body=%s", w.Code, w.Body.String())
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal login resp: %v", err)
+	}
+
+	// The requested admin role and manager were ignored.
+	w = doRequest(t, env.router, http.MethodGet, "/users", nil, map[string]string{"Authorization": "Bearer " + resp["token"]})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("self-registered user listing users expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Deactivated users cannot log in even with the right password.
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	w = doRequest(t, env.router, http.MethodPost, "/users/"+itoa(env.mem.ID)+"/deactivate", nil, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /users/:id/deactivate status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/login", map[string]any{"email": env.mem.Email, "password": "pass1234"}, nil)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("login as deactivated user expected 403 got=%d body=%s", w.Code, w.Body.String())
 	}
 }
 
-func TestTasks_CRUDAndDecisions(t *testing.T) {
+func TestAuth_MailFailuresDoNotFailRequests(t *testing.T) {
+	t.Setenv("MAIL_SENDER", "file")
+	t.Setenv("MAIL_FILE", filepath.Join(t.TempDir(), "missing", "mail.log"))
+
 	env := setupTestEnv(t)
 	defer env.dbCleanupSQL(t)
 
+	w := doRequest(t, env.router, http.MethodPost, "/register", map[string]any{
+		"name": "Unmailed", "email": "unmailed@example.com", "password": "pass1234",
+	}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("register with a failing mailer status=%d body=%s", w.Code, w.Body.String())
+	}
+	var count int64
+	if err := env.db.Model(&models.User{}).Where("email = ?", "unmailed@example.com").Count(&count).Error; err != nil || count != 1 {
+		t.Fatalf("expected the registered user to exist: count=%d err=%v", count, err)
+	}
+
+	// Forgot password answers alike for known and unknown emails, even when
+	// the reset mail cannot be sent.
+	for _, email := range []string{env.mem.Email, "nobody@example.com"} {
+		w = doRequest(t, env.router, http.MethodPost, "/password/forgot", map[string]any{"email": email}, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("forgot password for %s with a failing mailer status=%d body=%s", email, w.Code, w.Body.String())
+		}
+	}
+
+	// An invitation is kept and its link returned when the mail fails.
 	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
-	mgrAuth := map[string]string{"Authorization": bearerFor(t, env.mgr)}
-	memAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+	w = doRequest(t, env.router, http.MethodPost, "/users/invitations", map[string]any{"email": "nameless@example.com", "role": "member"}, adminAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /users/invitations with a failing mailer status=%d body=%s", w.Code, w.Body.String())
+	}
+	var invited struct {
+		InviteURL string `json:"invite_url"`
+		MailSent  *bool  `json:"mail_sent"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &invited); err != nil {
+		t.Fatalf("unmarshal invitation: %v", err)
+	}
+	_, token, found := strings.Cut(invited.InviteURL, "token=")
+	if !found || invited.MailSent == nil || *invited.MailSent {
+		t.Fatalf("expected the invite URL and mail_sent=false: %s", w.Body.String())
+	}
 
-	create := map[string]any{
-		"title":               "T1",
-		"description":         "D1",
-		"assigned_to_id":       env.mem.ID,
-		"progress_percentage": 0,
+	// Neither the invitation nor the request names the user.
+	accept := map[string]any{"token": token, "name": " ", "password": "pass1234"}
+	w = doRequest(t, env.router, http.MethodPost, "/invitations/accept", accept, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("accepting without a name expected 400 got=%d body=%s", w.Code, w.Body.String())
 	}
-	// Members are not allowed to create tasks.
-	w := doRequest(t, env.router, http.MethodPost, "/tasks", create, memAuth)
-	if w.Code != http.StatusForbidden {
-		t.Fatalf("POST /tasks as member expected 403 got=%d body=%s", w.Code, w.Body.String())
+	accept["name"] = "Named"
+	w = doRequest(t, env.router, http.MethodPost, "/invitations/accept", accept, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /invitations/accept status=%d body=%s", w.Code, w.Body.String())
 	}
+}
 
-	// Use admin for creation to avoid assignment permission complexity.
-	w = doRequest(t, env.router, http.MethodPost, "/tasks", create, adminAuth)
+func TestAuth_AdminInvitation(t *testing.T) {
+	mailFile := filepath.Join(t.TempDir(), "mail.log")
+	t.Setenv("MAIL_SENDER", "file")
+	t.Setenv("MAIL_FILE", mailFile)
+
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	invite := map[string]any{
+		"email":      "lead@example.com",
+		"name":       "Team Lead",
+		"role":       "manager",
+		"manager_id": env.admin.ID,
+	}
+	w := doRequest(t, env.router, http.MethodPost, "/users/invitations", invite, adminAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /users/invitations status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	token := lastMailedToken(t, mailFile)
+	w = doRequest(t, env.router, http.MethodGet, "/invitations/accept?token="+token, nil, nil)
 	if w.Code != http.StatusOK {
-		t.Fatalf("POST /tasks status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("GET /invitations/accept status=%d body=%s", w.Code, w.Body.String())
 	}
 
-	var created models.Task
-	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
-		t.Fatalf("unmarshal created task: %v", err)
+	accept := map[string]any{"token": token, "password": "pass1234"}
+	w = doRequest(t, env.router, http.MethodPost, "/invitations/accept", accept, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /invitations/accept status=%d body=%s", w.Code, w.Body.String())
+	}
+	var user models.User
+	if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+		t.Fatalf("unmarshal user: %v", err)
+	}
+	if user.Role != "manager" || user.ManagerID == nil || *user.ManagerID != env.admin.ID || user.EmailVerifiedAt == nil {
+		t.Fatalf("invited user not provisioned as invited: %+v", user)
 	}
 
-	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, memAuth)
+	w = doRequest(t, env.router, http.MethodPost, "/invitations/accept", accept, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("accepting invitation twice expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/login", map[string]any{"email": "lead@example.com", "password": "pass1234"}, nil)
 	if w.Code != http.StatusOK {
-		t.Fatalf("GET /tasks status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("login as invited user status=%d body=%s", w.Code, w.Body.String())
 	}
+}
 
-	w = doRequest(t, env.router, http.MethodGet, "/tasks/"+itoa(created.ID), nil, memAuth)
+func TestAuth_PasswordChangeAndReset(t *testing.T) {
+	mailFile := filepath.Join(t.TempDir(), "mail.log")
+	t.Setenv("MAIL_SENDER", "file")
+	t.Setenv("MAIL_FILE", mailFile)
+
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	oldAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+
+	change := map[string]any{"current_password": "wrong-pass1", "new_password": "newpass123"}
+	w := doRequest(t, env.router, http.MethodPost, "/password/change", change, oldAuth)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("change password with wrong current password expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	change["current_password"] = "pass1234"
+	w = doRequest(t, env.router, http.MethodPost, "/password/change", change, oldAuth)
 	if w.Code != http.StatusOK {
-		t.Fatalf("GET /tasks/:id status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("POST /password/change status=%d body=%s", w.Code, w.Body.String())
 	}
+	var changed map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &changed); err != nil {
+		t.Fatalf("unmarshal change resp: %v", err)
+	}
+	newAuth := map[string]string{"Authorization": "Bearer " + changed["token"]}
 
-	statusInProgress := "in_progress"
-	upd := map[string]any{"status": statusInProgress}
-	w = doRequest(t, env.router, http.MethodPut, "/tasks/"+itoa(created.ID), upd, memAuth)
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, oldAuth)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("old session after password change expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, newAuth)
 	if w.Code != http.StatusOK {
-		t.Fatalf("PUT /tasks/:id (to in_progress) status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("new session after password change status=%d body=%s", w.Code, w.Body.String())
 	}
 
-	pp := 100
-	statusPending := "pending_approval"
-	upd = map[string]any{"progress_percentage": pp, "status": statusPending}
-	w = doRequest(t, env.router, http.MethodPut, "/tasks/"+itoa(created.ID), upd, memAuth)
+	// A personal access token minted before the reset is revoked with it.
+	w = doRequest(t, env.router, http.MethodPost, "/tokens", map[string]any{"name": "laptop", "scopes": []string{"tasks:read"}}, newAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /tokens status=%d body=%s", w.Code, w.Body.String())
+	}
+	var pat map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &pat); err != nil {
+		t.Fatalf("unmarshal token: %v", err)
+	}
+	patAuth := map[string]string{"Authorization": "Bearer " + pat["token"].(string)}
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, patAuth)
 	if w.Code != http.StatusOK {
-		t.Fatalf("PUT /tasks/:id (to pending_approval) status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("GET /tasks with access token status=%d body=%s", w.Code, w.Body.String())
 	}
 
-	// Approve as admin (manager cannot access tasks they didn't create/aren't assigned to).
-	w = doRequest(t, env.router, http.MethodPost, "/tasks/"+itoa(created.ID)+"/approve", map[string]any{"comments": "ok"}, adminAuth)
+	// A reset also lifts a lockout.
+	lockedUntil := time.Now().Add(time.Hour)
+	if err := env.db.Model(&models.User{}).Where("id = ?", env.mem.ID).
+		Updates(map[string]any{"failed_login_count": 10, "last_failed_login_at": time.Now(), "locked_until": lockedUntil}).Error; err != nil {
+		t.Fatalf("lock account: %v", err)
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/password/forgot", map[string]any{"email": "nobody@example.com"}, nil)
 	if w.Code != http.StatusOK {
-		t.Fatalf("POST /tasks/:id/approve status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("forgot password for unknown email status=%d body=%s", w.Code, w.Body.String())
+	}
+	// The link points at the configured base URL whatever host the request
+	// claims, so a forged Host cannot capture the victim's token.
+	forged := map[string]string{"Host": "evil.example", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example"}
+	w = doRequest(t, env.router, http.MethodPost, "/password/forgot", map[string]any{"email": env.mem.Email}, forged)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /password/forgot status=%d body=%s", w.Code, w.Body.String())
+	}
+	if mails, err := os.ReadFile(mailFile); err != nil || strings.Contains(string(mails), "evil.example") || !strings.Contains(string(mails), "http://localhost:8000/password/reset?token=") {
+		t.Fatalf("reset link must use the configured base URL: err=%v mails=%s", err, mails)
 	}
 
-	w = doRequest(t, env.router, http.MethodDelete, "/tasks/"+itoa(created.ID), nil, adminAuth)
+	reset := map[string]any{"token": lastMailedToken(t, mailFile), "new_password": "reset1234"}
+	w = doRequest(t, env.router, http.MethodPost, "/password/reset", reset, nil)
 	if w.Code != http.StatusOK {
-		t.Fatalf("DELETE /tasks/:id status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("POST /password/reset status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/password/reset", reset, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("reusing reset token expected 400 got=%d body=%s", w.Code, w.Body.String())
 	}
 
-	// Manager decision flow: create a task under manager's hierarchy and reject it.
-	managerUpdate := map[string]any{"manager_id": env.mgr.ID}
-	w = doRequest(t, env.router, http.MethodPut, "/users/"+itoa(env.mem.ID), managerUpdate, adminAuth)
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, newAuth)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("session after password reset expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, patAuth)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("access token after password reset expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/login", map[string]any{"email": env.mem.Email, "password": "reset1234"}, nil)
 	if w.Code != http.StatusOK {
-		t.Fatalf("PUT /users/:id set manager_id status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("login with reset password status=%d body=%s", w.Code, w.Body.String())
 	}
+}
 
-	create2 := map[string]any{
-		"title":               "T2",
-		"description":         "D2",
-		"assigned_to_id":       env.mem.ID,
-		"progress_percentage": 0,
+func TestAuth_LoginThrottlingAndLockout(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	wrong := map[string]any{"email": env.mem.Email, "password": "wrong-pass1"}
+	right := map[string]any{"email": env.mem.Email, "password": "pass1234"}
+
+	for i := 0; i < 3; i++ {
+		w := doRequest(t, env.router, http.MethodPost, "/login", wrong, nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d expected 401 got=%d body=%s", i+1, w.Code, w.Body.String())
+		}
 	}
-	w = doRequest(t, env.router, http.MethodPost, "/tasks", create2, mgrAuth)
+
+	// Progressive delay: even the right password has to wait.
+	w := doRequest(t, env.router, http.MethodPost, "/login", right, nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("login during delay expected 429 with Retry-After got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// One failure short of the lockout threshold, outside the delay window.
+	past := time.Now().Add(-time.Hour)
+	if err := env.db.Model(&models.User{}).Where("id = ?", env.mem.ID).
+		Updates(map[string]any{"failed_login_count": 9, "last_failed_login_at": past}).Error; err != nil {
+		t.Fatalf("prepare failures: %v", err)
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/login", wrong, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("10th failed login expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/login", right, nil)
+	if w.Code != http.StatusLocked {
+		t.Fatalf("login on locked account expected 423 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/users/"+itoa(env.mem.ID)+"/unlock", nil, adminAuth)
 	if w.Code != http.StatusOK {
-		t.Fatalf("POST /tasks (manager creates) status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("POST /users/:id/unlock status=%d body=%s", w.Code, w.Body.String())
 	}
-	var created2 models.Task
-	if err := json.Unmarshal(w.Body.Bytes(), &created2); err != nil {
-		t.Fatalf("unmarshal created2 task: %v", err)
+	w = doRequest(t, env.router, http.MethodPost, "/login", right, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login after unlock status=%d body=%s", w.Code, w.Body.String())
 	}
 
-	w = doRequest(t, env.router, http.MethodPut, "/tasks/"+itoa(created2.ID), map[string]any{"status": "in_progress"}, memAuth)
+	w = doRequest(t, env.router, http.MethodGet, "/users/"+itoa(env.mem.ID)+"/login-attempts", nil, adminAuth)
 	if w.Code != http.StatusOK {
-		t.Fatalf("PUT /tasks/:id (T2 to in_progress) status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("GET /users/:id/login-attempts status=%d body=%s", w.Code, w.Body.String())
 	}
-	w = doRequest(t, env.router, http.MethodPut, "/tasks/"+itoa(created2.ID), map[string]any{"progress_percentage": 100, "status": "pending_approval"}, memAuth)
+	var attempts []models.LoginAttempt
+	if err := json.Unmarshal(w.Body.Bytes(), &attempts); err != nil {
+		t.Fatalf("unmarshal attempts: %v", err)
+	}
+	if len(attempts) == 0 || !attempts[0].Success {
+		t.Fatalf("expected latest attempt to be a success: %+v", attempts)
+	}
+	var failures int
+	for _, attempt := range attempts {
+		if attempt.Reason == "invalid_credentials" {
+			failures++
+		}
+	}
+	if failures != 4 {
+		t.Fatalf("expected 4 recorded failures, got %d", failures)
+	}
+}
+
+func TestAuth_IPThrottleIgnoresUntrustedForwardedFor(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	// No proxy is trusted, so a new X-Forwarded-For on every attempt must
+	// not start a fresh per-IP count.
+	for i := 0; i < 30; i++ {
+		headers := map[string]string{"X-Forwarded-For": "203.0.113." + strconv.Itoa(i+1)}
+		w := doRequest(t, env.router, http.MethodPost, "/login", map[string]any{"email": "nobody" + strconv.Itoa(i) + "@example.com", "password": "wrong-pass1"}, headers)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d expected 401 got=%d body=%s", i+1, w.Code, w.Body.String())
+		}
+	}
+	w := doRequest(t, env.router, http.MethodPost, "/login", map[string]any{"email": env.mem.Email, "password": "pass1234"}, map[string]string{"X-Forwarded-For": "198.51.100.7"})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("login after 30 failures from one address expected 429 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	var spoofed int64
+	if err := env.db.Model(&models.LoginAttempt{}).Where("ip <> ?", "192.0.2.1").Count(&spoofed).Error; err != nil {
+		t.Fatalf("count login attempts: %v", err)
+	}
+	if spoofed != 0 {
+		t.Fatalf("expected every attempt to be recorded with the connecting address, %d were not", spoofed)
+	}
+}
+
+func TestAuth_TwoFactor(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	memAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	totpAt := func(secret string, offset int64) string {
+		t.Helper()
+		code, err := utils.TOTPCode(secret, utils.TOTPStep(time.Now())+offset)
+		if err != nil {
+			t.Fatalf("totp code: %v", err)
+		}
+		return code
+	}
+
+	w := doRequest(t, env.router, http.MethodPost, "/2fa/enroll", nil, memAuth)
 	if w.Code != http.StatusOK {
-		t.Fatalf("PUT /tasks/:id (T2 to pending_approval) status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("POST /2fa/enroll status=%d body=%s", w.Code, w.Body.String())
+	}
+	var enroll struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &enroll); err != nil {
+		t.Fatalf("unmarshal enroll resp: %v", err)
+	}
+	if !strings.HasPrefix(enroll.ProvisioningURI, "otpauth://totp/") {
+		t.Fatalf("unexpected provisioning uri: %s", enroll.ProvisioningURI)
 	}
 
-	w = doRequest(t, env.router, http.MethodPost, "/tasks/"+itoa(created2.ID)+"/reject", map[string]any{"reason": "Missing tests", "comments": "Add tests"}, mgrAuth)
+	w = doRequest(t, env.router, http.MethodPost, "/2fa/confirm", map[string]any{"code": totpAt(enroll.Secret, 0)}, memAuth)
 	if w.Code != http.StatusOK {
-		t.Fatalf("POST /tasks/:id/reject status=%d body=%s", w.Code, w.Body.String())
+		t.Fatalf("POST /2fa/confirm status=%d body=%s", w.Code, w.Body.String())
+	}
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &confirmed); err != nil {
+		t.Fatalf("unmarshal confirm resp: %v", err)
+	}
+	if len(confirmed.RecoveryCodes) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %d", len(confirmed.RecoveryCodes))
+	}
+
+	login := func() string {
+		t.Helper()
+		w := doRequest(t, env.router, http.MethodPost, "/login", map[string]any{"email": env.mem.Email, "password": "pass1234"}, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("login status=%d body=%s", w.Code, w.Body.String())
+		}
+		var resp map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("unmarshal login resp: %v", err)
+		}
+		if resp["token"] != nil || resp["two_factor_required"] != true {
+			t.Fatalf("expected a two-factor challenge instead of a token: %v", resp)
+		}
+		return resp["challenge_token"].(string)
+	}
+
+	challenge := login()
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, map[string]string{"Authorization": "Bearer " + challenge})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("challenge token used as bearer expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/login/2fa", map[string]any{"challenge_token": challenge, "code": "000000"}, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong 2fa code expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+	// The code used for confirmation cannot be replayed; the next one works.
+	w = doRequest(t, env.router, http.MethodPost, "/login/2fa", map[string]any{"challenge_token": challenge, "code": totpAt(enroll.Secret, 0)}, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed 2fa code expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/login/2fa", map[string]any{"challenge_token": challenge, "code": totpAt(enroll.Secret, 1)}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /login/2fa status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	recovery := map[string]any{"challenge_token": login(), "recovery_code": strings.ToUpper(confirmed.RecoveryCodes[0])}
+	w = doRequest(t, env.router, http.MethodPost, "/login/2fa", recovery, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /login/2fa with recovery code status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/login/2fa", recovery, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("reused recovery code expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Enforce 2FA for managers: the manager has to enroll during login.
+	w = doRequest(t, env.router, http.MethodPut, "/users/2fa-policy/manager", map[string]any{"required": true}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /users/2fa-policy/:role status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/login", map[string]any{"email": env.mgr.Email, "password": "pass1234"}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("manager login status=%d body=%s", w.Code, w.Body.String())
+	}
+	var enrollmentResp map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &enrollmentResp); err != nil {
+		t.Fatalf("unmarshal manager login resp: %v", err)
+	}
+	if enrollmentResp["two_factor_enrollment_required"] != true {
+		t.Fatalf("expected enrollment to be required: %v", enrollmentResp)
+	}
+	enrollmentToken := enrollmentResp["enrollment_token"].(string)
+
+	w = doRequest(t, env.router, http.MethodPost, "/login/2fa/enroll", map[string]any{"enrollment_token": enrollmentToken}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /login/2fa/enroll status=%d body=%s", w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &enroll); err != nil {
+		t.Fatalf("unmarshal enroll resp: %v", err)
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/login/2fa/enroll/confirm", map[string]any{"enrollment_token": enrollmentToken, "code": totpAt(enroll.Secret, 0)}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /login/2fa/enroll/confirm status=%d body=%s", w.Code, w.Body.String())
+	}
+	var session map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil {
+		t.Fatalf("unmarshal session resp: %v", err)
+	}
+	mgrAuth := map[string]string{"Authorization": "Bearer " + session["token"].(string)}
+
+	disable := map[string]any{"password": "pass1234", "code": totpAt(enroll.Secret, 1)}
+	w = doRequest(t, env.router, http.MethodPost, "/2fa/disable", disable, mgrAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("disabling required 2fa expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Guessing the second factor with a session goes through the login
+	// throttle too.
+	if err := env.db.Model(&models.User{}).Where("id = ?", env.mem.ID).Update("failed_login_count", 0).Error; err != nil {
+		t.Fatalf("reset failures: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		w = doRequest(t, env.router, http.MethodPost, "/2fa/recovery-codes", map[string]any{"code": "000000"}, memAuth)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code for recovery codes expected 401 got=%d body=%s", w.Code, w.Body.String())
+		}
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/2fa/disable", map[string]any{"password": "wrong-pass1", "code": "000000"}, memAuth)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("disable with wrong password expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/2fa/disable", map[string]any{"password": "pass1234", "code": "000000"}, memAuth)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("disable after repeated failures expected 429 with Retry-After got=%d body=%s", w.Code, w.Body.String())
+	}
+	var member models.User
+	env.db.First(&member, env.mem.ID)
+	if member.FailedLoginCount != 3 || !member.HasTwoFactor() {
+		t.Fatalf("expected 3 counted failures and 2fa still enabled, got count=%d enabled=%v", member.FailedLoginCount, member.HasTwoFactor())
+	}
+}
+
+// mockIdP is a minimal OpenID provider: discovery, JWKS and a token endpoint
+// that checks the PKCE verifier and signs an RS256 ID token.
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	codes  map[string]mockIdPCode
+}
+
+type mockIdPCode struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate idp key: %v", err)
+	}
+	idp := &mockIdP{t: t, key: key, codes: map[string]mockIdPCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		base := idp.server.URL
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                base,
+			"authorization_endpoint":                base + "/authorize",
+			"token_endpoint":                        base + "/token",
+			"jwks_uri":                              base + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		code, ok := idp.codes[r.FormValue("code")]
+		if !ok {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss": idp.server.URL,
+			"aud": "taskmanager",
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range code.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Errorf("sign id token: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// login runs the browser side of the flow against router and returns the
+// callback response. claims are the identity the IdP vouches for.
+func (idp *mockIdP) login(router http.Handler, claims jwt.MapClaims) *httptest.ResponseRecorder {
+	t := idp.t
+	t.Helper()
+
+	w := doRequest(t, router, http.MethodGet, "/auth/oidc/login", nil, nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("GET /auth/oidc/login status=%d body=%s", w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+	params := location.Query()
+	if params.Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected PKCE S256 challenge in %s", location)
+	}
+
+	code := "code-" + params.Get("state")
+	withNonce := jwt.MapClaims{"nonce": params.Get("nonce")}
+	for k, v := range claims {
+		withNonce[k] = v
+	}
+	idp.codes[code] = mockIdPCode{challenge: params.Get("code_challenge"), claims: withNonce}
+
+	return doRequest(t, router, http.MethodGet, "/auth/oidc/callback?state="+params.Get("state")+"&code="+code, nil, nil)
+}
+
+func TestAuth_OIDCSingleSignOn(t *testing.T) {
+	idp := newMockIdP(t)
+	t.Setenv("OIDC_ISSUER", idp.server.URL)
+	t.Setenv("OIDC_CLIENT_ID", "taskmanager")
+	t.Setenv("OIDC_CLIENT_SECRET", "secret")
+	t.Setenv("OIDC_REDIRECT_URL", "http://localhost:8080/auth/oidc/callback")
+	t.Setenv("OIDC_ADMIN_GROUPS", "it-admins")
+	t.Setenv("OIDC_MANAGER_GROUPS", "team-leads")
+
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	// A new identity is provisioned with the role from its groups.
+	w := idp.login(env.router, jwt.MapClaims{
+		"sub":            "alice-1",
+		"email":          "Alice@Example.com",
+		"email_verified": true,
+		"name":           "Alice",
+		"groups":         []string{"team-leads"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback (new user) status=%d body=%s", w.Code, w.Body.String())
+	}
+	var alice models.User
+	if err := env.db.Where("email = ?", "alice@example.com").First(&alice).Error; err != nil {
+		t.Fatalf("provisioned user not found: %v", err)
+	}
+	if alice.Role != "manager" || alice.EmailVerifiedAt == nil || alice.Password != "" {
+		t.Fatalf("unexpected provisioned user: %+v", alice)
+	}
+
+	// Group changes at the IdP are applied on the next login.
+	w = idp.login(env.router, jwt.MapClaims{
+		"sub":            "alice-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"staff"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback (returning user) status=%d body=%s", w.Code, w.Body.String())
+	}
+	env.db.First(&alice, alice.ID)
+	if alice.Role != "member" {
+		t.Fatalf("expected role synced to member, got %s", alice.Role)
+	}
+
+	// An unverified email must not take over an existing local account, and
+	// neither must one the provider says nothing about.
+	for _, claims := range []jwt.MapClaims{
+		{"sub": "mallory", "email": env.admin.Email, "email_verified": false},
+		{"sub": "mallory", "email": env.admin.Email},
+	} {
+		w = idp.login(env.router, claims)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("callback with %v expected 403 got=%d body=%s", claims, w.Code, w.Body.String())
+		}
+	}
+
+	// Accounts with a local second factor keep needing it.
+	now := time.Now()
+	if err := env.db.Model(&models.User{}).Where("id = ?", env.mgr.ID).Update("two_factor_enabled_at", &now).Error; err != nil {
+		t.Fatalf("enable 2fa: %v", err)
+	}
+	w = idp.login(env.router, jwt.MapClaims{
+		"sub":            "manager-1",
+		"email":          env.mgr.Email,
+		"email_verified": true,
+	})
+	var challenge map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &challenge); err != nil || w.Code != http.StatusOK ||
+		challenge["token"] != nil || challenge["two_factor_required"] != true {
+		t.Fatalf("expected a two-factor challenge for an enrolled account, status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// A verified email links the identity to the existing account.
+	w = idp.login(env.router, jwt.MapClaims{
+		"sub":            "member-1",
+		"email":          env.mem.Email,
+		"email_verified": true,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback (link) status=%d body=%s", w.Code, w.Body.String())
+	}
+	var linked models.User
+	env.db.First(&linked, env.mem.ID)
+	if linked.OIDCSubject == nil || *linked.OIDCSubject != "member-1" {
+		t.Fatalf("expected identity linked to existing user: %+v", linked)
+	}
+
+	var session struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil || session.Token == "" {
+		t.Fatalf("expected session token: %s", w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, map[string]string{"Authorization": "Bearer " + session.Token})
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /tasks with SSO session status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// States are single use.
+	w = doRequest(t, env.router, http.MethodGet, "/auth/oidc/callback?state=unknown&code=x", nil, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("callback with unknown state expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestAuth_AccessTokensAndServiceAccounts(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	mgrAuth := map[string]string{"Authorization": bearerFor(t, env.mgr)}
+
+	w := doRequest(t, env.router, http.MethodPost, "/tokens", map[string]any{"name": "ci", "scopes": []string{"tasks:everything"}}, mgrAuth)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("POST /tokens with unknown scope expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/tokens", map[string]any{"name": "reporting", "scopes": []string{"tasks:read"}}, mgrAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /tokens status=%d body=%s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Token       string             `json:"token"`
+		AccessToken models.AccessToken `json:"access_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("unmarshal token: %v", err)
+	}
+	if !strings.HasPrefix(created.Token, "tm_pat_") {
+		t.Fatalf("unexpected token format: %s", created.Token)
+	}
+	var stored models.AccessToken
+	env.db.First(&stored, created.AccessToken.ID)
+	if stored.TokenHash == created.Token || stored.TokenHash != utils.HashToken(created.Token) {
+		t.Fatalf("token must be stored hashed")
+	}
+
+	patAuth := map[string]string{"Authorization": "Bearer " + created.Token}
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, patAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /tasks with token status=%d body=%s", w.Code, w.Body.String())
+	}
+	env.db.First(&stored, created.AccessToken.ID)
+	if stored.LastUsedAt == nil {
+		t.Fatalf("expected last_used_at to be recorded")
+	}
+
+	// The token only carries the read scope.
+	w = doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "T", "assigned_to_id": env.mem.ID}, patAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("POST /tasks with read-only token expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Tokens cannot manage the account or mint more tokens.
+	w = doRequest(t, env.router, http.MethodGet, "/tokens", nil, patAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("GET /tokens with token expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodDelete, "/tokens/"+itoa(created.AccessToken.ID), nil, mgrAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE /tokens/:id status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, patAuth)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /tasks with revoked token expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Service accounts are managed by admins and only use tokens.
+	w = doRequest(t, env.router, http.MethodPost, "/service-accounts", map[string]any{"name": "Nightly import", "role": "manager"}, mgrAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("POST /service-accounts as manager expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/service-accounts", map[string]any{"name": "Nightly import", "role": "manager"}, adminAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /service-accounts status=%d body=%s", w.Code, w.Body.String())
+	}
+	var account models.User
+	if err := json.Unmarshal(w.Body.Bytes(), &account); err != nil {
+		t.Fatalf("unmarshal service account: %v", err)
+	}
+	if !account.ServiceAccount {
+		t.Fatalf("expected service account flag: %s", w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/service-accounts/"+itoa(account.ID)+"/tokens", map[string]any{"name": "import", "scopes": []string{"tasks:write"}, "expires_in_days": 30}, adminAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /service-accounts/:id/tokens status=%d body=%s", w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("unmarshal token: %v", err)
+	}
+	svcAuth := map[string]string{"Authorization": "Bearer " + created.Token}
+
+	w = doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "Imported", "assigned_to_id": account.ID}, svcAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /tasks as service account status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/login", map[string]any{"email": account.Email, "password": ""}, nil)
+	if w.Code == http.StatusOK {
+		t.Fatalf("service account must not be able to log in with a password")
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/users/"+itoa(account.ID)+"/deactivate", nil, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /users/:id/deactivate status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, svcAuth)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /tasks for deactivated service account expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestAuth_JWTKeyRotationAndJWKS(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+	t.Cleanup(func() { utils.UseJWTKeys(nil) })
+
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+	writePEM := func(name, blockType string, key any) {
+		t.Helper()
+		var der []byte
+		var err error
+		if blockType == "PUBLIC KEY" {
+			der, err = x509.MarshalPKIXPublicKey(key)
+		} else {
+			der, err = x509.MarshalPKCS8PrivateKey(key)
+		}
+		if err != nil {
+			t.Fatalf("marshal %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	writePEM("2026-01", "PRIVATE KEY", rsaKey)
+	writePEM("2026-02", "PRIVATE KEY", edKey)
+
+	if _, err := utils.LoadJWTKeys("", "", true); err == nil {
+		t.Fatalf("expected missing keys to fail in production")
+	}
+	if _, err := utils.LoadJWTKeys(dir, "", true); err == nil {
+		t.Fatalf("expected an error when several private keys exist without JWT_ACTIVE_KID")
+	}
+
+	useKeys := func(activeKID string) {
+		t.Helper()
+		keys, err := utils.LoadJWTKeys(dir, activeKID, true)
+		if err != nil {
+			t.Fatalf("load keys (%s): %v", activeKID, err)
+		}
+		utils.UseJWTKeys(keys)
+	}
+	tokenHeader := func(auth string) map[string]any {
+		t.Helper()
+		raw, err := base64.RawURLEncoding.DecodeString(strings.Split(strings.TrimPrefix(auth, "Bearer "), ".")[0])
+		if err != nil {
+			t.Fatalf("decode token header: %v", err)
+		}
+		var header map[string]any
+		if err := json.Unmarshal(raw, &header); err != nil {
+			t.Fatalf("unmarshal token header: %v", err)
+		}
+		return header
+	}
+
+	useKeys("2026-01")
+	oldAuth := bearerFor(t, env.mem)
+	if header := tokenHeader(oldAuth); header["kid"] != "2026-01" || header["alg"] != "RS256" {
+		t.Fatalf("unexpected token header: %v", header)
+	}
+
+	w := doRequest(t, env.router, http.MethodGet, "/.well-known/jwks.json", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET jwks status=%d body=%s", w.Code, w.Body.String())
+	}
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil {
+		t.Fatalf("unmarshal jwks: %v", err)
+	}
+	if len(jwks.Keys) != 2 || jwks.Keys[0]["kty"] != "RSA" || jwks.Keys[1]["kty"] != "OKP" || jwks.Keys[1]["crv"] != "Ed25519" {
+		t.Fatalf("unexpected jwks: %s", w.Body.String())
+	}
+	for _, key := range jwks.Keys {
+		if key["d"] != "" {
+			t.Fatalf("jwks must not leak private key material: %s", w.Body.String())
+		}
+	}
+
+	// Rotate: the new key signs, and the retired key keeps verifying old
+	// tokens once only its public half is left.
+	if err := os.Remove(filepath.Join(dir, "2026-01.pem")); err != nil {
+		t.Fatalf("remove old key: %v", err)
+	}
+	writePEM("2026-01", "PUBLIC KEY", &rsaKey.PublicKey)
+	if _, err := utils.LoadJWTKeys(dir, "2026-01", true); err == nil {
+		t.Fatalf("expected a public-only key to be refused as the active key")
+	}
+	useKeys("2026-02")
+
+	newAuth := bearerFor(t, env.mem)
+	if header := tokenHeader(newAuth); header["kid"] != "2026-02" || header["alg"] != "EdDSA" {
+		t.Fatalf("unexpected token header after rotation: %v", header)
+	}
+	for _, auth := range []string{oldAuth, newAuth} {
+		w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, map[string]string{"Authorization": auth})
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /tasks after rotation status=%d body=%s", w.Code, w.Body.String())
+		}
+	}
+
+	// The old shared secret and unknown keys are no longer accepted.
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": env.admin.ID,
+		"role":    "admin",
+		"ver":     0,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("supersecretkey"))
+	if err != nil {
+		t.Fatalf("sign forged token: %v", err)
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, map[string]string{"Authorization": "Bearer " + forged})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("HS256 token expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"user_id": env.admin.ID,
+		"role":    "admin",
+		"ver":     0,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	unknown.Header["kid"] = "2026-03"
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	signed, err := unknown.SignedString(otherKey)
+	if err != nil {
+		t.Fatalf("sign unknown-key token: %v", err)
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, map[string]string{"Authorization": "Bearer " + signed})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("token with unknown kid expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Session tokens are typed and scoped, so other tokens signed with the
+	// published keys are not accepted as sessions.
+	raw, err := base64.RawURLEncoding.DecodeString(strings.Split(strings.TrimPrefix(newAuth, "Bearer "), ".")[1])
+	if err != nil {
+		t.Fatalf("decode token claims: %v", err)
+	}
+	var sessionClaims map[string]any
+	if err := json.Unmarshal(raw, &sessionClaims); err != nil {
+		t.Fatalf("unmarshal token claims: %v", err)
+	}
+	if sessionClaims["typ"] != "session" || sessionClaims["aud"] != utils.SessionAudience {
+		t.Fatalf("unexpected session token claims: %v", sessionClaims)
+	}
+	for name, claims := range map[string]jwt.MapClaims{
+		"untyped":        {"user_id": env.admin.ID, "ver": 0, "exp": time.Now().Add(time.Hour).Unix()},
+		"wrong audience": {"typ": "session", "aud": "other-service", "user_id": env.admin.ID, "ver": 0, "exp": time.Now().Add(time.Hour).Unix()},
+		"challenge":      {"typ": "challenge", "aud": utils.SessionAudience, "user_id": env.admin.ID, "ver": 0, "exp": time.Now().Add(time.Hour).Unix()},
+	} {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		token.Header["kid"] = "2026-02"
+		signed, err := token.SignedString(edKey)
+		if err != nil {
+			t.Fatalf("sign %s token: %v", name, err)
+		}
+		w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, map[string]string{"Authorization": "Bearer " + signed})
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s token expected 401 got=%d body=%s", name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestUsers_AdminOnly(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	mgrAuth := map[string]string{"Authorization": bearerFor(t, env.mgr)}
+
+	w := doRequest(t, env.router, http.MethodGet, "/users", nil, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /users as admin status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodGet, "/users", nil, mgrAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("GET /users as manager expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	upd := map[string]any{"role": "manager"}
+	w = doRequest(t, env.router, http.MethodPut, "/users/"+itoa(env.mem.ID), upd, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /users/:id as admin status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Role changes apply to sessions issued before them.
+	memAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+	for _, step := range []struct {
+		role string
+		want int
+	}{
+		{"admin", http.StatusOK},
+		{"member", http.StatusForbidden},
+	} {
+		w = doRequest(t, env.router, http.MethodPut, "/users/"+itoa(env.mem.ID), map[string]any{"role": step.role}, adminAuth)
+		if w.Code != http.StatusOK {
+			t.Fatalf("PUT /users/:id role=%s status=%d body=%s", step.role, w.Code, w.Body.String())
+		}
+		w = doRequest(t, env.router, http.MethodGet, "/users", nil, memAuth)
+		if w.Code != step.want {
+			t.Fatalf("GET /users after the role became %s status=%d, want %d", step.role, w.Code, step.want)
+		}
+	}
+}
+
+func TestUsers_RolesGrantedAreBoundedByTheCaller(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	for _, role := range []map[string]any{
+		{"name": "hr", "permissions": []string{"user.manage", "task.request_extension"}},
+		{"name": "intern", "permissions": []string{"task.request_extension"}},
+	} {
+		w := doRequest(t, env.router, http.MethodPost, "/roles", role, adminAuth)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST /roles status=%d body=%s", w.Code, w.Body.String())
+		}
+	}
+	w := doRequest(t, env.router, http.MethodPut, "/users/"+itoa(env.mgr.ID), map[string]any{"role": "hr"}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /users/:id role=hr status=%d body=%s", w.Code, w.Body.String())
+	}
+	hrAuth := map[string]string{"Authorization": bearerFor(t, env.mgr)}
+
+	for _, tc := range []struct {
+		name, method, path string
+		body               map[string]any
+		want               int
+	}{
+		{"grant a role within the caller's permissions", http.MethodPut, "/users/" + itoa(env.mem.ID), map[string]any{"role": "intern"}, http.StatusOK},
+		{"promote to admin", http.MethodPut, "/users/" + itoa(env.mem.ID), map[string]any{"role": "admin"}, http.StatusForbidden},
+		{"demote an admin", http.MethodPut, "/users/" + itoa(env.admin.ID), map[string]any{"role": "intern"}, http.StatusForbidden},
+		{"create an admin", http.MethodPost, "/users", map[string]any{"name": "Eve", "email": "eve@example.com", "password": "pass1234", "role": "admin"}, http.StatusForbidden},
+		{"invite an admin", http.MethodPost, "/users/invitations", map[string]any{"name": "Eve", "email": "eve@example.com", "role": "admin"}, http.StatusForbidden},
+		{"create an admin service account", http.MethodPost, "/service-accounts", map[string]any{"name": "Backdoor", "role": "admin"}, http.StatusForbidden},
+	} {
+		w := doRequest(t, env.router, tc.method, tc.path, tc.body, hrAuth)
+		if w.Code != tc.want {
+			t.Fatalf("%s: status=%d, want %d, body=%s", tc.name, w.Code, tc.want, w.Body.String())
+		}
+	}
+}
+
+func TestUsers_LifecycleAndOffboarding(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+
+	create := map[string]any{
+		"name":       "Second Manager",
+		"email":      "mgr2@example.com",
+		"password":   "pass1234",
+		"role":       "manager",
+		"manager_id": env.admin.ID,
+	}
+	w := doRequest(t, env.router, http.MethodPost, "/users", create, adminAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /users status=%d body=%s", w.Code, w.Body.String())
+	}
+	var mgr2 models.User
+	if err := json.Unmarshal(w.Body.Bytes(), &mgr2); err != nil {
+		t.Fatalf("unmarshal created user: %v", err)
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/users", create, adminAuth)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("POST /users duplicate email expected 409 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Deactivated users are rejected even with an unexpired token.
+	memAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+	w = doRequest(t, env.router, http.MethodPost, "/users/"+itoa(env.mem.ID)+"/deactivate", nil, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /users/:id/deactivate status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, memAuth)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /tasks as deactivated user expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/users/"+itoa(env.mem.ID)+"/reactivate", nil, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /users/:id/reactivate status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /tasks after reactivation status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Offboard the manager: their open task and direct report move to mgr2.
+	w = doRequest(t, env.router, http.MethodPut, "/users/"+itoa(env.mem.ID), map[string]any{"manager_id": env.mgr.ID}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /users/:id set manager_id status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "Open", "assigned_to_id": env.mgr.ID}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /tasks status=%d body=%s", w.Code, w.Body.String())
+	}
+	var task models.Task
+	if err := json.Unmarshal(w.Body.Bytes(), &task); err != nil {
+		t.Fatalf("unmarshal task: %v", err)
+	}
+
+	w = doRequest(t, env.router, http.MethodDelete, "/users/"+itoa(env.mgr.ID), nil, adminAuth)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("DELETE /users/:id with open work expected 409 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/users/"+itoa(env.mgr.ID)+"/offboard", map[string]any{"successor_id": mgr2.ID}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /users/:id/offboard status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodGet, "/tasks/"+itoa(task.ID), nil, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /tasks/:id status=%d body=%s", w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &task); err != nil {
+		t.Fatalf("unmarshal task: %v", err)
+	}
+	if task.AssignedToID != mgr2.ID {
+		t.Fatalf("expected task reassigned to %d, got %d", mgr2.ID, task.AssignedToID)
+	}
+
+	w = doRequest(t, env.router, http.MethodGet, "/users", nil, adminAuth)
+	var users []models.User
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		t.Fatalf("unmarshal users: %v", err)
+	}
+	for _, u := range users {
+		if u.ID == env.mem.ID && (u.ManagerID == nil || *u.ManagerID != mgr2.ID) {
+			t.Fatalf("expected member to report to successor, got %v", u.ManagerID)
+		}
+		if u.ID == env.mgr.ID && u.IsActive() {
+			t.Fatalf("expected offboarded manager to be deactivated")
+		}
+	}
+
+	w = doRequest(t, env.router, http.MethodDelete, "/users/"+itoa(env.mgr.ID), nil, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE /users/:id after offboarding status=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestUsers_ReportingHierarchy(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	mgrAuth := map[string]string{"Authorization": bearerFor(t, env.mgr)}
+
+	// Six levels below the manager.
+	chain := []models.User{env.mgr}
+	for i := 1; i <= 6; i++ {
+		body := map[string]any{
+			"name":       "Level " + strconv.Itoa(i),
+			"email":      "level" + strconv.Itoa(i) + "@example.com",
+			"password":   "pass1234",
+			"role":       "member",
+			"manager_id": chain[len(chain)-1].ID,
+		}
+		w := doRequest(t, env.router, http.MethodPost, "/users", body, adminAuth)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST /users level %d status=%d body=%s", i, w.Code, w.Body.String())
+		}
+		var user models.User
+		if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+			t.Fatalf("unmarshal user: %v", err)
+		}
+		chain = append(chain, user)
+	}
+	bottom := chain[len(chain)-1]
+
+	w := doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "Assigned by manager", "assigned_to_id": bottom.ID}, mgrAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("manager assigning to a deep report status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "Deep", "assigned_to_id": bottom.ID}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /tasks status=%d body=%s", w.Code, w.Body.String())
+	}
+	visibleTitles := func(auth map[string]string) string {
+		t.Helper()
+		w := doRequest(t, env.router, http.MethodGet, "/tasks", nil, auth)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /tasks status=%d body=%s", w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+	if !strings.Contains(visibleTitles(mgrAuth), `"title":"Deep"`) {
+		t.Fatalf("manager should see tasks six levels down")
+	}
+
+	// Nobody may report to someone in their own reporting line.
+	w = doRequest(t, env.router, http.MethodPut, "/users/"+itoa(env.mgr.ID), map[string]any{"manager_id": bottom.ID}, adminAuth)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("creating a reporting cycle expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Moving the bottom user out of the tree takes effect immediately.
+	w = doRequest(t, env.router, http.MethodPut, "/users/"+itoa(bottom.ID), map[string]any{"role": "member", "manager_id": env.admin.ID}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /users/:id manager change status=%d body=%s", w.Code, w.Body.String())
+	}
+	if strings.Contains(visibleTitles(mgrAuth), `"title":"Deep"`) {
+		t.Fatalf("manager should lose sight of tasks after the report moved away")
+	}
+
+	// A cycle that already exists in the data must not hang lookups.
+	if err := env.db.Model(&models.User{}).Where("id = ?", chain[1].ID).Update("manager_id", chain[3].ID).Error; err != nil {
+		t.Fatalf("create cycle: %v", err)
+	}
+	utils.InvalidateReportCache()
+	reports := utils.GetRecursiveReportIDs(chain[2].ID, env.db)
+	if len(reports) != 4 {
+		t.Fatalf("expected the cycle to be walked once, got reports %v", reports)
+	}
+}
+
+func TestOrg_TreeAndChain(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	mgrAuth := map[string]string{"Authorization": bearerFor(t, env.mgr)}
+	memAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+
+	w := doRequest(t, env.router, http.MethodPut, "/users/"+itoa(env.mem.ID), map[string]any{"role": "member", "manager_id": env.mgr.ID}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /users/:id status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/users", map[string]any{
+		"name":       "Intern",
+		"email":      "intern@example.com",
+		"password":   "pass1234",
+		"role":       "member",
+		"manager_id": env.mem.ID,
+	}, adminAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /users status=%d body=%s", w.Code, w.Body.String())
+	}
+	var intern models.User
+	if err := json.Unmarshal(w.Body.Bytes(), &intern); err != nil {
+		t.Fatalf("unmarshal user: %v", err)
+	}
+
+	for _, task := range []map[string]any{
+		{"title": "Late", "assigned_to_id": env.mem.ID, "deadline": time.Now().Add(-time.Hour)},
+		{"title": "Upcoming", "assigned_to_id": env.mem.ID, "deadline": time.Now().Add(time.Hour)},
+		{"title": "Backlog", "assigned_to_id": env.mgr.ID},
+	} {
+		w = doRequest(t, env.router, http.MethodPost, "/tasks", task, adminAuth)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST /tasks status=%d body=%s", w.Code, w.Body.String())
+		}
+	}
+
+	type node struct {
+		ID           uint   `json:"id"`
+		OpenTasks    int64  `json:"open_tasks"`
+		OverdueTasks int64  `json:"overdue_tasks"`
+		Reports      []node `json:"reports"`
+	}
+	getTree := func(auth map[string]string) []node {
+		t.Helper()
+		w := doRequest(t, env.router, http.MethodGet, "/org/tree", nil, auth)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /org/tree status=%d body=%s", w.Code, w.Body.String())
+		}
+		var roots []node
+		if err := json.Unmarshal(w.Body.Bytes(), &roots); err != nil {
+			t.Fatalf("unmarshal tree: %v", err)
+		}
+		return roots
+	}
+
+	roots := getTree(adminAuth)
+	if len(roots) != 2 || roots[0].ID != env.admin.ID || roots[1].ID != env.mgr.ID {
+		t.Fatalf("expected admin and manager as roots, got %+v", roots)
+	}
+	mgrNode := roots[1]
+	if mgrNode.OpenTasks != 1 || len(mgrNode.Reports) != 1 {
+		t.Fatalf("unexpected manager node: %+v", mgrNode)
+	}
+	memNode := mgrNode.Reports[0]
+	if memNode.ID != env.mem.ID || memNode.OpenTasks != 2 || memNode.OverdueTasks != 1 {
+		t.Fatalf("unexpected member node: %+v", memNode)
+	}
+	if len(memNode.Reports) != 1 || memNode.Reports[0].ID != intern.ID {
+		t.Fatalf("expected intern below member: %+v", memNode)
+	}
+
+	roots = getTree(mgrAuth)
+	if len(roots) != 1 || roots[0].ID != env.mgr.ID || len(roots[0].Reports) != 1 {
+		t.Fatalf("manager should see only their subtree, got %+v", roots)
+	}
+
+	w = doRequest(t, env.router, http.MethodGet, "/org/tree", nil, memAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("GET /org/tree as member expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodGet, "/org/chain", nil, map[string]string{"Authorization": bearerFor(t, intern)})
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /org/chain status=%d body=%s", w.Code, w.Body.String())
+	}
+	var chain struct {
+		User  node   `json:"user"`
+		Chain []node `json:"chain"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &chain); err != nil {
+		t.Fatalf("unmarshal chain: %v", err)
+	}
+	if chain.User.ID != intern.ID || len(chain.Chain) != 2 || chain.Chain[0].ID != env.mem.ID || chain.Chain[1].ID != env.mgr.ID {
+		t.Fatalf("unexpected chain of command: %s", w.Body.String())
+	}
+}
+
+func TestTeams_TeamTasksAndLeads(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	memAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+
+	createUser := func(name, email string) models.User {
+		t.Helper()
+		w := doRequest(t, env.router, http.MethodPost, "/users", map[string]any{
+			"name": name, "email": email, "password": "pass1234", "role": "member",
+		}, adminAuth)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST /users status=%d body=%s", w.Code, w.Body.String())
+		}
+		var u models.User
+		if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil {
+			t.Fatalf("unmarshal user: %v", err)
+		}
+		return u
+	}
+	lead := createUser("Lead", "lead@example.com")
+	outsider := createUser("Outsider", "outsider@example.com")
+	leadAuth := map[string]string{"Authorization": bearerFor(t, lead)}
+	outsiderAuth := map[string]string{"Authorization": bearerFor(t, outsider)}
+
+	w := doRequest(t, env.router, http.MethodPost, "/teams", map[string]any{"name": "Platform"}, memAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("POST /teams as member expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/departments", map[string]any{"name": "Engineering"}, adminAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /departments status=%d body=%s", w.Code, w.Body.String())
+	}
+	var department models.Department
+	if err := json.Unmarshal(w.Body.Bytes(), &department); err != nil {
+		t.Fatalf("unmarshal department: %v", err)
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/teams", map[string]any{"name": "Platform", "department_id": department.ID}, adminAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /teams status=%d body=%s", w.Code, w.Body.String())
+	}
+	var team models.Team
+	if err := json.Unmarshal(w.Body.Bytes(), &team); err != nil {
+		t.Fatalf("unmarshal team: %v", err)
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/teams", map[string]any{"name": "Platform"}, adminAuth)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("duplicate team expected 409 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	teamPath := "/teams/" + itoa(team.ID)
+	if w := doRequest(t, env.router, http.MethodPut, teamPath+"/members/"+itoa(env.mem.ID), nil, adminAuth); w.Code != http.StatusOK {
+		t.Fatalf("PUT member status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, env.router, http.MethodPut, teamPath+"/members/"+itoa(lead.ID), map[string]any{"is_lead": true}, adminAuth); w.Code != http.StatusOK {
+		t.Fatalf("PUT lead status=%d body=%s", w.Code, w.Body.String())
+	}
+	// Repeating the PUT without a body keeps the lead flag.
+	if w := doRequest(t, env.router, http.MethodPut, teamPath+"/members/"+itoa(lead.ID), nil, adminAuth); w.Code != http.StatusOK {
+		t.Fatalf("PUT lead again status=%d body=%s", w.Code, w.Body.String())
+	}
+	var leadMembership models.TeamMember
+	if err := env.db.Where("team_id = ? AND user_id = ?", team.ID, lead.ID).First(&leadMembership).Error; err != nil || !leadMembership.IsLead {
+		t.Fatalf("PUT without is_lead must not demote the lead: %+v err=%v", leadMembership, err)
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "Bad team", "team_id": team.ID + 100}, adminAuth)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown team expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
+	createTask := func(body map[string]any) models.Task {
+		t.Helper()
+		w := doRequest(t, env.router, http.MethodPost, "/tasks", body, adminAuth)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST /tasks status=%d body=%s", w.Code, w.Body.String())
+		}
+		var task models.Task
+		if err := json.Unmarshal(w.Body.Bytes(), &task); err != nil {
+			t.Fatalf("unmarshal task: %v", err)
+		}
+		return task
+	}
+	teamTask := createTask(map[string]any{"title": "Upgrade database", "team_id": team.ID})
+	directTask := createTask(map[string]any{"title": "Write runbook", "assigned_to_id": env.mem.ID})
+
+	listTasks := func(path string, auth map[string]string) []models.Task {
+		t.Helper()
+		w := doRequest(t, env.router, http.MethodGet, path, nil, auth)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s status=%d body=%s", path, w.Code, w.Body.String())
+		}
+		var tasks []models.Task
+		if err := json.Unmarshal(w.Body.Bytes(), &tasks); err != nil {
+			t.Fatalf("unmarshal tasks: %v", err)
+		}
+		return tasks
+	}
+	if tasks := listTasks("/tasks?team_id="+itoa(team.ID), memAuth); len(tasks) != 1 || tasks[0].ID != teamTask.ID {
+		t.Fatalf("member should see the team task, got %+v", tasks)
+	}
+	if tasks := listTasks("/tasks", outsiderAuth); len(tasks) != 0 {
+		t.Fatalf("outsider should see no tasks, got %+v", tasks)
+	}
+	if w := doRequest(t, env.router, http.MethodGet, "/tasks/"+itoa(directTask.ID), nil, leadAuth); w.Code != http.StatusOK {
+		t.Fatalf("lead should see tasks assigned to members, got=%d body=%s", w.Code, w.Body.String())
+	}
+	if tasks := listTasks("/tasks", leadAuth); len(tasks) != 2 {
+		t.Fatalf("lead should see both tasks, got %+v", tasks)
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/tasks/"+itoa(teamTask.ID)+"/claim", nil, outsiderAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("outsider claim expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/tasks/"+itoa(teamTask.ID)+"/claim", nil, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("member claim status=%d body=%s", w.Code, w.Body.String())
+	}
+	var claimed models.Task
+	if err := json.Unmarshal(w.Body.Bytes(), &claimed); err != nil {
+		t.Fatalf("unmarshal task: %v", err)
+	}
+	if claimed.AssignedToID != env.mem.ID || claimed.Status != "assigned" {
+		t.Fatalf("unexpected claimed task: %+v", claimed)
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/tasks/"+itoa(teamTask.ID)+"/claim", nil, leadAuth)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("claiming an assigned task expected 409 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// team_id 0 takes a task out of its team.
+	directPath := "/tasks/" + itoa(directTask.ID)
+	for _, teamID := range []uint{team.ID, 0} {
+		w = doRequest(t, env.router, http.MethodPut, directPath, map[string]any{"team_id": teamID}, adminAuth)
+		if w.Code != http.StatusOK {
+			t.Fatalf("PUT task team_id=%d status=%d body=%s", teamID, w.Code, w.Body.String())
+		}
+		var updated models.Task
+		if err := json.Unmarshal(w.Body.Bytes(), &updated); err != nil {
+			t.Fatalf("unmarshal task: %v", err)
+		}
+		if (teamID == 0) != (updated.TeamID == nil) {
+			t.Fatalf("PUT task team_id=%d left team_id=%v", teamID, updated.TeamID)
+		}
+	}
+
+	if w := doRequest(t, env.router, http.MethodDelete, teamPath, nil, adminAuth); w.Code != http.StatusOK {
+		t.Fatalf("DELETE team status=%d body=%s", w.Code, w.Body.String())
+	}
+	var reloaded models.Task
+	if err := env.db.First(&reloaded, teamTask.ID).Error; err != nil {
+		t.Fatalf("reload task: %v", err)
+	}
+	if reloaded.TeamID != nil {
+		t.Fatalf("deleting the team should detach its tasks, got team_id=%v", *reloaded.TeamID)
+	}
+}
+
+func TestTasks_ClaimFromPool(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+
+	team := models.Team{Name: "Support"}
+	if err := env.db.Create(&team).Error; err != nil {
+		t.Fatalf("create team: %v", err)
+	}
+	other := models.Team{Name: "Sales"}
+	if err := env.db.Create(&other).Error; err != nil {
+		t.Fatalf("create team: %v", err)
+	}
+	claimers := []models.User{env.mem, env.mgr}
+	for i := 0; i < 4; i++ {
+		u := models.User{Name: "Agent", Email: "agent" + strconv.Itoa(i) + "@example.com", Role: "member"}
+		if err := env.db.Create(&u).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		claimers = append(claimers, u)
+	}
+	for _, u := range claimers {
+		if err := env.db.Create(&models.TeamMember{TeamID: team.ID, UserID: u.ID}).Error; err != nil {
+			t.Fatalf("add member: %v", err)
+		}
+	}
+
+	var pooled models.Task
+	for _, body := range []map[string]any{
+		{"title": "Ticket 1", "team_id": team.ID},
+		{"title": "Ticket 2", "team_id": team.ID, "assigned_to_id": env.mem.ID},
+		{"title": "Lead", "team_id": other.ID},
+		{"title": "Unteamed"},
+	} {
+		w := doRequest(t, env.router, http.MethodPost, "/tasks", body, adminAuth)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST /tasks status=%d body=%s", w.Code, w.Body.String())
+		}
+		if body["title"] == "Ticket 1" {
+			if err := json.Unmarshal(w.Body.Bytes(), &pooled); err != nil {
+				t.Fatalf("unmarshal task: %v", err)
+			}
+		}
+	}
+
+	memAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+	w := doRequest(t, env.router, http.MethodGet, "/tasks/pool", nil, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /tasks/pool status=%d body=%s", w.Code, w.Body.String())
+	}
+	var pool []models.Task
+	if err := json.Unmarshal(w.Body.Bytes(), &pool); err != nil {
+		t.Fatalf("unmarshal pool: %v", err)
+	}
+	if len(pool) != 1 || pool[0].ID != pooled.ID {
+		t.Fatalf("pool should hold only the unassigned team task, got %+v", pool)
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/tasks/pool?team_id="+itoa(other.ID), nil, memAuth)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("pool of another team should be empty, status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	auths := make([]map[string]string, len(claimers))
+	for i, u := range claimers {
+		auths[i] = map[string]string{"Authorization": bearerFor(t, u)}
+	}
+	codes := make([]int, len(claimers))
+	var wg sync.WaitGroup
+	for i := range claimers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = doRequest(t, env.router, http.MethodPost, "/tasks/"+itoa(pooled.ID)+"/claim", nil, auths[i]).Code
+		}(i)
+	}
+	wg.Wait()
+
+	winners := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			winners++
+		case http.StatusConflict:
+		default:
+			t.Fatalf("unexpected claim status %d in %v", code, codes)
+		}
+	}
+	if winners != 1 {
+		t.Fatalf("expected exactly one successful claim, got %v", codes)
+	}
+
+	var claimed models.Task
+	if err := env.db.First(&claimed, pooled.ID).Error; err != nil {
+		t.Fatalf("reload task: %v", err)
+	}
+	if claimed.AssignedToID == 0 || claimed.Status != "assigned" {
+		t.Fatalf("unexpected claimed task: %+v", claimed)
+	}
+}
+
+func TestTasks_OptimisticConcurrency(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	w := doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "Shared", "assigned_to_id": env.mem.ID}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /tasks status=%d body=%s", w.Code, w.Body.String())
+	}
+	var task models.Task
+	if err := json.Unmarshal(w.Body.Bytes(), &task); err != nil {
+		t.Fatalf("unmarshal task: %v", err)
+	}
+	path := "/tasks/" + itoa(task.ID)
+
+	w = doRequest(t, env.router, http.MethodGet, path, nil, adminAuth)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag != `"1"` {
+		t.Fatalf("GET task status=%d etag=%q", w.Code, etag)
+	}
+
+	withMatch := func(tag string) map[string]string {
+		return map[string]string{"Authorization": adminAuth["Authorization"], "If-Match": tag}
+	}
+	// If-Match uses the strong comparison, so a weak tag never matches.
+	w = doRequest(t, env.router, http.MethodPut, path, map[string]any{"title": "Weak"}, withMatch("W/"+etag))
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PUT with weak etag expected 412 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPut, path, map[string]any{"title": "First"}, withMatch(etag))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT with current etag status=%d body=%s", w.Code, w.Body.String())
+	}
+	newTag := w.Header().Get("ETag")
+	if newTag != `"2"` {
+		t.Fatalf("expected version 2 after update, got etag %q", newTag)
+	}
+
+	// A second editor still holding the old ETag must not overwrite the change
+	w = doRequest(t, env.router, http.MethodPut, path, map[string]any{"title": "Second"}, withMatch(etag))
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != newTag {
+		t.Fatalf("PUT with stale etag expected 412 got=%d etag=%q body=%s", w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+
+	var stored models.Task
+	if err := env.db.First(&stored, task.ID).Error; err != nil {
+		t.Fatalf("reload task: %v", err)
+	}
+	if stored.Title != "First" || stored.Version != 2 {
+		t.Fatalf("stale write leaked through: %+v", stored)
+	}
+
+	// Writes that bypass the ETag still bump the version
+	memAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+	w = doRequest(t, env.router, http.MethodPut, path, map[string]any{"progress_percentage": 100}, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT progress status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPut, path, map[string]any{"description": "x"}, withMatch(newTag))
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PUT after concurrent progress update expected 412 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPut, path, map[string]any{"status": "in_progress"}, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT status status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPut, path, map[string]any{"status": "completed"}, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT completed status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, path+"/approve", map[string]any{"comments": "ok"}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("approve status=%d body=%s", w.Code, w.Body.String())
+	}
+	var approved models.Task
+	if err := json.Unmarshal(w.Body.Bytes(), &approved); err != nil {
+		t.Fatalf("unmarshal task: %v", err)
+	}
+	if approved.Version != 6 {
+		t.Fatalf("expected version 6 after approval, got %d", approved.Version)
+	}
+}
+
+func TestTasks_IdempotencyKeys(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	withKey := func(user models.User, key string) map[string]string {
+		return map[string]string{"Authorization": bearerFor(t, user), "Idempotency-Key": key}
+	}
+	body := map[string]any{"title": "Once", "assigned_to_id": env.mem.ID}
+
+	first := doRequest(t, env.router, http.MethodPost, "/tasks", body, withKey(env.admin, "create-1"))
+	if first.Code != http.StatusOK {
+		t.Fatalf("POST /tasks status=%d body=%s", first.Code, first.Body.String())
+	}
+	replay := doRequest(t, env.router, http.MethodPost, "/tasks", body, withKey(env.admin, "create-1"))
+	if replay.Code != http.StatusOK || replay.Body.String() != first.Body.String() || replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replay status=%d replayed=%q body=%s", replay.Code, replay.Header().Get("Idempotent-Replayed"), replay.Body.String())
+	}
+	if etag := first.Header().Get("ETag"); etag == "" || replay.Header().Get("ETag") != etag {
+		t.Fatalf("replay must carry the original ETag %q, got %q", etag, replay.Header().Get("ETag"))
+	}
+	var count int64
+	env.db.Model(&models.Task{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("expected a single task after replay, got %d", count)
+	}
+
+	w := doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "Other"}, withKey(env.admin, "create-1"))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("reused key with another body expected 409 got=%d body=%s", w.Code, w.Body.String())
+	}
+	// Keys belong to the caller
+	w = doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "Once"}, withKey(env.mgr, "create-1"))
+	if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("same key for another user should run, status=%d body=%s", w.Code, w.Body.String())
+	}
+	// A caller who lost the permission is refused rather than replayed
+	if err := env.db.Model(&models.Role{}).Where("name = ?", "manager").
+		Update("permissions", models.StringList{constants.PermTaskApprove}).Error; err != nil {
+		t.Fatalf("revoke task.create: %v", err)
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "Once"}, withKey(env.mgr, "create-1"))
+	if w.Code != http.StatusForbidden || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("replay without permission expected 403 got=%d replayed=%q body=%s", w.Code, w.Header().Get("Idempotent-Replayed"), w.Body.String())
+	}
+
+	var task models.Task
+	if err := json.Unmarshal(first.Body.Bytes(), &task); err != nil {
+		t.Fatalf("unmarshal task: %v", err)
+	}
+	if err := env.db.Model(&task).Updates(map[string]any{"status": "pending_approval", "progress_percentage": 100}).Error; err != nil {
+		t.Fatalf("prepare task: %v", err)
+	}
+	approvePath := "/tasks/" + itoa(task.ID) + "/approve"
+	for i := 0; i < 2; i++ {
+		w = doRequest(t, env.router, http.MethodPost, approvePath, map[string]any{"comments": "ok"}, withKey(env.admin, "approve-1"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("approve attempt %d status=%d body=%s", i+1, w.Code, w.Body.String())
+		}
+	}
+	env.db.Model(&models.TaskAudit{}).Where("task_id = ?", task.ID).Count(&count)
+	if count != 1 {
+		t.Fatalf("expected a single audit row after retried approval, got %d", count)
+	}
+
+	// Errors below 500 are replayed too
+	w = doRequest(t, env.router, http.MethodPost, approvePath, map[string]any{}, withKey(env.admin, "approve-2"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("approving an approved task expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, approvePath, map[string]any{}, withKey(env.admin, "approve-2"))
+	if w.Code != http.StatusBadRequest || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replayed error status=%d replayed=%q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestTasks_CRUDAndDecisions(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	mgrAuth := map[string]string{"Authorization": bearerFor(t, env.mgr)}
+	memAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+
+	create := map[string]any{
+		"title":               "T1",
+		"description":         "D1",
+		"assigned_to_id":       env.mem.ID,
+		"progress_percentage": 0,
+	}
+	// Members are not allowed to create tasks.
+	w := doRequest(t, env.router, http.MethodPost, "/tasks", create, memAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("POST /tasks as member expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Use admin for creation to avoid assignment permission complexity.
+	w = doRequest(t, env.router, http.MethodPost, "/tasks", create, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /tasks status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	var created models.Task
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("unmarshal created task: %v", err)
+	}
+
+	w = doRequest(t, env.router, http.MethodGet, "/tasks", nil, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /tasks status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodGet, "/tasks/"+itoa(created.ID), nil, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /tasks/:id status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	statusInProgress := "in_progress"
+	upd := map[string]any{"status": statusInProgress}
+	w = doRequest(t, env.router, http.MethodPut, "/tasks/"+itoa(created.ID), upd, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /tasks/:id (to in_progress) status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	pp := 100
+	statusPending := "pending_approval"
+	upd = map[string]any{"progress_percentage": pp, "status": statusPending}
+	w = doRequest(t, env.router, http.MethodPut, "/tasks/"+itoa(created.ID), upd, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /tasks/:id (to pending_approval) status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Approve as admin (manager cannot access tasks they didn't create/aren't assigned to).
+	w = doRequest(t, env.router, http.MethodPost, "/tasks/"+itoa(created.ID)+"/approve", map[string]any{"comments": "ok"}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /tasks/:id/approve status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodDelete, "/tasks/"+itoa(created.ID), nil, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE /tasks/:id status=%d body=%s", w.Code, w.Body.String())
+	}
+	// The audit trail goes with the task.
+	w = doRequest(t, env.router, http.MethodGet, "/tasks/"+itoa(created.ID), nil, adminAuth)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("GET deleted task status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Manager decision flow: create a task under manager's hierarchy and reject it.
+	managerUpdate := map[string]any{"manager_id": env.mgr.ID}
+	w = doRequest(t, env.router, http.MethodPut, "/users/"+itoa(env.mem.ID), managerUpdate, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /users/:id set manager_id status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	create2 := map[string]any{
+		"title":               "T2",
+		"description":         "D2",
+		"assigned_to_id":       env.mem.ID,
+		"progress_percentage": 0,
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/tasks", create2, mgrAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /tasks (manager creates) status=%d body=%s", w.Code, w.Body.String())
+	}
+	var created2 models.Task
+	if err := json.Unmarshal(w.Body.Bytes(), &created2); err != nil {
+		t.Fatalf("unmarshal created2 task: %v", err)
+	}
+
+	w = doRequest(t, env.router, http.MethodPut, "/tasks/"+itoa(created2.ID), map[string]any{"status": "in_progress"}, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /tasks/:id (T2 to in_progress) status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPut, "/tasks/"+itoa(created2.ID), map[string]any{"progress_percentage": 100, "status": "pending_approval"}, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /tasks/:id (T2 to pending_approval) status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/tasks/"+itoa(created2.ID)+"/reject", map[string]any{"reason": "Missing tests", "comments": "Add tests"}, mgrAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /tasks/:id/reject status=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestMigrations_UpDownStatus(t *testing.T) {
+	env := setupTestEnv(t)
+
+	if err := migrations.Check(env.db); err != nil {
+		t.Fatalf("expected a migrated database, got %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runMigrate(env.db, []string{"down"}, &out); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	if !strings.Contains(out.String(), "rolled back 2 idempotency_response_headers") {
+		t.Fatalf("unexpected migrate down output: %q", out.String())
+	}
+	if env.db.Migrator().HasColumn(&models.IdempotencyRecord{}, "response_headers") {
+		t.Fatalf("expected response_headers column to be dropped")
+	}
+
+	out.Reset()
+	if err := runMigrate(env.db, []string{"down"}, &out); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	if !strings.Contains(out.String(), "rolled back 1 initial_schema") {
+		t.Fatalf("unexpected migrate down output: %q", out.String())
+	}
+	if env.db.Migrator().HasTable(&models.Task{}) {
+		t.Fatalf("expected tasks table to be dropped")
+	}
+	if err := migrations.Check(env.db); !errors.Is(err, migrations.ErrPending) {
+		t.Fatalf("expected pending migrations, got %v", err)
+	}
+
+	out.Reset()
+	if err := runMigrate(env.db, []string{"status"}, &out); err != nil {
+		t.Fatalf("migrate status: %v", err)
+	}
+	if !regexp.MustCompile(`1\s+initial_schema\s+pending`).MatchString(out.String()) {
+		t.Fatalf("unexpected migrate status output: %q", out.String())
+	}
+
+	if err := runMigrate(env.db, []string{"down", "0"}, &out); err == nil {
+		t.Fatalf("expected migrate down 0 to be rejected")
+	}
+
+	out.Reset()
+	if err := runMigrate(env.db, []string{"up"}, &out); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	if err := migrations.Check(env.db); err != nil {
+		t.Fatalf("expected a migrated database, got %v", err)
+	}
+	if !env.db.Migrator().HasTable(&models.Task{}) || !env.db.Migrator().HasColumn(&models.Task{}, "version") {
+		t.Fatalf("expected tasks table to be recreated")
+	}
+	if !env.db.Migrator().HasColumn(&models.IdempotencyRecord{}, "response_headers") {
+		t.Fatalf("expected response_headers column to be added")
+	}
+
+	out.Reset()
+	if err := runMigrate(env.db, []string{"up"}, &out); err != nil || !strings.Contains(out.String(), "up to date") {
+		t.Fatalf("expected a second migrate up to do nothing, got %q, %v", out.String(), err)
+	}
+
+	// A schema migrated by a newer release is refused too.
+	if err := env.db.Create(&migrations.SchemaMigration{Version: 999, Name: "from_the_future", AppliedAt: time.Now()}).Error; err != nil {
+		t.Fatalf("record migration: %v", err)
+	}
+	if err := migrations.Check(env.db); err == nil || !strings.Contains(err.Error(), "newer release") {
+		t.Fatalf("expected unknown migrations to be refused, got %v", err)
+	}
+}
+
+func TestCLI_AdminCommands(t *testing.T) {
+	env := setupTestEnv(t)
+
+	run := func(input string, args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := runCommand(env.db, args, strings.NewReader(input), &out); err != nil {
+			t.Fatalf("%s: %v (output %q)", strings.Join(args, " "), err, out.String())
+		}
+		return out.String()
+	}
+	login := func(email, password string) int {
+		t.Helper()
+		return doRequest(t, env.router, http.MethodPost, "/login", map[string]any{"email": email, "password": password}, nil).Code
+	}
+
+	// The password is read from standard input when not given as a flag.
+	run("rootpass1\n", "create-admin", "-name", "Root", "-email", "Root@Example.com")
+	if code := login("root@example.com", "rootpass1"); code != http.StatusOK {
+		t.Fatalf("expected the new admin to log in, got %d", code)
+	}
+	var out bytes.Buffer
+	if err := runCommand(env.db, []string{"create-admin", "-name", "Again", "-email", "root@example.com", "-password", "rootpass1"}, nil, &out); err == nil {
+		t.Fatalf("expected a duplicate email to be rejected")
+	}
+
+	run("", "reset-password", "-email", env.mem.Email, "-password", "newpass123")
+	if code := login(env.mem.Email, "pass1234"); code != http.StatusUnauthorized {
+		t.Fatalf("expected the old password to stop working, got %d", code)
+	}
+	if code := login(env.mem.Email, "newpass123"); code != http.StatusOK {
+		t.Fatalf("expected the new password to work, got %d", code)
+	}
+
+	run("", "set-manager", "-email", env.mem.Email, "-manager", env.mgr.Email)
+	run("", "set-role", "-email", env.mem.Email, "-role", "manager")
+	var mem models.User
+	env.db.First(&mem, env.mem.ID)
+	if mem.Role != "manager" || mem.ManagerID == nil || *mem.ManagerID != env.mgr.ID {
+		t.Fatalf("expected role and manager to be updated, got %q %v", mem.Role, mem.ManagerID)
+	}
+	// The same rules as over HTTP: no reporting cycles, no unknown roles.
+	if err := runCommand(env.db, []string{"set-manager", "-email", env.mgr.Email, "-manager", env.mem.Email}, nil, &out); err == nil {
+		t.Fatalf("expected a reporting cycle to be rejected")
+	}
+	if err := runCommand(env.db, []string{"set-role", "-email", env.mem.Email, "-role", "wizard"}, nil, &out); err == nil {
+		t.Fatalf("expected an unknown role to be rejected")
+	}
+	run("", "set-manager", "-email", env.mem.Email, "-none")
+	env.db.First(&mem, env.mem.ID)
+	if mem.ManagerID != nil || mem.Role != "manager" {
+		t.Fatalf("expected only the manager to be removed, got %q %v", mem.Role, mem.ManagerID)
+	}
+
+	if got := run("", "seed-demo"); !strings.Contains(got, "4 tasks") {
+		t.Fatalf("unexpected seed-demo output: %q", got)
+	}
+	if code := login(demoMember1Email, "demo1234"); code != http.StatusOK {
+		t.Fatalf("expected demo accounts to log in, got %d", code)
+	}
+	if err := runCommand(env.db, []string{"seed-demo"}, nil, &out); err == nil {
+		t.Fatalf("expected seeding twice to be refused")
+	}
+
+	// A deadline that passes after the task was last written is only noticed
+	// by the sweep.
+	var overdue models.Task
+	if err := env.db.Where("title = ?", "Prepare quarterly report").First(&overdue).Error; err != nil {
+		t.Fatalf("load demo task: %v", err)
+	}
+	if err := env.db.Model(&overdue).UpdateColumn("deadline", time.Now().Add(-time.Hour)).Error; err != nil {
+		t.Fatalf("move deadline: %v", err)
+	}
+	if got := run("", "sweep-overdue"); !strings.Contains(got, "marked 1 tasks overdue") {
+		t.Fatalf("unexpected sweep-overdue output: %q", got)
+	}
+	env.db.First(&overdue, overdue.ID)
+	if overdue.DeadlineStatus != constants.DeadlineStatusOverdue {
+		t.Fatalf("expected the past deadline to be marked overdue, got %q", overdue.DeadlineStatus)
+	}
+	if got := run("", "sweep-overdue"); !strings.Contains(got, "marked 0 tasks overdue") {
+		t.Fatalf("expected a second sweep to change nothing, got %q", got)
+	}
+
+	if err := runCommand(env.db, []string{"frobnicate"}, nil, &out); err == nil {
+		t.Fatalf("expected an unknown command to fail")
+	}
+}
+
+func TestHealth_LivenessAndReadiness(t *testing.T) {
+	env := setupTestEnv(t)
+
+	w := doRequest(t, env.router, http.MethodGet, "/healthz", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /healthz expected 200 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodGet, "/readyz", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /readyz expected 200 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// A schema behind the build takes the instance out of rotation, while
+	// it stays alive.
+	if _, err := migrations.Down(env.db, 1); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/readyz", nil, nil)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"migrations":"pending"`) {
+		t.Fatalf("GET /readyz with pending migrations expected 503 got=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, env.router, http.MethodGet, "/healthz", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("GET /healthz expected 200 got=%d", w.Code)
+	}
+
+	// So does a database that cannot be reached.
+	if _, err := migrations.Up(env.db); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	sqlDB, err := env.db.DB()
+	if err != nil {
+		t.Fatalf("sql db: %v", err)
+	}
+	sqlDB.Close()
+	w = doRequest(t, env.router, http.MethodGet, "/readyz", nil, nil)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"database":"unreachable"`) {
+		t.Fatalf("GET /readyz without a database expected 503 got=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestServer_GracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	var runs, stoppedRuns int
+	var mu sync.Mutex
+	sweep := worker{name: "test", interval: time.Hour, run: func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs++
+		if ctx.Err() != nil {
+			stoppedRuns++
+		}
+		return nil
+	}}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := newHTTPServer(config.ServerConfig{}, handler)
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, srv, ln, []worker{sweep}, 5*time.Second) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/approve")
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- result{string(body), err}
+	}()
+	<-started
+
+	// SIGTERM arrives while the request is in flight.
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned before the request finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second); err == nil {
+		t.Fatalf("expected the listener to be closed during shutdown")
+	}
+
+	close(release)
+	if got := <-responses; got.err != nil || got.body != "done" {
+		t.Fatalf("expected the request in flight to complete, got %q, %v", got.body, got.err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+
+	// serve only returns once the workers have stopped.
+	mu.Lock()
+	defer mu.Unlock()
+	if runs != 1 || stoppedRuns != 0 {
+		t.Fatalf("expected the worker to run once at startup and then stop, got %d runs", runs)
+	}
+}
+
+func TestMetrics_RequestsAndWorkflow(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	var scraperAuth map[string]string
+	scrape := func() string {
+		t.Helper()
+		w := doRequest(t, env.router, http.MethodGet, "/metrics", nil, scraperAuth)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /metrics status=%d body=%s", w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+	// value returns the sample of series, 0 when it has not been recorded.
+	// Counters are shared by every test, so the test compares differences.
+	value := func(body, series string) float64 {
+		t.Helper()
+		for _, line := range strings.Split(body, "\n") {
+			if rest, ok := strings.CutPrefix(line, series+" "); ok {
+				v, err := strconv.ParseFloat(rest, 64)
+				if err != nil {
+					t.Fatalf("parse %s: %v", line, err)
+				}
+				return v
+			}
+		}
+		return 0
+	}
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	memAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+
+	// Scrapers authenticate with a service account token carrying the
+	// metrics:read scope and a role with metrics.read.
+	w := doRequest(t, env.router, http.MethodPost, "/roles", map[string]any{"name": "monitoring", "permissions": []string{"metrics.read"}}, adminAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /roles status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/service-accounts", map[string]any{"name": "Prometheus", "role": "monitoring"}, adminAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /service-accounts status=%d body=%s", w.Code, w.Body.String())
+	}
+	var scraper models.User
+	if err := json.Unmarshal(w.Body.Bytes(), &scraper); err != nil {
+		t.Fatalf("unmarshal service account: %v", err)
+	}
+	tokenAuth := func(scope string) map[string]string {
+		t.Helper()
+		w := doRequest(t, env.router, http.MethodPost, "/service-accounts/"+itoa(scraper.ID)+"/tokens", map[string]any{"name": scope, "scopes": []string{scope}}, adminAuth)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST /service-accounts/:id/tokens status=%d body=%s", w.Code, w.Body.String())
+		}
+		var created struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+			t.Fatalf("unmarshal token: %v", err)
+		}
+		return map[string]string{"Authorization": "Bearer " + created.Token}
+	}
+	for _, tc := range []struct {
+		name string
+		auth map[string]string
+		want int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"member", memAuth, http.StatusForbidden},
+		{"token without the metrics scope", tokenAuth("tasks:read"), http.StatusForbidden},
+		{"admin", adminAuth, http.StatusOK},
+	} {
+		w := doRequest(t, env.router, http.MethodGet, "/metrics", nil, tc.auth)
+		if w.Code != tc.want {
+			t.Fatalf("GET /metrics as %s expected %d got=%d body=%s", tc.name, tc.want, w.Code, w.Body.String())
+		}
+	}
+	scraperAuth = tokenAuth("metrics:read")
+
+	createTask := func(body map[string]any) models.Task {
+		t.Helper()
+		w := doRequest(t, env.router, http.MethodPost, "/tasks", body, adminAuth)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST /tasks status=%d body=%s", w.Code, w.Body.String())
+		}
+		var task models.Task
+		if err := json.Unmarshal(w.Body.Bytes(), &task); err != nil {
+			t.Fatalf("unmarshal task: %v", err)
+		}
+		return task
+	}
+	post := func(path string, body map[string]any, auth map[string]string) {
+		t.Helper()
+		w := doRequest(t, env.router, http.MethodPost, path, body, auth)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST %s status=%d body=%s", path, w.Code, w.Body.String())
+		}
+	}
+	complete := func(task models.Task) {
+		t.Helper()
+		for _, update := range []map[string]any{
+			{"status": "in_progress"},
+			{"progress_percentage": 100},
+			{"status": "completed"},
+		} {
+			w := doRequest(t, env.router, http.MethodPut, "/tasks/"+itoa(task.ID), update, memAuth)
+			if w.Code != http.StatusOK {
+				t.Fatalf("PUT %v status=%d body=%s", update, w.Code, w.Body.String())
+			}
+		}
+	}
+
+	before := scrape()
+
+	late := createTask(map[string]any{"title": "Late", "assigned_to_id": env.mem.ID, "deadline": time.Now().Add(-time.Hour)})
+	done := createTask(map[string]any{"title": "Done", "assigned_to_id": env.mem.ID, "deadline": time.Now().Add(time.Hour)})
+	post("/tasks/"+itoa(late.ID)+"/request-extension", map[string]any{"requested_deadline": time.Now().Add(48 * time.Hour), "reason": "blocked"}, memAuth)
+	complete(done)
+	post("/tasks/"+itoa(done.ID)+"/reject", map[string]any{"reason": "missing tests"}, adminAuth)
+	complete(done)
+	post("/tasks/"+itoa(done.ID)+"/approve", map[string]any{"comments": "ok"}, adminAuth)
+	doRequest(t, env.router, http.MethodGet, "/no-such-route", nil, nil)
+
+	after := scrape()
+	for series, want := range map[string]float64{
+		"taskmanager_tasks_created_total":                                                            2,
+		`taskmanager_task_reviews_total{outcome="approved"}`:                                         1,
+		`taskmanager_task_reviews_total{outcome="rejected"}`:                                         1,
+		"taskmanager_task_extension_requests_total":                                                  1,
+		`taskmanager_http_requests_total{method="POST",route="/tasks/:id/approve",status="200"}`:     1,
+		`taskmanager_http_request_duration_seconds_count{method="POST",route="/tasks",status="200"}`: 2,
+		`taskmanager_http_requests_total{method="GET",route="unmatched",status="404"}`:               1,
+	} {
+		if got := value(after, series) - value(before, series); got != want {
+			t.Fatalf("%s increased by %v, want %v", series, got, want)
+		}
+	}
+
+	// Gauges read from the database when scraped.
+	if got := value(after, `taskmanager_tasks_overdue{status="assigned"}`); got != 1 {
+		t.Fatalf("expected one overdue assigned task, got %v", got)
+	}
+	if !strings.Contains(after, "go_sql_open_connections{db_name=") {
+		t.Fatalf("expected connection pool metrics in:\n%s", after)
+	}
+}
+
+func TestLogging_RequestIDsAndDatabaseErrors(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(logging.New(&logs, slog.LevelInfo))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	// entries returns the logged lines carrying requestID, in order.
+	entries := func(requestID string) []map[string]any {
+		t.Helper()
+		var found []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("log line is not JSON: %q", line)
+			}
+			if entry["request_id"] == requestID {
+				found = append(found, entry)
+			}
+		}
+		return found
+	}
+	memAuth := map[string]string{"Authorization": bearerFor(t, env.mem), logging.RequestIDHeader: "req-42"}
+
+	// A valid client ID is kept, and the access line names the caller.
+	w := doRequest(t, env.router, http.MethodGet, "/tasks", nil, memAuth)
+	if w.Code != http.StatusOK || w.Header().Get(logging.RequestIDHeader) != "req-42" {
+		t.Fatalf("GET /tasks status=%d request id=%q", w.Code, w.Header().Get(logging.RequestIDHeader))
+	}
+	lines := entries("req-42")
+	if len(lines) != 1 {
+		t.Fatalf("expected one log line for the request, got %v", lines)
+	}
+	access := lines[0]
+	if access["msg"] != "request" || access["route"] != "/tasks" || access["status"] != float64(http.StatusOK) ||
+		access["user_id"] != float64(env.mem.ID) || access["role"] != "member" {
+		t.Fatalf("unexpected access line: %v", access)
+	}
+
+	// Anything else is replaced by a generated ID.
+	for _, header := range []map[string]string{nil, {logging.RequestIDHeader: "not an id"}} {
+		w = doRequest(t, env.router, http.MethodGet, "/healthz", nil, header)
+		id := w.Header().Get(logging.RequestIDHeader)
+		if id == "" || id == "not an id" || len(entries(id)) != 1 {
+			t.Fatalf("expected a generated request id, got %q", id)
+		}
+	}
+
+	// Database errors are logged with the request and the caller, and
+	// queries without the values bound to them.
+	if err := env.db.Migrator().DropTable(&models.TaskAudit{}, &models.Task{}); err != nil {
+		t.Fatalf("drop tasks: %v", err)
+	}
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin), logging.RequestIDHeader: "req-43"}
+	w = doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "Secret title", "assigned_to_id": env.mem.ID}, adminAuth)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("POST /tasks without a tasks table status=%d body=%s", w.Code, w.Body.String())
+	}
+	var queryFailed, serviceFailed bool
+	for _, entry := range entries("req-43") {
+		if entry["user_id"] != float64(env.admin.ID) || entry["role"] != "admin" {
+			t.Fatalf("expected the caller in every line, got %v", entry)
+		}
+		switch entry["msg"] {
+		case "database query failed":
+			queryFailed = true
+			if sql, _ := entry["sql"].(string); !strings.Contains(sql, "tasks") || strings.Contains(sql, "Secret title") {
+				t.Fatalf("unexpected query in log: %v", entry)
+			}
+		case "Failed to create task":
+			serviceFailed = entry["error"] != nil
+		}
+	}
+	if !queryFailed || !serviceFailed {
+		t.Fatalf("expected the failed query and its cause to be logged, got %v", entries("req-43"))
+	}
+}
+
+func TestCalendar_FeedFollowsDeadlineExtensions(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	memAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+
+	deadline := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	create := map[string]any{
+		"title":          "Quarterly report",
+		"assigned_to_id": env.mem.ID,
+		"deadline":       deadline,
+	}
+	w := doRequest(t, env.router, http.MethodPost, "/tasks", create, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /tasks status=%d body=%s", w.Code, w.Body.String())
+	}
+	var created models.Task
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("unmarshal created task: %v", err)
+	}
+
+	w = doRequest(t, env.router, http.MethodGet, "/calendar/feed.ics?token=bogus", nil, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET feed with bogus token expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/calendar/token", nil, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /calendar/token status=%d body=%s", w.Code, w.Body.String())
+	}
+	var tokenResp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &tokenResp); err != nil {
+		t.Fatalf("unmarshal token resp: %v", err)
+	}
+
+	w = doRequest(t, env.router, http.MethodGet, "/calendar/feed.ics?token="+tokenResp.Token, nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET feed status=%d body=%s", w.Code, w.Body.String())
+	}
+	feed := w.Body.String()
+	if !strings.Contains(feed, "BEGIN:VEVENT") || !strings.Contains(feed, "SUMMARY:Quarterly report") {
+		t.Fatalf("feed missing task event: %s", feed)
+	}
+	if !strings.Contains(feed, "DTSTART:"+deadline.Format("20060102T150405Z")) {
+		t.Fatalf("feed missing deadline: %s", feed)
+	}
+
+	extended := deadline.Add(72 * time.Hour)
+	w = doRequest(t, env.router, http.MethodPost, "/tasks/"+itoa(created.ID)+"/extend-deadline", map[string]any{"new_deadline": extended}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /tasks/:id/extend-deadline status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodGet, "/calendar/feed.ics?token="+tokenResp.Token+"&type=todo", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET feed (todo) status=%d body=%s", w.Code, w.Body.String())
+	}
+	feed = w.Body.String()
+	if !strings.Contains(feed, "BEGIN:VTODO") || !strings.Contains(feed, "DUE:"+extended.Format("20060102T150405Z")) {
+		t.Fatalf("feed does not reflect extension: %s", feed)
+	}
+	if !strings.Contains(feed, "SEQUENCE:1") {
+		t.Fatalf("expected bumped sequence after extension: %s", feed)
+	}
+
+	// Regenerating the token invalidates the old feed URL.
+	w = doRequest(t, env.router, http.MethodPost, "/calendar/token", nil, memAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /calendar/token (regenerate) status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/calendar/feed.ics?token="+tokenResp.Token, nil, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET feed with old token expected 401 got=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestRoles_CustomRolesAndPermissions(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.dbCleanupSQL(t)
+
+	adminAuth := map[string]string{"Authorization": bearerFor(t, env.admin)}
+	mgrAuth := map[string]string{"Authorization": bearerFor(t, env.mgr)}
+
+	w := doRequest(t, env.router, http.MethodGet, "/roles", nil, mgrAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("GET /roles as manager expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodGet, "/roles", nil, adminAuth)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"manager"`) {
+		t.Fatalf("GET /roles status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/roles", map[string]any{"name": "reviewer", "permissions": []string{"task.fly"}}, adminAuth)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("POST /roles with unknown permission expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
+	reviewer := map[string]any{
+		"name":        "reviewer",
+		"description": "Signs off work across teams",
+		"permissions": []string{"task.view_all", "task.approve"},
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/roles", reviewer, adminAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /roles status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, "/roles", reviewer, adminAuth)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("POST /roles duplicate expected 409 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPut, "/users/"+itoa(env.mem.ID), map[string]any{"role": "nope"}, adminAuth)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT /users/:id with unknown role expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPut, "/users/"+itoa(env.mem.ID), map[string]any{"role": "reviewer"}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /users/:id role=reviewer status=%d body=%s", w.Code, w.Body.String())
+	}
+	env.mem.Role = "reviewer"
+	reviewerAuth := map[string]string{"Authorization": bearerFor(t, env.mem)}
+
+	// The manager finishes a task of their own; the reviewer is outside the
+	// manager's reporting line but can see and approve everything.
+	w = doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "Audit", "assigned_to_id": env.mgr.ID}, mgrAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /tasks status=%d body=%s", w.Code, w.Body.String())
+	}
+	var task models.Task
+	if err := json.Unmarshal(w.Body.Bytes(), &task); err != nil {
+		t.Fatalf("unmarshal task: %v", err)
+	}
+	taskPath := "/tasks/" + itoa(task.ID)
+	w = doRequest(t, env.router, http.MethodPut, taskPath, map[string]any{"status": "in_progress"}, mgrAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT task to in_progress status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPut, taskPath, map[string]any{"progress_percentage": 100, "status": "pending_approval"}, mgrAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT task to pending_approval status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPost, "/tasks", map[string]any{"title": "Nope"}, reviewerAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("POST /tasks as reviewer expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPut, taskPath, map[string]any{"title": "Renamed"}, reviewerAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("PUT task as reviewer expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// Permission changes apply without a new token.
+	w = doRequest(t, env.router, http.MethodPut, "/roles/reviewer", map[string]any{"permissions": []string{"task.view_all"}}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /roles/reviewer status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, taskPath+"/approve", map[string]any{"comments": "ok"}, reviewerAuth)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("approve without task.approve expected 403 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPut, "/roles/reviewer", map[string]any{"permissions": []string{"task.view_all", "task.approve"}}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /roles/reviewer status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPost, taskPath+"/approve", map[string]any{"comments": "ok"}, reviewerAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("approve as reviewer status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, env.router, http.MethodPut, "/roles/admin", map[string]any{"permissions": []string{}}, adminAuth)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT /roles/admin permissions expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodDelete, "/roles/member", nil, adminAuth)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("DELETE built-in role expected 400 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodDelete, "/roles/reviewer", nil, adminAuth)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("DELETE role in use expected 409 got=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodPut, "/users/"+itoa(env.mem.ID), map[string]any{"role": "member"}, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /users/:id role=member status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, env.router, http.MethodDelete, "/roles/reviewer", nil, adminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE /roles/reviewer status=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+// lastMailedToken returns the token from the most recent link written by the
+// file mail sender.
+func lastMailedToken(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read mail file: %v", err)
+	}
+	matches := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindAllStringSubmatch(string(data), -1)
+	if len(matches) == 0 {
+		t.Fatalf("no token found in mail file: %s", data)
 	}
+	return matches[len(matches)-1][1]
 }
 
 func itoa(v uint) string {
@@ -0,0 +1,274 @@
+// Package authz is the single place that decides what a user may do. Roles
+// are sets of permissions stored in the roles table; handlers and
+// middleware ask Can instead of comparing role names.
+package authz
+
+import (
+	"errors"
+	"slices"
+	"taskmanager/constants"
+	"taskmanager/models"
+
+	"gorm.io/gorm"
+)
+
+// Permission describes a grantable permission.
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Permissions lists every permission a role can be granted.
+var Permissions = []Permission{
+	{constants.PermTaskCreate, "Create tasks"},
+	{constants.PermTaskViewAll, "See every task"},
+	{constants.PermTaskViewReports, "See tasks of people in their reporting line"},
+	{constants.PermTaskEdit, "Edit title, description, deadline and assignee of visible tasks"},
+	{constants.PermTaskAssignAny, "Assign tasks to anyone"},
+	{constants.PermTaskAssignReports, "Assign tasks to people in their reporting line"},
+	{constants.PermTaskApprove, "Approve or reject visible tasks"},
+	{constants.PermTaskExtendDeadline, "Extend deadlines of tasks they created"},
+	{constants.PermTaskExtendAnyDeadline, "Extend deadlines of any visible task"},
+	{constants.PermTaskRequestExtension, "Request deadline extensions for tasks assigned to them"},
+	{constants.PermTaskDelete, "Delete visible tasks"},
+	{constants.PermUserManage, "Manage users, invitations, service accounts and 2FA policies"},
+	{constants.PermRoleManage, "Manage roles and their permissions"},
+	{constants.PermTeamManage, "Manage teams, departments and team membership"},
+	{constants.PermMetricsRead, "Read the Prometheus metrics at /metrics"},
+}
+
+// DefaultRoles are seeded on startup and reproduce the original fixed roles.
+var DefaultRoles = []models.Role{
+	{
+		Name:        constants.RoleAdmin,
+		Description: "Full access",
+		Permissions: allPermissionNames(),
+		BuiltIn:     true,
+	},
+	{
+		Name:        constants.RoleManager,
+		Description: "Creates, assigns and approves tasks for their reporting line",
+		Permissions: models.StringList{
+			constants.PermTaskCreate,
+			constants.PermTaskViewReports,
+			constants.PermTaskEdit,
+			constants.PermTaskAssignReports,
+			constants.PermTaskApprove,
+			constants.PermTaskExtendDeadline,
+		},
+		BuiltIn: true,
+	},
+	{
+		Name:        constants.RoleMember,
+		Description: "Works on tasks assigned to them",
+		Permissions: models.StringList{
+			constants.PermTaskRequestExtension,
+		},
+		BuiltIn: true,
+	},
+}
+
+// IsPermission reports whether name is a known permission.
+func IsPermission(name string) bool {
+	for _, permission := range Permissions {
+		if permission.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func allPermissionNames() models.StringList {
+	names := make(models.StringList, 0, len(Permissions))
+	for _, permission := range Permissions {
+		names = append(names, permission.Name)
+	}
+	return names
+}
+
+// SeedDefaultRoles creates missing default roles. The admin role is reset
+// to every permission so new permissions reach it and nobody can lock
+// themselves out; other defaults keep any changes made by admins.
+func SeedDefaultRoles(db *gorm.DB) error {
+	for _, role := range DefaultRoles {
+		var existing models.Role
+		err := db.Where("name = ?", role.Name).First(&existing).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := db.Create(&role).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case role.Name == constants.RoleAdmin:
+			existing.Permissions = role.Permissions
+			existing.BuiltIn = true
+			if err := db.Save(&existing).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Subject is the caller an authorization decision is made for.
+type Subject struct {
+	UserID      uint
+	Role        string
+	Permissions models.StringList
+}
+
+// SubjectFor loads the permissions of role. Unknown roles grant nothing.
+func SubjectFor(db *gorm.DB, userID uint, role string) (Subject, error) {
+	subject := Subject{UserID: userID, Role: role}
+
+	var stored models.Role
+	err := db.Where("name = ?", role).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return subject, nil
+	}
+	if err != nil {
+		return subject, err
+	}
+
+	subject.Permissions = stored.Permissions
+	return subject, nil
+}
+
+// Has reports whether the subject's role grants permission.
+func (s Subject) Has(permission string) bool {
+	return slices.Contains(s.Permissions, permission)
+}
+
+// CanGrant reports whether subject may give role to someone: only roles
+// whose permissions the subject holds itself can be granted, so managing
+// users does not lead to more permissions than the caller has.
+func CanGrant(subject Subject, role models.Role) bool {
+	for _, permission := range role.Permissions {
+		if !subject.Has(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// Directory answers the questions about users and teams that task rules
+// depend on. repository.UserRepository implements it.
+type Directory interface {
+	// User returns nil, without an error, when there is no such user.
+	User(id uint) (*models.User, error)
+	// ReportIDs lists everyone below managerID in the reporting hierarchy.
+	ReportIDs(managerID uint) []uint
+	IsTeamMember(userID, teamID uint) bool
+	// LeadsMember reports whether leadID leads a team memberID belongs to.
+	LeadsMember(leadID, memberID uint) bool
+	// RolePermissions returns the permissions role grants, none for an
+	// unknown role.
+	RolePermissions(role string) (models.StringList, error)
+}
+
+// Can is the policy every handler asks. It reports whether subject may
+// perform action, on task when the action concerns one. dir is only
+// consulted for task actions.
+func Can(dir Directory, subject Subject, action string, task *models.Task) bool {
+	if task == nil {
+		return subject.Has(action)
+	}
+
+	switch action {
+	case constants.ActionTaskView:
+		return canViewTask(dir, subject, task)
+	case constants.ActionTaskUpdate:
+		// Assignees may report progress without the edit permission.
+		return canViewTask(dir, subject, task) &&
+			(subject.Has(constants.PermTaskEdit) || task.AssignedToID == subject.UserID)
+	case constants.ActionTaskClaim:
+		return task.TeamID != nil && task.AssignedToID == 0 && dir.IsTeamMember(subject.UserID, *task.TeamID)
+	case constants.PermTaskRequestExtension:
+		return subject.Has(action) && task.AssignedToID == subject.UserID
+	case constants.PermTaskExtendDeadline:
+		if !canViewTask(dir, subject, task) {
+			return false
+		}
+		if subject.Has(constants.PermTaskExtendAnyDeadline) {
+			return true
+		}
+		return subject.Has(action) && task.CreatedByID == subject.UserID
+	default:
+		return subject.Has(action) && canViewTask(dir, subject, task)
+	}
+}
+
+func canViewTask(dir Directory, subject Subject, task *models.Task) bool {
+	if subject.Has(constants.PermTaskViewAll) {
+		return true
+	}
+	if task.CreatedByID == subject.UserID || task.AssignedToID == subject.UserID {
+		return true
+	}
+	if subject.Has(constants.PermTaskViewReports) {
+		for _, reportID := range dir.ReportIDs(subject.UserID) {
+			if task.CreatedByID == reportID || task.AssignedToID == reportID {
+				return true
+			}
+		}
+	}
+	// Team members see the team's tasks; leads also see everything
+	// assigned to their members.
+	if task.TeamID != nil && dir.IsTeamMember(subject.UserID, *task.TeamID) {
+		return true
+	}
+	return task.AssignedToID != 0 && dir.LeadsMember(subject.UserID, task.AssignedToID)
+}
+
+// CanAssign reports whether subject may assign a task to assigneeID. An
+// assigneeID of 0 leaves the task unassigned.
+func CanAssign(dir Directory, subject Subject, assigneeID uint) (bool, error) {
+	canAssignOthers := subject.Has(constants.PermTaskAssignAny) || subject.Has(constants.PermTaskAssignReports)
+	if assigneeID == 0 {
+		return canAssignOthers, nil
+	}
+
+	// Fetch assignee to check they exist and are active
+	assignee, err := dir.User(assigneeID)
+	if err != nil {
+		return false, err
+	}
+	if assignee == nil {
+		return false, nil
+	}
+
+	// Nobody can hand work to a deactivated account
+	if !assignee.IsActive() {
+		return false, nil
+	}
+
+	if subject.Has(constants.PermTaskAssignAny) {
+		return true, nil
+	}
+
+	// Everyone may assign to themselves
+	if assigneeID == subject.UserID {
+		return true, nil
+	}
+
+	// Only those who can assign to anyone can hand work to someone who
+	// manages users
+	assigneePermissions, err := dir.RolePermissions(assignee.Role)
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(assigneePermissions, constants.PermUserManage) {
+		return false, nil
+	}
+
+	// Team leads can hand work to their members
+	if dir.LeadsMember(subject.UserID, assigneeID) {
+		return true, nil
+	}
+
+	if subject.Has(constants.PermTaskAssignReports) {
+		return slices.Contains(dir.ReportIDs(subject.UserID), assigneeID), nil
+	}
+	return false, nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"taskmanager/authz"
+	"taskmanager/constants"
+	"taskmanager/migrations"
+	"taskmanager/services"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// command is a management subcommand of the server binary, run as
+// "taskmanager <name> [flags]". Commands go through the same services as
+// the HTTP handlers, so the same rules apply.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(cli *cli, args []string) error
+}
+
+var commands = []command{
+	{"serve", "", "Run the HTTP server (the default)", nil},
+	{"migrate", "up | down [steps] | status", "Apply, roll back or list schema migrations", runMigrateCommand},
+	{"create-admin", "-name NAME -email EMAIL [-password PASSWORD]", "Create an admin account", runCreateAdmin},
+	{"reset-password", "-email EMAIL [-password PASSWORD]", "Set a user's password and sign them out everywhere", runResetPassword},
+	{"set-role", "-email EMAIL -role ROLE", "Change a user's role", runSetRole},
+	{"set-manager", "-email EMAIL (-manager EMAIL | -none)", "Change or remove a user's manager", runSetManager},
+	{"seed-demo", "[-password PASSWORD]", "Create demo users and tasks", runSeedDemo},
+	{"sweep-overdue", "", "Mark tasks past their deadline as overdue", runSweepOverdue},
+}
+
+// cli is what commands run with. Passwords not given as flags are read from
+// in, one per line.
+type cli struct {
+	db  *gorm.DB
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// runCommand runs the command named by args[0].
+func runCommand(db *gorm.DB, args []string, in io.Reader, out io.Writer) error {
+	if len(args) > 0 {
+		for _, cmd := range commands {
+			if cmd.name == args[0] && cmd.run != nil {
+				return cmd.run(&cli{db: db, in: bufio.NewReader(in), out: out}, args[1:])
+			}
+		}
+	}
+
+	fmt.Fprintln(out, "usage: taskmanager <command> [arguments]")
+	fmt.Fprintln(out)
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-16s %s\n", cmd.name, cmd.summary)
+		if cmd.usage != "" {
+			fmt.Fprintf(out, "  %-16s   %s %s\n", "", cmd.name, cmd.usage)
+		}
+	}
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// ready checks that the schema is current and the default roles exist,
+// which every command but migrate relies on.
+func (c *cli) ready() error {
+	if err := migrations.Check(c.db); err != nil {
+		return fmt.Errorf("%w; run \"migrate up\" first", err)
+	}
+	return authz.SeedDefaultRoles(c.db)
+}
+
+func (c *cli) users() *services.UserService {
+	return services.NewGormUserService(c.db)
+}
+
+func (c *cli) tasks() *services.TaskService {
+	return services.NewGormTaskService(c.db)
+}
+
+// flags returns a flag set for cmd that reports errors instead of exiting.
+func (c *cli) flags(cmd string) *flag.FlagSet {
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	flags.SetOutput(c.out)
+	return flags
+}
+
+// password returns value, or reads a line from the input when it is empty.
+func (c *cli) password(value string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	fmt.Fprint(c.out, "Password: ")
+	line, err := c.in.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	fmt.Fprintln(c.out)
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func runMigrateCommand(c *cli, args []string) error {
+	return runMigrate(c.db, args, c.out)
+}
+
+func runCreateAdmin(c *cli, args []string) error {
+	flags := c.flags("create-admin")
+	name := flags.String("name", "", "display name")
+	email := flags.String("email", "", "login email")
+	password := flags.String("password", "", "password, read from standard input when omitted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.ready(); err != nil {
+		return err
+	}
+
+	pw, err := c.password(*password)
+	if err != nil {
+		return err
+	}
+	user, err := c.users().Create(services.CreateUserInput{
+		Name:     *name,
+		Email:    *email,
+		Password: pw,
+		Role:     constants.RoleAdmin,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "created admin %d <%s>\n", user.ID, user.Email)
+	return nil
+}
+
+func runResetPassword(c *cli, args []string) error {
+	flags := c.flags("reset-password")
+	email := flags.String("email", "", "email of the user")
+	password := flags.String("password", "", "new password, read from standard input when omitted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.ready(); err != nil {
+		return err
+	}
+
+	user, err := c.users().GetByEmail(*email)
+	if err != nil {
+		return err
+	}
+	pw, err := c.password(*password)
+	if err != nil {
+		return err
+	}
+	if _, err := c.users().SetPassword(user.ID, pw); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "password of <%s> reset; existing sessions are signed out\n", user.Email)
+	return nil
+}
+
+func runSetRole(c *cli, args []string) error {
+	flags := c.flags("set-role")
+	email := flags.String("email", "", "email of the user")
+	role := flags.String("role", "", "new role")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *role == "" {
+		return errors.New("-role is required")
+	}
+	if err := c.ready(); err != nil {
+		return err
+	}
+
+	user, err := c.users().GetByEmail(*email)
+	if err != nil {
+		return err
+	}
+	if _, err := c.users().Update(user.ID, services.UpdateUserInput{Role: *role, ManagerID: user.ManagerID}); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "<%s> is now %s\n", user.Email, *role)
+	return nil
+}
+
+func runSetManager(c *cli, args []string) error {
+	flags := c.flags("set-manager")
+	email := flags.String("email", "", "email of the user")
+	managerEmail := flags.String("manager", "", "email of the new manager")
+	none := flags.Bool("none", false, "remove the user's manager")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if (*managerEmail == "") == !*none {
+		return errors.New("give either -manager or -none")
+	}
+	if err := c.ready(); err != nil {
+		return err
+	}
+
+	user, err := c.users().GetByEmail(*email)
+	if err != nil {
+		return err
+	}
+	var managerID *uint
+	if !*none {
+		manager, err := c.users().GetByEmail(*managerEmail)
+		if err != nil {
+			return fmt.Errorf("manager: %w", err)
+		}
+		managerID = &manager.ID
+	}
+	if _, err := c.users().Update(user.ID, services.UpdateUserInput{ManagerID: managerID}); err != nil {
+		return err
+	}
+
+	if *none {
+		fmt.Fprintf(c.out, "<%s> no longer has a manager\n", user.Email)
+	} else {
+		fmt.Fprintf(c.out, "<%s> now reports to <%s>\n", user.Email, *managerEmail)
+	}
+	return nil
+}
+
+// Demo accounts created by seed-demo.
+const (
+	demoManagerEmail = "demo-manager@example.com"
+	demoMember1Email = "demo-member1@example.com"
+	demoMember2Email = "demo-member2@example.com"
+)
+
+func runSeedDemo(c *cli, args []string) error {
+	flags := c.flags("seed-demo")
+	password := flags.String("password", "demo1234", "password of the demo accounts")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.ready(); err != nil {
+		return err
+	}
+
+	users := c.users()
+	if _, err := users.GetByEmail(demoManagerEmail); err == nil {
+		return errors.New("demo data is already present")
+	} else if services.KindOf(err) != services.KindNotFound {
+		return err
+	}
+
+	manager, err := users.Create(services.CreateUserInput{Name: "Demo Manager", Email: demoManagerEmail, Password: *password, Role: constants.RoleManager})
+	if err != nil {
+		return err
+	}
+	member1, err := users.Create(services.CreateUserInput{Name: "Demo Member 1", Email: demoMember1Email, Password: *password, Role: constants.RoleMember, ManagerID: &manager.ID})
+	if err != nil {
+		return err
+	}
+	member2, err := users.Create(services.CreateUserInput{Name: "Demo Member 2", Email: demoMember2Email, Password: *password, Role: constants.RoleMember, ManagerID: &manager.ID})
+	if err != nil {
+		return err
+	}
+
+	managerSubject, err := authz.SubjectFor(c.db, manager.ID, manager.Role)
+	if err != nil {
+		return err
+	}
+	member1Subject, err := authz.SubjectFor(c.db, member1.ID, member1.Role)
+	if err != nil {
+		return err
+	}
+
+	tasks := c.tasks()
+	day := 24 * time.Hour
+	nextWeek := time.Now().Add(7 * day)
+	twoDaysAgo := time.Now().Add(-2 * day)
+	for _, input := range []services.CreateTaskInput{
+		{Title: "Prepare quarterly report", Description: "Collect the numbers from every team", AssignedToID: member1.ID, Deadline: &nextWeek},
+		{Title: "Update onboarding checklist", Description: "Add the new tooling", AssignedToID: member2.ID, Deadline: &twoDaysAgo},
+		{Title: "Plan team offsite", Description: "Not assigned yet"},
+	} {
+		if _, err := tasks.Create(managerSubject, input); err != nil {
+			return err
+		}
+	}
+	started, err := tasks.Create(managerSubject, services.CreateTaskInput{Title: "Review vendor contracts", AssignedToID: member1.ID, Deadline: &nextWeek})
+	if err != nil {
+		return err
+	}
+	inProgress, progress := constants.TaskStatusInProgress, 40
+	if _, err := tasks.Update(member1Subject, started.ID, services.UpdateTaskInput{Status: &inProgress, ProgressPercentage: &progress}); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "created %s, %s and %s (password %q) and 4 tasks\n", demoManagerEmail, demoMember1Email, demoMember2Email, *password)
+	return nil
+}
+
+func runSweepOverdue(c *cli, args []string) error {
+	if err := c.flags("sweep-overdue").Parse(args); err != nil {
+		return err
+	}
+	if err := c.ready(); err != nil {
+		return err
+	}
+
+	changed, err := c.tasks().SweepOverdue(time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "marked %d tasks overdue\n", changed)
+	return nil
+}
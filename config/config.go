@@ -0,0 +1,499 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment modes. Production refuses the defaults that only make sense
+// on a developer's machine.
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// Database credentials used in development when none are configured. They
+// are rejected in production, as is the placeholder from .env.example.
+const (
+	devDBUser     = "admin"
+	devDBPassword = "12345678"
+)
+
+var insecureDBPasswords = []string{devDBPassword, "change_me"}
+
+var postgresSSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// Config is the complete application configuration. Load builds it from
+// defaults, an optional config file and the environment, in that order of
+// precedence, lowest first.
+type Config struct {
+	Env      string         `yaml:"env" toml:"env"`
+	Server   ServerConfig   `yaml:"server" toml:"server"`
+	Database DatabaseConfig `yaml:"database" toml:"database"`
+	Auth     AuthConfig     `yaml:"auth" toml:"auth"`
+	OIDC     OIDCConfig     `yaml:"oidc" toml:"oidc"`
+	Mail     MailConfig     `yaml:"mail" toml:"mail"`
+	Workers  WorkersConfig  `yaml:"workers" toml:"workers"`
+	Log      LogConfig      `yaml:"log" toml:"log"`
+}
+
+type ServerConfig struct {
+	Port int `yaml:"port" toml:"port"`
+	// BaseURL is the public URL used in links sent to users. Links are
+	// never built from request headers, which the client controls; in
+	// development it defaults to http://localhost and the port.
+	BaseURL string `yaml:"base_url" toml:"base_url"`
+	// TrustedProxies are the addresses or CIDR ranges of the reverse
+	// proxies whose X-Forwarded-For header names the client. Without any,
+	// the client is always the address of the connection.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	ReadTimeout       Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout      Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// ShutdownTimeout bounds how long in-flight requests may take to finish
+	// once the server is asked to stop.
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+// DatabaseConfig selects and locates the database. DSN, when set, is passed
+// to the driver as is and the other connection settings are ignored. For
+// sqlite Name is the database file.
+type DatabaseConfig struct {
+	Driver   string `yaml:"driver" toml:"driver"`
+	DSN      string `yaml:"dsn" toml:"dsn"`
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"`
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	Name     string `yaml:"name" toml:"name"`
+	// SSLMode is the PostgreSQL sslmode (disable, allow, prefer, require,
+	// verify-ca or verify-full). It defaults to require in production and
+	// disable in development.
+	SSLMode string `yaml:"sslmode" toml:"sslmode"`
+	// SlowQueryThreshold is the duration above which a query is logged as
+	// slow. Zero disables the warning.
+	SlowQueryThreshold Duration `yaml:"slow_query_threshold" toml:"slow_query_threshold"`
+}
+
+type AuthConfig struct {
+	// JWTKeysDir holds the PEM files that sign and verify tokens; without
+	// it an ephemeral key is used, which production does not allow.
+	JWTKeysDir   string `yaml:"jwt_keys_dir" toml:"jwt_keys_dir"`
+	JWTActiveKID string `yaml:"jwt_active_kid" toml:"jwt_active_kid"`
+
+	RequireEmailVerification bool `yaml:"require_email_verification" toml:"require_email_verification"`
+}
+
+// MailConfig selects how outgoing email is delivered: "log", "file" or
+// "smtp".
+type MailConfig struct {
+	Sender       string `yaml:"sender" toml:"sender"`
+	File         string `yaml:"file" toml:"file"`
+	From         string `yaml:"from" toml:"from"`
+	SMTPHost     string `yaml:"smtp_host" toml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port" toml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username" toml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password" toml:"smtp_password"`
+}
+
+// WorkersConfig schedules the background jobs run next to the server. An
+// interval of zero disables the job.
+type WorkersConfig struct {
+	OverdueSweepInterval Duration `yaml:"overdue_sweep_interval" toml:"overdue_sweep_interval"`
+}
+
+// LogConfig controls the JSON log written to standard error.
+type LogConfig struct {
+	// Level is the lowest level logged: debug, info, warn or error. At debug
+	// every database query is logged as well.
+	Level string `yaml:"level" toml:"level"`
+}
+
+// SlogLevel returns Level as a slog.Level. Load has validated it.
+func (c LogConfig) SlogLevel() slog.Level {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(c.Level))
+	return level
+}
+
+// Duration is a time.Duration written like "30s" or "5m" in config files
+// and environment variables.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ValidationError lists everything wrong with a configuration, so that it
+// can be fixed in one go.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Production reports whether the configuration is for production.
+func (c *Config) Production() bool {
+	return c.Env == EnvProduction
+}
+
+// Load reads the configuration. CONFIG_FILE names an optional YAML
+// (.yaml, .yml) or TOML (.toml) file; environment variables override its
+// values. The result is validated, and an invalid configuration is returned
+// as a *ValidationError.
+func Load() (*Config, error) {
+	cfg := defaults()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var problems []string
+	cfg.loadEnv(&problems)
+	cfg.applyModeDefaults()
+	cfg.validate(&problems)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+func defaults() *Config {
+	return &Config{
+		Env: EnvDevelopment,
+		Server: ServerConfig{
+			Port:              8000,
+			ReadHeaderTimeout: Duration(10 * time.Second),
+			ReadTimeout:       Duration(30 * time.Second),
+			WriteTimeout:      Duration(30 * time.Second),
+			IdleTimeout:       Duration(2 * time.Minute),
+			ShutdownTimeout:   Duration(30 * time.Second),
+		},
+		Database: DatabaseConfig{
+			Driver: DriverMySQL,
+			Host:   "127.0.0.1",
+			Name:   "taskdbgo",
+
+			SlowQueryThreshold: Duration(200 * time.Millisecond),
+		},
+		Mail: MailConfig{
+			Sender:   "log",
+			File:     "mail.log",
+			SMTPPort: 587,
+		},
+		Workers: WorkersConfig{
+			OverdueSweepInterval: Duration(5 * time.Minute),
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+	}
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		// An empty file decodes to io.EOF; it simply sets nothing.
+		if err := decoder.Decode(c); err != nil && len(bytes.TrimSpace(data)) > 0 {
+			return fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(c); err != nil {
+			return fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// loadEnv overrides settings with the environment variables that are set
+// to a non-empty value.
+func (c *Config) loadEnv(problems *[]string) {
+	for _, env := range []struct {
+		name   string
+		target *string
+	}{
+		{"APP_ENV", &c.Env},
+		{"APP_BASE_URL", &c.Server.BaseURL},
+		{"DB_DRIVER", &c.Database.Driver},
+		{"DB_DSN", &c.Database.DSN},
+		{"DB_HOST", &c.Database.Host},
+		{"DB_USER", &c.Database.User},
+		{"DB_PASSWORD", &c.Database.Password},
+		{"DB_NAME", &c.Database.Name},
+		{"DB_SSLMODE", &c.Database.SSLMode},
+		{"JWT_KEYS_DIR", &c.Auth.JWTKeysDir},
+		{"JWT_ACTIVE_KID", &c.Auth.JWTActiveKID},
+		{"OIDC_ISSUER", &c.OIDC.Issuer},
+		{"OIDC_CLIENT_ID", &c.OIDC.ClientID},
+		{"OIDC_CLIENT_SECRET", &c.OIDC.ClientSecret},
+		{"OIDC_REDIRECT_URL", &c.OIDC.RedirectURL},
+		{"OIDC_GROUPS_CLAIM", &c.OIDC.GroupsClaim},
+		{"MAIL_SENDER", &c.Mail.Sender},
+		{"MAIL_FILE", &c.Mail.File},
+		{"MAIL_FROM", &c.Mail.From},
+		{"SMTP_HOST", &c.Mail.SMTPHost},
+		{"SMTP_USERNAME", &c.Mail.SMTPUsername},
+		{"SMTP_PASSWORD", &c.Mail.SMTPPassword},
+		{"LOG_LEVEL", &c.Log.Level},
+	} {
+		if value := os.Getenv(env.name); value != "" {
+			*env.target = value
+		}
+	}
+
+	for _, env := range []struct {
+		name   string
+		target *int
+	}{
+		{"PORT", &c.Server.Port},
+		{"DB_PORT", &c.Database.Port},
+		{"SMTP_PORT", &c.Mail.SMTPPort},
+	} {
+		if value := os.Getenv(env.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				*problems = append(*problems, fmt.Sprintf("%s: %q is not a number", env.name, value))
+				continue
+			}
+			*env.target = n
+		}
+	}
+
+	for _, env := range []struct {
+		name   string
+		target *[]string
+	}{
+		{"TRUSTED_PROXIES", &c.Server.TrustedProxies},
+		{"OIDC_SCOPES", &c.OIDC.Scopes},
+		{"OIDC_ADMIN_GROUPS", &c.OIDC.AdminGroups},
+		{"OIDC_MANAGER_GROUPS", &c.OIDC.ManagerGroups},
+	} {
+		if value := os.Getenv(env.name); value != "" {
+			*env.target = splitList(value)
+		}
+	}
+
+	for _, env := range []struct {
+		name   string
+		target *Duration
+	}{
+		{"HTTP_READ_HEADER_TIMEOUT", &c.Server.ReadHeaderTimeout},
+		{"HTTP_READ_TIMEOUT", &c.Server.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", &c.Server.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", &c.Server.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout},
+		{"OVERDUE_SWEEP_INTERVAL", &c.Workers.OverdueSweepInterval},
+		{"DB_SLOW_QUERY_THRESHOLD", &c.Database.SlowQueryThreshold},
+	} {
+		if value := os.Getenv(env.name); value != "" {
+			if err := env.target.UnmarshalText([]byte(value)); err != nil {
+				*problems = append(*problems, fmt.Sprintf("%s: %q is not a duration such as 30s or 5m", env.name, value))
+			}
+		}
+	}
+
+	if value := os.Getenv("REQUIRE_EMAIL_VERIFICATION"); value != "" {
+		required, err := strconv.ParseBool(value)
+		if err != nil {
+			*problems = append(*problems, fmt.Sprintf("REQUIRE_EMAIL_VERIFICATION: %q is not a boolean", value))
+		}
+		c.Auth.RequireEmailVerification = required
+	}
+}
+
+// applyModeDefaults fills in defaults that depend on other settings or that
+// a config file may have emptied: the port of the database driver, the OIDC
+// scopes and, in development only, the development database credentials.
+func (c *Config) applyModeDefaults() {
+	c.Env = strings.ToLower(c.Env)
+	c.Database.Driver = strings.ToLower(c.Database.Driver)
+
+	if c.Database.Port == 0 {
+		switch c.Database.Driver {
+		case DriverMySQL:
+			c.Database.Port = 3306
+		case DriverPostgres:
+			c.Database.Port = 5432
+		}
+	}
+	if len(c.OIDC.Scopes) == 0 {
+		c.OIDC.Scopes = []string{"openid", "profile", "email"}
+	}
+	if c.OIDC.GroupsClaim == "" {
+		c.OIDC.GroupsClaim = "groups"
+	}
+	if c.Database.SSLMode == "" {
+		c.Database.SSLMode = "disable"
+		if c.Env == EnvProduction {
+			c.Database.SSLMode = "require"
+		}
+	}
+	if c.Env == EnvDevelopment {
+		if c.Server.BaseURL == "" {
+			c.Server.BaseURL = "http://localhost:" + strconv.Itoa(c.Server.Port)
+		}
+		if c.Database.User == "" {
+			c.Database.User = devDBUser
+		}
+		if c.Database.Password == "" {
+			c.Database.Password = devDBPassword
+		}
+	}
+}
+
+func (c *Config) validate(problems *[]string) {
+	add := func(format string, args ...any) {
+		*problems = append(*problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		add("env (APP_ENV): %q is not %q or %q", c.Env, EnvDevelopment, EnvProduction)
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		add("server.port (PORT): %d is not a valid port", c.Server.Port)
+	}
+	if c.Server.BaseURL != "" {
+		if u, err := url.Parse(c.Server.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("server.base_url (APP_BASE_URL): %q is not an absolute http(s) URL", c.Server.BaseURL)
+		}
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			add("server.trusted_proxies (TRUSTED_PROXIES): %q is not an IP address or CIDR range", proxy)
+		}
+	}
+
+	for _, timeout := range []struct {
+		key   string
+		value Duration
+	}{
+		{"server.read_header_timeout (HTTP_READ_HEADER_TIMEOUT)", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout (HTTP_READ_TIMEOUT)", c.Server.ReadTimeout},
+		{"server.write_timeout (HTTP_WRITE_TIMEOUT)", c.Server.WriteTimeout},
+		{"server.idle_timeout (HTTP_IDLE_TIMEOUT)", c.Server.IdleTimeout},
+		{"workers.overdue_sweep_interval (OVERDUE_SWEEP_INTERVAL)", c.Workers.OverdueSweepInterval},
+		{"database.slow_query_threshold (DB_SLOW_QUERY_THRESHOLD)", c.Database.SlowQueryThreshold},
+	} {
+		if timeout.value < 0 {
+			add("%s: must not be negative", timeout.key)
+		}
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		add("server.shutdown_timeout (SHUTDOWN_TIMEOUT): must be positive")
+	}
+
+	switch c.Database.Driver {
+	case DriverMySQL, DriverPostgres, DriverSQLite:
+	default:
+		add("database.driver (DB_DRIVER): unsupported driver %q, want mysql, postgres or sqlite", c.Database.Driver)
+	}
+	if c.Database.DSN == "" {
+		if c.Database.Name == "" {
+			add("database.name (DB_NAME) is required")
+		}
+		if !slices.Contains(postgresSSLModes, c.Database.SSLMode) {
+			add("database.sslmode (DB_SSLMODE): %q is not one of %s", c.Database.SSLMode, strings.Join(postgresSSLModes, ", "))
+		}
+		if c.Database.Driver != DriverSQLite && (c.Database.Port < 1 || c.Database.Port > 65535) {
+			add("database.port (DB_PORT): %d is not a valid port", c.Database.Port)
+		}
+	}
+
+	switch c.Mail.Sender {
+	case "log":
+	case "file":
+		if c.Mail.File == "" {
+			add("mail.file (MAIL_FILE) is required when mail.sender is \"file\"")
+		}
+	case "smtp":
+		if c.Mail.SMTPHost == "" {
+			add("mail.smtp_host (SMTP_HOST) is required when mail.sender is \"smtp\"")
+		}
+		if c.Mail.From == "" {
+			add("mail.from (MAIL_FROM) is required when mail.sender is \"smtp\"")
+		}
+		if c.Mail.SMTPPort < 1 || c.Mail.SMTPPort > 65535 {
+			add("mail.smtp_port (SMTP_PORT): %d is not a valid port", c.Mail.SMTPPort)
+		}
+	default:
+		add("mail.sender (MAIL_SENDER): unsupported sender %q, want log, file or smtp", c.Mail.Sender)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		add("log.level (LOG_LEVEL): unsupported level %q, want debug, info, warn or error", c.Log.Level)
+	}
+
+	if c.OIDC.Issuer != "" {
+		if c.OIDC.ClientID == "" {
+			add("oidc.client_id (OIDC_CLIENT_ID) is required when oidc.issuer is set")
+		}
+		if c.OIDC.RedirectURL == "" {
+			add("oidc.redirect_url (OIDC_REDIRECT_URL) is required when oidc.issuer is set")
+		}
+	}
+
+	if c.Env == EnvProduction {
+		c.validateProduction(add)
+	}
+}
+
+// validateProduction rejects settings that are convenient locally but
+// insecure once deployed.
+func (c *Config) validateProduction(add func(format string, args ...any)) {
+	if c.Database.DSN == "" && c.Database.Driver != DriverSQLite {
+		if c.Database.User == "" {
+			add("database.user (DB_USER) must be set in production")
+		}
+		switch {
+		case c.Database.Password == "":
+			add("database.password (DB_PASSWORD) must be set in production")
+		case slices.Contains(insecureDBPasswords, c.Database.Password):
+			add("database.password (DB_PASSWORD) is a well-known default and is not allowed in production")
+		}
+	}
+	if c.Auth.JWTKeysDir == "" {
+		add("auth.jwt_keys_dir (JWT_KEYS_DIR) must be set in production; tokens would be signed with an ephemeral key")
+	}
+	if c.Server.BaseURL == "" {
+		add("server.base_url (APP_BASE_URL) must be set in production; links in emails are built from it")
+	}
+	if c.Mail.Sender == "log" {
+		add("mail.sender (MAIL_SENDER) \"log\" writes tokens to the log and is not allowed in production")
+	}
+}
@@ -0,0 +1,248 @@
+package config_test
+
+import (
+	"errors"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"taskmanager/config"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// clearEnv blanks every variable Load reads, so the tests see only what they
+// set themselves. Empty variables count as unset.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{
+		"CONFIG_FILE", "APP_ENV", "APP_BASE_URL", "PORT", "TRUSTED_PROXIES",
+		"DB_DRIVER", "DB_DSN", "DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME", "DB_SSLMODE",
+		"JWT_KEYS_DIR", "JWT_ACTIVE_KID", "REQUIRE_EMAIL_VERIFICATION",
+		"OIDC_ISSUER", "OIDC_CLIENT_ID", "OIDC_CLIENT_SECRET", "OIDC_REDIRECT_URL",
+		"OIDC_SCOPES", "OIDC_GROUPS_CLAIM", "OIDC_ADMIN_GROUPS", "OIDC_MANAGER_GROUPS",
+		"MAIL_SENDER", "MAIL_FILE", "MAIL_FROM", "SMTP_HOST", "SMTP_PORT", "SMTP_USERNAME", "SMTP_PASSWORD",
+		"HTTP_READ_HEADER_TIMEOUT", "HTTP_READ_TIMEOUT", "HTTP_WRITE_TIMEOUT", "HTTP_IDLE_TIMEOUT",
+		"SHUTDOWN_TIMEOUT", "OVERDUE_SWEEP_INTERVAL", "DB_SLOW_QUERY_THRESHOLD", "LOG_LEVEL",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+// wantProblems asserts that err is a ValidationError mentioning each of
+// the given settings.
+func wantProblems(t *testing.T, err error, settings ...string) {
+	t.Helper()
+	var invalid *config.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	for _, setting := range settings {
+		if !strings.Contains(err.Error(), setting) {
+			t.Fatalf("expected a problem with %s, got:\n%v", setting, err)
+		}
+	}
+}
+
+func TestLoad_DevelopmentDefaults(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Production() || cfg.Server.Port != 8000 || cfg.Server.BaseURL != "http://localhost:8000" || cfg.Mail.Sender != "log" {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+	db := cfg.Database
+	if db.Driver != config.DriverMySQL || db.Port != 3306 || db.User != "admin" || db.Password != "12345678" || db.SSLMode != "disable" {
+		t.Fatalf("expected the development database defaults, got %+v", db)
+	}
+	if len(cfg.OIDC.Scopes) != 3 || cfg.OIDC.GroupsClaim != "groups" || cfg.OIDC.Enabled() {
+		t.Fatalf("unexpected OIDC defaults: %+v", cfg.OIDC)
+	}
+	if time.Duration(cfg.Server.ShutdownTimeout) != 30*time.Second || time.Duration(cfg.Workers.OverdueSweepInterval) != 5*time.Minute {
+		t.Fatalf("unexpected timing defaults: %+v %+v", cfg.Server, cfg.Workers)
+	}
+	if cfg.Log.SlogLevel() != slog.LevelInfo || time.Duration(cfg.Database.SlowQueryThreshold) != 200*time.Millisecond {
+		t.Fatalf("unexpected logging defaults: %+v %+v", cfg.Log, cfg.Database)
+	}
+}
+
+func TestLoad_FileAndEnvironment(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CONFIG_FILE", writeFile(t, "config.yaml", `
+server:
+  port: 9000
+  base_url: https://tasks.example.com
+  write_timeout: 1m
+database:
+  driver: postgres
+  user: tasks
+  password: from-file
+auth:
+  require_email_verification: true
+oidc:
+  issuer: https://idp.example.com
+  client_id: taskmanager
+  redirect_url: https://tasks.example.com/auth/oidc/callback
+  admin_groups: [it-admins]
+`))
+	// The environment wins over the file.
+	t.Setenv("DB_PASSWORD", "from-env")
+	t.Setenv("OVERDUE_SWEEP_INTERVAL", "0s")
+	t.Setenv("LOG_LEVEL", "debug")
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Server.Port != 9000 || cfg.Server.BaseURL != "https://tasks.example.com" || !cfg.Auth.RequireEmailVerification {
+		t.Fatalf("expected the file to be applied, got %+v", cfg)
+	}
+	if time.Duration(cfg.Server.WriteTimeout) != time.Minute || cfg.Workers.OverdueSweepInterval != 0 || cfg.Log.SlogLevel() != slog.LevelDebug {
+		t.Fatalf("unexpected durations: %+v %+v", cfg.Server, cfg.Workers)
+	}
+	if cfg.Database.Port != 5432 || cfg.Database.User != "tasks" || cfg.Database.Password != "from-env" {
+		t.Fatalf("unexpected database settings: %+v", cfg.Database)
+	}
+	if !cfg.OIDC.Enabled() || !cfg.OIDC.MapsGroups() || len(cfg.OIDC.Scopes) != 3 {
+		t.Fatalf("unexpected OIDC settings: %+v", cfg.OIDC)
+	}
+
+	t.Setenv("DB_PASSWORD", "")
+	t.Setenv("CONFIG_FILE", writeFile(t, "config.toml", `
+env = "development"
+
+[database]
+driver = "sqlite"
+name = "tasks.db"
+
+[mail]
+sender = "file"
+file = "/tmp/mail.log"
+
+[server]
+shutdown_timeout = "10s"
+`))
+	cfg, err = config.Load()
+	if err != nil {
+		t.Fatalf("load toml: %v", err)
+	}
+	if cfg.Database.Driver != config.DriverSQLite || cfg.Database.Name != "tasks.db" || cfg.Mail.File != "/tmp/mail.log" || time.Duration(cfg.Server.ShutdownTimeout) != 10*time.Second {
+		t.Fatalf("expected the TOML file to be applied, got %+v", cfg)
+	}
+
+	// Typos in a file are reported rather than ignored.
+	t.Setenv("CONFIG_FILE", writeFile(t, "typo.yaml", "server:\n  prot: 9000\n"))
+	if _, err := config.Load(); err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Fatalf("expected an unknown key to be rejected, got %v", err)
+	}
+	t.Setenv("CONFIG_FILE", writeFile(t, "config.json", "{}"))
+	if _, err := config.Load(); err == nil {
+		t.Fatalf("expected an unsupported file format to be rejected")
+	}
+}
+
+func TestLoad_Validation(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("PORT", "http")
+	t.Setenv("DB_DRIVER", "oracle")
+	t.Setenv("MAIL_SENDER", "smtp")
+	t.Setenv("OIDC_ISSUER", "https://idp.example.com")
+	t.Setenv("APP_BASE_URL", "tasks.example.com")
+	t.Setenv("HTTP_READ_TIMEOUT", "soon")
+	t.Setenv("SHUTDOWN_TIMEOUT", "0s")
+	t.Setenv("LOG_LEVEL", "verbose")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, proxy.internal")
+
+	_, err := config.Load()
+	wantProblems(t, err, "PORT", "DB_DRIVER", "SMTP_HOST", "MAIL_FROM", "OIDC_CLIENT_ID", "APP_BASE_URL", "HTTP_READ_TIMEOUT", "SHUTDOWN_TIMEOUT", "LOG_LEVEL", "TRUSTED_PROXIES")
+}
+
+func TestLoad_ProductionForbidsInsecureDefaults(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("APP_ENV", "production")
+
+	_, err := config.Load()
+	wantProblems(t, err, "DB_USER", "DB_PASSWORD", "JWT_KEYS_DIR", "APP_BASE_URL", "MAIL_SENDER")
+
+	t.Setenv("DB_USER", "admin")
+	t.Setenv("DB_PASSWORD", "12345678")
+	_, err = config.Load()
+	wantProblems(t, err, "well-known default")
+
+	t.Setenv("DB_PASSWORD", "s3cret-and-long")
+	t.Setenv("JWT_KEYS_DIR", "/etc/taskmanager/keys")
+	t.Setenv("APP_BASE_URL", "https://tasks.example.com")
+	t.Setenv("MAIL_SENDER", "smtp")
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("MAIL_FROM", "tasks@example.com")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("expected a complete production configuration to load, got %v", err)
+	}
+	if !cfg.Production() {
+		t.Fatalf("expected production mode")
+	}
+	if cfg.Database.SSLMode != "require" {
+		t.Fatalf("expected production to require TLS to the database, got sslmode %q", cfg.Database.SSLMode)
+	}
+
+	t.Setenv("DB_SSLMODE", "verify-full")
+	if cfg, err = config.Load(); err != nil || cfg.Database.SSLMode != "verify-full" {
+		t.Fatalf("expected DB_SSLMODE to be used, got %v %+v", err, cfg)
+	}
+	t.Setenv("DB_SSLMODE", "always")
+	_, err = config.Load()
+	wantProblems(t, err, "DB_SSLMODE")
+}
+
+func TestDatabaseConfig_ConnectionStringEscapesFields(t *testing.T) {
+	base := config.DatabaseConfig{Host: "db.internal", Port: 5432, User: "app@team", Password: `p@ss:w/o'rd\ ?#&`, Name: "tasks"}
+
+	pg := base
+	pg.Driver = config.DriverPostgres
+	u, err := url.Parse(pg.ConnectionString())
+	if err != nil {
+		t.Fatalf("parse postgres DSN %q: %v", pg.ConnectionString(), err)
+	}
+	password, _ := u.User.Password()
+	if u.User.Username() != base.User || password != base.Password || u.Host != "db.internal:5432" || u.Path != "/tasks" {
+		t.Fatalf("postgres DSN lost a field: %q", pg.ConnectionString())
+	}
+	pg.SSLMode = "verify-full"
+	if u, err := url.Parse(pg.ConnectionString()); err != nil || u.Query().Get("sslmode") != "verify-full" {
+		t.Fatalf("postgres DSN does not carry the sslmode: %q", pg.ConnectionString())
+	}
+
+	my := base
+	my.Driver = config.DriverMySQL
+	my.Port = 3306
+	parsed, err := mysql.ParseDSN(my.ConnectionString())
+	if err != nil {
+		t.Fatalf("parse mysql DSN %q: %v", my.ConnectionString(), err)
+	}
+	if parsed.User != base.User || parsed.Passwd != base.Password || parsed.Addr != "db.internal:3306" || parsed.DBName != "tasks" || !parsed.ParseTime {
+		t.Fatalf("mysql DSN lost a field: %q", my.ConnectionString())
+	}
+
+	explicit := my
+	explicit.DSN = "user:pass@tcp(other:3306)/db"
+	if explicit.ConnectionString() != explicit.DSN {
+		t.Fatalf("expected DB_DSN to be used as is, got %q", explicit.ConnectionString())
+	}
+}
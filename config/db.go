@@ -2,48 +2,98 @@ package config
 
 import (
 	"fmt"
-	"os"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"taskmanager/logging"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-func ConnectDB() *gorm.DB {
-	dsn := os.Getenv("DB_DSN")
-	if dsn == "" {
-		host := os.Getenv("DB_HOST")
-		if host == "" {
-			host = "127.0.0.1"
-		}
-		port := os.Getenv("DB_PORT")
-		if port == "" {
-			port = "3306"
-		}
-		user := os.Getenv("DB_USER")
-		if user == "" {
-			user = "admin"
-		}
-		password := os.Getenv("DB_PASSWORD")
-		if password == "" {
-			password = "12345678"
-		}
-		dbName := os.Getenv("DB_NAME")
-		if dbName == "" {
-			dbName = "taskdbgo"
-		}
+// Supported database drivers.
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// ConnectDB opens the database described by cfg. Queries are logged through
+// package logging.
+func ConnectDB(cfg DatabaseConfig) (*gorm.DB, error) {
+	dsn := cfg.ConnectionString()
 
-		dsn = fmt.Sprintf(
-			"%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-			user,
-			password,
-			host,
-			port,
-			dbName,
-		)
+	var dialector gorm.Dialector
+	switch cfg.Driver {
+	case DriverMySQL:
+		dialector = mysql.Open(dsn)
+	case DriverPostgres:
+		dialector = postgres.Open(dsn)
+	case DriverSQLite:
+		dialector = sqlite.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q (want mysql, postgres or sqlite)", cfg.Driver)
 	}
 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	// TranslateError turns driver-specific constraint violations into
+	// gorm.ErrDuplicatedKey and friends, so callers need not know the driver.
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger:         logging.NewGormLogger(time.Duration(cfg.SlowQueryThreshold)),
+		TranslateError: true,
+	})
 	if err != nil {
-		panic("Failed to connect to database")
+		return nil, fmt.Errorf("connect to %s database: %w", cfg.Driver, err)
+	}
+	return db, nil
+}
+
+// ConnectionString returns DSN when it is set, and otherwise builds one for
+// the driver from the separate fields, escaping them so that passwords and
+// names may contain any character. For sqlite ".db" is appended to the file
+// name when it has no extension.
+func (c DatabaseConfig) ConnectionString() string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+
+	switch c.Driver {
+	case DriverMySQL:
+		mc := mysqldriver.NewConfig()
+		mc.User = c.User
+		mc.Passwd = c.Password
+		mc.Net = "tcp"
+		mc.Addr = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+		mc.DBName = c.Name
+		mc.ParseTime = true
+		mc.Loc = time.Local
+		mc.Params = map[string]string{"charset": "utf8mb4"}
+		return mc.FormatDSN()
+	case DriverPostgres:
+		u := url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(c.User, c.Password),
+			Host:   net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+			Path:   "/" + c.Name,
+		}
+		if c.SSLMode != "" {
+			u.RawQuery = url.Values{"sslmode": {c.SSLMode}}.Encode()
+		}
+		return u.String()
+	case DriverSQLite:
+		path := c.Name
+		if !strings.Contains(path, ".") {
+			path += ".db"
+		}
+		// Foreign keys are off by default in SQLite. Taking the write lock
+		// at BEGIN, and waiting for it, makes concurrent transactions queue
+		// like row locks do elsewhere instead of failing with "database is
+		// locked".
+		return "file:" + path + "?_foreign_keys=on&_busy_timeout=5000&_txlock=immediate&_journal_mode=WAL"
 	}
-	return db
+	return ""
 }
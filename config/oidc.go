@@ -0,0 +1,39 @@
+package config
+
+import "strings"
+
+// OIDCConfig configures single sign-on against an OpenID Connect provider.
+type OIDCConfig struct {
+	Issuer       string   `yaml:"issuer" toml:"issuer"`
+	ClientID     string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret string   `yaml:"client_secret" toml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url" toml:"redirect_url"`
+	Scopes       []string `yaml:"scopes" toml:"scopes"`
+
+	// GroupsClaim names the ID token claim that lists the user's groups.
+	GroupsClaim string `yaml:"groups_claim" toml:"groups_claim"`
+	// Members of these IdP groups get the admin or manager role. Everyone
+	// else is a member. Roles are only synced when at least one is set.
+	AdminGroups   []string `yaml:"admin_groups" toml:"admin_groups"`
+	ManagerGroups []string `yaml:"manager_groups" toml:"manager_groups"`
+}
+
+// Enabled reports whether enough settings are present to offer SSO.
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != "" && c.ClientID != ""
+}
+
+// MapsGroups reports whether IdP groups should drive user roles.
+func (c OIDCConfig) MapsGroups() bool {
+	return len(c.AdminGroups) > 0 || len(c.ManagerGroups) > 0
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
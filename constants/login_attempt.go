@@ -0,0 +1,12 @@
+package constants
+
+const (
+	LoginResultSuccess            = "success"
+	LoginResultInvalidCredentials = "invalid_credentials"
+	LoginResultInvalidTwoFactor   = "invalid_2fa"
+	LoginResultUnknownEmail       = "unknown_email"
+	LoginResultLocked             = "locked"
+	LoginResultThrottled          = "throttled"
+	LoginResultDeactivated        = "deactivated"
+	LoginResultUnverified         = "unverified"
+)
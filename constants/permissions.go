@@ -0,0 +1,29 @@
+package constants
+
+// Permissions granted to roles. Handlers never check role names; they ask
+// authz whether the caller's role carries the permission they need.
+const (
+	PermTaskCreate            = "task.create"
+	PermTaskViewAll           = "task.view_all"
+	PermTaskViewReports       = "task.view_reports"
+	PermTaskEdit              = "task.edit"
+	PermTaskAssignAny         = "task.assign_any"
+	PermTaskAssignReports     = "task.assign_reports"
+	PermTaskApprove           = "task.approve"
+	PermTaskExtendDeadline    = "task.extend_deadline"
+	PermTaskExtendAnyDeadline = "task.extend_any_deadline"
+	PermTaskRequestExtension  = "task.request_extension"
+	PermTaskDelete            = "task.delete"
+	PermUserManage            = "user.manage"
+	PermRoleManage            = "role.manage"
+	PermTeamManage            = "team.manage"
+	PermMetricsRead           = "metrics.read"
+)
+
+// Actions that depend on the task they are performed on. They are checked
+// with authz.Can together with the permissions above.
+const (
+	ActionTaskView   = "task.view"
+	ActionTaskUpdate = "task.update"
+	ActionTaskClaim  = "task.claim"
+)
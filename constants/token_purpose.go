@@ -0,0 +1,10 @@
+package constants
+
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+
+	// Purposes of the short-lived login challenge JWTs.
+	TokenPurposeTwoFactorLogin  = "2fa_login"
+	TokenPurposeTwoFactorEnroll = "2fa_enroll"
+)
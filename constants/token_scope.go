@@ -0,0 +1,13 @@
+package constants
+
+// Scopes that can be granted to personal access tokens. Read scopes cover
+// GET requests, write scopes everything else on the same routes.
+const (
+	ScopeTasksRead  = "tasks:read"
+	ScopeTasksWrite = "tasks:write"
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	// ScopeMetricsRead lets a scraper read /metrics, which has no write
+	// counterpart.
+	ScopeMetricsRead = "metrics:read"
+)
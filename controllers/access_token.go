@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"taskmanager/constants"
+	"taskmanager/logging"
+	"taskmanager/models"
+	"taskmanager/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxAccessTokenLifetimeDays caps expires_in_days. Tokens without an expiry
+// live until they are revoked.
+const maxAccessTokenLifetimeDays = 3650
+
+var accessTokenScopes = []string{
+	constants.ScopeTasksRead,
+	constants.ScopeTasksWrite,
+	constants.ScopeUsersRead,
+	constants.ScopeUsersWrite,
+	constants.ScopeMetricsRead,
+}
+
+type AccessTokenController struct {
+	DB *gorm.DB
+}
+
+type createAccessTokenInput struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays *int     `json:"expires_in_days"`
+}
+
+type createServiceAccountInput struct {
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	ManagerID *uint  `json:"manager_id"`
+}
+
+// GetAccessTokens lists the caller's active personal access tokens.
+func (atc *AccessTokenController) GetAccessTokens(c *gin.Context) {
+	atc.listTokens(c, uint(c.GetFloat64("user_id")))
+}
+
+// CreateAccessToken issues a personal access token for the caller. The token
+// is only returned once.
+func (atc *AccessTokenController) CreateAccessToken(c *gin.Context) {
+	userID := uint(c.GetFloat64("user_id"))
+	atc.createToken(c, userID, userID)
+}
+
+// RevokeAccessToken revokes one of the caller's tokens.
+func (atc *AccessTokenController) RevokeAccessToken(c *gin.Context) {
+	atc.revokeToken(c, uint(c.GetFloat64("user_id")), c.Param("id"))
+}
+
+// GetServiceAccounts lists all service accounts.
+func (atc *AccessTokenController) GetServiceAccounts(c *gin.Context) {
+	var accounts []models.User
+	if err := logging.RequestDB(c, atc.DB).Where("service_account = ?", true).Order("id").Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load service accounts"})
+		return
+	}
+	c.JSON(http.StatusOK, accounts)
+}
+
+// CreateServiceAccount creates a non-human user. Service accounts have no
+// password and can only authenticate with access tokens issued by an admin.
+func (atc *AccessTokenController) CreateServiceAccount(c *gin.Context) {
+	var input createServiceAccountInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	if !checkGrantableRole(c, logging.RequestDB(c, atc.DB), input.Role, "Failed to create service account") {
+		return
+	}
+	if input.ManagerID != nil {
+		var manager models.User
+		if err := logging.RequestDB(c, atc.DB).First(&manager, *input.ManagerID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Manager not found"})
+			return
+		}
+	}
+
+	// The email column is unique and required, so give the account a
+	// placeholder address that can never receive mail.
+	_, suffix, err := utils.GenerateToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+		return
+	}
+
+	now := time.Now()
+	account := models.User{
+		Name:            name,
+		Email:           "svc-" + suffix[:16] + "@service-accounts.invalid",
+		Role:            input.Role,
+		ManagerID:       input.ManagerID,
+		ServiceAccount:  true,
+		EmailVerifiedAt: &now,
+	}
+	if err := logging.RequestDB(c, atc.DB).Create(&account).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+		return
+	}
+	utils.InvalidateReportCache()
+
+	c.JSON(http.StatusCreated, account)
+}
+
+// GetServiceAccountTokens lists the active tokens of a service account.
+func (atc *AccessTokenController) GetServiceAccountTokens(c *gin.Context) {
+	account, ok := atc.findServiceAccount(c)
+	if !ok {
+		return
+	}
+	atc.listTokens(c, account.ID)
+}
+
+// CreateServiceAccountToken issues a token for a service account.
+func (atc *AccessTokenController) CreateServiceAccountToken(c *gin.Context) {
+	account, ok := atc.findServiceAccount(c)
+	if !ok {
+		return
+	}
+	atc.createToken(c, account.ID, uint(c.GetFloat64("user_id")))
+}
+
+// RevokeServiceAccountToken revokes a token of a service account.
+func (atc *AccessTokenController) RevokeServiceAccountToken(c *gin.Context) {
+	account, ok := atc.findServiceAccount(c)
+	if !ok {
+		return
+	}
+	atc.revokeToken(c, account.ID, c.Param("token_id"))
+}
+
+func (atc *AccessTokenController) findServiceAccount(c *gin.Context) (models.User, bool) {
+	var account models.User
+	err := logging.RequestDB(c, atc.DB).Where("service_account = ?", true).First(&account, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+		return account, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load service account"})
+		return account, false
+	}
+	return account, true
+}
+
+func (atc *AccessTokenController) listTokens(c *gin.Context, ownerID uint) {
+	var tokens []models.AccessToken
+	if err := logging.RequestDB(c, atc.DB).
+		Where("user_id = ? AND revoked_at IS NULL", ownerID).
+		Order("id").
+		Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (atc *AccessTokenController) createToken(c *gin.Context, ownerID, creatorID uint) {
+	var input createAccessTokenInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" || len(name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required and must be at most 100 characters"})
+		return
+	}
+	if len(input.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+		return
+	}
+	for _, scope := range input.Scopes {
+		if !slices.Contains(accessTokenScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + scope})
+			return
+		}
+	}
+
+	var expiresAt *time.Time
+	if input.ExpiresInDays != nil {
+		days := *input.ExpiresInDays
+		if days < 1 || days > maxAccessTokenLifetimeDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days must be between 1 and " + strconv.Itoa(maxAccessTokenLifetimeDays)})
+			return
+		}
+		expiry := time.Now().AddDate(0, 0, days)
+		expiresAt = &expiry
+	}
+
+	token, hash, err := utils.GenerateAccessToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	slices.Sort(input.Scopes)
+	accessToken := models.AccessToken{
+		UserID:      ownerID,
+		Name:        name,
+		TokenPrefix: token[:len(utils.AccessTokenPrefix)+4],
+		TokenHash:   hash,
+		Scopes:      strings.Join(slices.Compact(input.Scopes), " "),
+		CreatedByID: creatorID,
+		ExpiresAt:   expiresAt,
+	}
+	if err := logging.RequestDB(c, atc.DB).Create(&accessToken).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":        token,
+		"access_token": accessToken,
+	})
+}
+
+func (atc *AccessTokenController) revokeToken(c *gin.Context, ownerID uint, tokenID string) {
+	result := logging.RequestDB(c, atc.DB).Model(&models.AccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, ownerID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
+
+// revokeAccessTokens revokes every active personal access token of a user.
+func revokeAccessTokens(db *gorm.DB, userID uint) error {
+	return db.Model(&models.AccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -1,30 +1,104 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"taskmanager/constants"
+	"taskmanager/logging"
+	"taskmanager/mail"
 	"taskmanager/models"
 	"taskmanager/utils"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+const emailVerificationTTL = 24 * time.Hour
+
 type AuthController struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	Mailer  mail.Sender
+	BaseURL string
+	// RequireEmailVerification blocks logins until the email is verified.
+	RequireEmailVerification bool
+}
+
+type registerInput struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
 }
 
+type loginInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Register creates a self-service account. The role is always member and the
+// manager is left unset; admins promote users or send invitations instead.
 func (ac *AuthController) Register(c *gin.Context) {
-	var user models.User
+	var input registerInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if err := c.BindJSON(&user); err != nil {
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	email, err := utils.NormalizeEmail(input.Email)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := utils.ValidatePassword(input.Password); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	hashed, _ := utils.HashPassword(user.Password)
-	user.Password = hashed
+	taken, err := emailTaken(logging.RequestDB(c, ac.DB), email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+		return
+	}
 
-	ac.DB.Create(&user)
+	hashed, err := utils.HashPassword(input.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+		return
+	}
+
+	user := models.User{
+		Name:     name,
+		Email:    email,
+		Password: hashed,
+		Role:     constants.RoleMember,
+	}
+	// The check above is only a shortcut: a concurrent registration can
+	// still take the email first, which the unique index reports here.
+	if err := logging.RequestDB(c, ac.DB).Create(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+		return
+	}
+
+	// The account exists now, so a mail failure is logged rather than
+	// reported as a failed registration.
+	if err := ac.sendVerificationEmail(c, user); err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to send verification email", "user_id", user.ID, "error", err)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "User registered",
@@ -32,7 +106,7 @@ func (ac *AuthController) Register(c *gin.Context) {
 }
 
 func (ac *AuthController) Login(c *gin.Context) {
-	var input models.User
+	var input loginInput
 	var user models.User
 
 	if err := c.BindJSON(&input); err != nil {
@@ -40,22 +114,240 @@ func (ac *AuthController) Login(c *gin.Context) {
 		return
 	}
 
-	if err := ac.DB.
-		Where("email = ?", input.Email).
+	if !ac.checkIPThrottle(c) {
+		return
+	}
+
+	email := normalizeLoginEmail(input.Email)
+	if err := logging.RequestDB(c, ac.DB).
+		Where("email = ?", email).
 		First(&user).Error; err != nil {
 
+		ac.recordLoginAttempt(c, email, nil, constants.LoginResultUnknownEmail)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
+	if !ac.checkAccountThrottle(c, &user) {
+		return
+	}
+
 	if !utils.CheckPassword(input.Password, user.Password) {
+		ac.registerLoginFailure(c, &user, constants.LoginResultInvalidCredentials)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	token, _ := utils.GenerateJWT(user)
+	if !user.IsActive() {
+		ac.recordLoginAttempt(c, user.Email, &user.ID, constants.LoginResultDeactivated)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is deactivated"})
+		return
+	}
+
+	if user.EmailVerifiedAt == nil && ac.RequireEmailVerification {
+		ac.recordLoginAttempt(c, user.Email, &user.ID, constants.LoginResultUnverified)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address is not verified"})
+		return
+	}
+
+	// The JWT is only issued once the second factor has been checked.
+	if user.HasTwoFactor() {
+		respondTwoFactorChallenge(c, user)
+		return
+	}
+
+	required, err := twoFactorRequired(logging.RequestDB(c, ac.DB), user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor policy"})
+		return
+	}
+	if required {
+		enrollment, err := utils.GenerateChallengeJWT(user, constants.TokenPurposeTwoFactorEnroll)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_enrollment_required": true,
+			"enrollment_token":               enrollment,
+		})
+		return
+	}
 
+	ac.issueSession(c, &user, nil)
+}
+
+// respondTwoFactorChallenge answers a login whose first factor succeeded
+// with a challenge token for POST /login/2fa instead of a session.
+func respondTwoFactorChallenge(c *gin.Context, user models.User) {
+	challenge, err := utils.GenerateChallengeJWT(user, constants.TokenPurposeTwoFactorLogin)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"token": token,
+		"two_factor_required": true,
+		"challenge_token":     challenge,
 	})
 }
+
+// issueSession records a successful login and responds with a JWT for user,
+// merged with any extra fields.
+func (ac *AuthController) issueSession(c *gin.Context, user *models.User, extra gin.H) {
+	ac.registerLoginSuccess(c, user)
+
+	token, err := utils.GenerateJWT(*user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	response := gin.H{"token": token}
+	for key, value := range extra {
+		response[key] = value
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// VerifyEmail consumes the token sent by Register or ResendVerification.
+func (ac *AuthController) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	err := logging.RequestDB(c, ac.DB).Transaction(func(tx *gorm.DB) error {
+		userToken, err := consumeUserToken(tx, token, constants.TokenPurposeEmailVerification)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).
+			Where("id = ? AND email_verified_at IS NULL", userToken.UserID).
+			Update("email_verified_at", time.Now()).Error
+	})
+	if errors.Is(err, errInvalidUserToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// ResendVerification sends a fresh verification link to the caller.
+func (ac *AuthController) ResendVerification(c *gin.Context) {
+	userID := uint(c.GetFloat64("user_id"))
+
+	var user models.User
+	if err := logging.RequestDB(c, ac.DB).First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email address is already verified"})
+		return
+	}
+
+	if err := ac.sendVerificationEmail(c, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+func (ac *AuthController) sendVerificationEmail(c *gin.Context, user models.User) error {
+	token, err := issueUserToken(logging.RequestDB(c, ac.DB), user.ID, constants.TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := publicLink(ac.BaseURL, "/verify-email?token="+token)
+	return ac.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in 24 hours.",
+			user.Name, link,
+		),
+	})
+}
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// issueUserToken stores the hash of a new single-use token and returns the
+// plaintext token. Unused tokens with the same purpose are discarded so only
+// the latest link works.
+func issueUserToken(db *gorm.DB, userID uint, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := utils.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Delete(&models.UserToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	return token, err
+}
+
+// consumeUserToken marks a valid token as used and returns it. It returns
+// errInvalidUserToken for unknown, expired or already used tokens.
+func consumeUserToken(tx *gorm.DB, token, purpose string) (models.UserToken, error) {
+	var userToken models.UserToken
+	if err := tx.Where("token_hash = ? AND purpose = ?", utils.HashToken(token), purpose).
+		First(&userToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return userToken, errInvalidUserToken
+		}
+		return userToken, err
+	}
+
+	now := time.Now()
+	if userToken.UsedAt != nil || now.After(userToken.ExpiresAt) {
+		return userToken, errInvalidUserToken
+	}
+
+	// The conditional update keeps two concurrent requests from both
+	// consuming the same token.
+	result := tx.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", userToken.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return userToken, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return userToken, errInvalidUserToken
+	}
+	userToken.UsedAt = &now
+	return userToken, nil
+}
+
+func emailTaken(db *gorm.DB, email string) (bool, error) {
+	var count int64
+	if err := db.Model(&models.User{}).Where("email = ?", email).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// normalizeLoginEmail lower-cases the email without validating it, so that
+// malformed input simply fails to match an account.
+func normalizeLoginEmail(email string) string {
+	if normalized, err := utils.NormalizeEmail(email); err == nil {
+		return normalized
+	}
+	return email
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"taskmanager/authz"
+	"taskmanager/constants"
+	"taskmanager/logging"
+	"taskmanager/models"
+	"taskmanager/repository"
+	"taskmanager/services"
+	"taskmanager/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CalendarController struct {
+	DB *gorm.DB
+	// BaseURL is the public URL of the API; see publicLink.
+	BaseURL string
+}
+
+// RegenerateFeedToken issues a new calendar feed token for the caller. Any
+// previously issued token stops working immediately.
+func (cc *CalendarController) RegenerateFeedToken(c *gin.Context) {
+	userID := uint(c.GetFloat64("user_id"))
+
+	token, hash, err := utils.GenerateToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate feed token"})
+		return
+	}
+
+	if err := logging.RequestDB(c, cc.DB).Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("calendar_token_hash", hash).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save feed token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":    token,
+		"feed_url": publicLink(cc.BaseURL, "/calendar/feed.ics?token="+token),
+	})
+}
+
+// RevokeFeedToken disables the caller's calendar feed.
+func (cc *CalendarController) RevokeFeedToken(c *gin.Context) {
+	userID := uint(c.GetFloat64("user_id"))
+
+	if err := logging.RequestDB(c, cc.DB).Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("calendar_token_hash", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke feed token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Feed token revoked"})
+}
+
+// Feed serves the deadlines of every task visible to the token owner as an
+// iCalendar document. Tasks are rendered as VEVENTs unless type=todo is
+// requested. The feed is built on every request, so deadline extensions show
+// up on the next refresh with a bumped SEQUENCE.
+func (cc *CalendarController) Feed(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Feed token required"})
+		return
+	}
+
+	var user models.User
+	if err := logging.RequestDB(c, cc.DB).
+		Where("calendar_token_hash = ? AND deactivated_at IS NULL", utils.HashToken(token)).
+		First(&user).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid feed token"})
+		return
+	}
+
+	subject, err := authz.SubjectFor(logging.RequestDB(c, cc.DB), user.ID, user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions"})
+		return
+	}
+	query := repository.ScopeVisibleTasks(logging.RequestDB(c, cc.DB), subject, logging.RequestDB(c, cc.DB).Preload("AuditTrail"))
+
+	var tasks []models.Task
+	if err := query.Where("deadline IS NOT NULL").Order("deadline").Find(&tasks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load tasks"})
+		return
+	}
+
+	todo := c.Query("type") == "todo"
+	entries := make([]utils.ICalEntry, 0, len(tasks))
+	for i := range tasks {
+		entries = append(entries, taskICalEntry(&tasks[i], todo))
+	}
+
+	c.Header("Content-Disposition", `inline; filename="tasks.ics"`)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(utils.BuildICalendar("Task deadlines", entries)))
+}
+
+func taskICalEntry(task *models.Task, todo bool) utils.ICalEntry {
+	services.SetDeadlineStatus(task)
+
+	sequence := 0
+	for _, audit := range task.AuditTrail {
+		if audit.Action == "deadline_extended" {
+			sequence++
+		}
+	}
+
+	lastModified := task.CreatedAt
+	if task.ExtensionApprovedAt != nil && task.ExtensionApprovedAt.After(lastModified) {
+		lastModified = *task.ExtensionApprovedAt
+	}
+
+	description := fmt.Sprintf("Status: %s\nProgress: %d%%", task.Status, task.ProgressPercentage)
+	if task.DeadlineStatus == constants.DeadlineStatusOverdue {
+		description += "\nOverdue"
+	}
+	if task.Description != "" {
+		description = task.Description + "\n\n" + description
+	}
+
+	entry := utils.ICalEntry{
+		UID:             fmt.Sprintf("task-%d@taskmanager", task.ID),
+		Summary:         task.Title,
+		Description:     description,
+		Due:             *task.Deadline,
+		LastModified:    lastModified,
+		Sequence:        sequence,
+		PercentComplete: task.ProgressPercentage,
+		Todo:            todo,
+	}
+
+	if todo {
+		switch task.Status {
+		case constants.TaskStatusApproved:
+			entry.Status = "COMPLETED"
+		case constants.TaskStatusInProgress, constants.TaskStatusPendingApproval, constants.TaskStatusRejected:
+			entry.Status = "IN-PROCESS"
+		default:
+			entry.Status = "NEEDS-ACTION"
+		}
+	} else {
+		entry.Status = "CONFIRMED"
+	}
+
+	return entry
+}
+
+// publicLink returns the link to path under the configured public base URL.
+// Links are never built from the Host or X-Forwarded-* headers: whoever
+// sends the request controls them, and could have a reset or invitation
+// token mailed to a victim point at their own server.
+func publicLink(base, path string) string {
+	return strings.TrimRight(base, "/") + path
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"taskmanager/logging"
+	"taskmanager/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serviceErrorStatus maps service error kinds to HTTP statuses.
+var serviceErrorStatus = map[services.ErrorKind]int{
+	services.KindInvalid:            http.StatusBadRequest,
+	services.KindForbidden:          http.StatusForbidden,
+	services.KindNotFound:           http.StatusNotFound,
+	services.KindConflict:           http.StatusConflict,
+	services.KindPreconditionFailed: http.StatusPreconditionFailed,
+	services.KindInternal:           http.StatusInternalServerError,
+}
+
+// respondServiceError writes the response for an error returned by a
+// service. Errors from elsewhere become a 500 with fallback as message.
+// Internal errors are logged with their cause, which the response leaves
+// out.
+func respondServiceError(c *gin.Context, err error, fallback string) {
+	var serviceErr *services.Error
+	if !errors.As(err, &serviceErr) {
+		logging.FromContext(c.Request.Context()).Error(fallback, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+		return
+	}
+	if serviceErr.Kind == services.KindInternal {
+		logging.FromContext(c.Request.Context()).Error(serviceErr.Message, "error", serviceErr.Err)
+	}
+	c.JSON(serviceErrorStatus[serviceErr.Kind], gin.H{"error": serviceErr.Message})
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"taskmanager/logging"
+	"taskmanager/migrations"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// readinessTimeout bounds the database checks of a readiness probe, which
+// should fail fast rather than pile up behind a stuck database.
+const readinessTimeout = 2 * time.Second
+
+// HealthController answers the probes of load balancers and orchestrators.
+type HealthController struct {
+	DB *gorm.DB
+}
+
+// Live reports that the process is up and serving. It deliberately checks
+// nothing else, so that an unavailable database does not get every
+// instance restarted.
+func (hc *HealthController) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Ready reports whether the instance should receive traffic: the database
+// answers and has exactly the migrations this build expects. Failures are
+// logged rather than returned, the probe being unauthenticated.
+func (hc *HealthController) Ready(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := gin.H{"database": "ok", "migrations": "ok"}
+	ready := true
+
+	db := hc.DB.WithContext(ctx)
+	if err := ping(ctx, db); err != nil {
+		logging.FromContext(ctx).Error("readiness: database unreachable", "error", err)
+		checks["database"] = "unreachable"
+		checks["migrations"] = "unknown"
+		ready = false
+	} else if err := migrations.Check(db); err != nil {
+		logging.FromContext(ctx).Error("readiness: migrations not in sync", "error", err)
+		checks["migrations"] = "mismatch"
+		if errors.Is(err, migrations.ErrPending) {
+			checks["migrations"] = "pending"
+		}
+		ready = false
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": checks})
+}
+
+func ping(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"taskmanager/logging"
+	"taskmanager/mail"
+	"taskmanager/models"
+	"taskmanager/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+type InvitationController struct {
+	DB      *gorm.DB
+	Mailer  mail.Sender
+	BaseURL string
+}
+
+type createInvitationInput struct {
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	ManagerID *uint  `json:"manager_id"`
+}
+
+type acceptInvitationInput struct {
+	Token    string `json:"token"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// CreateInvitation records an invitation and emails the invite link. The link
+// is also returned so the admin can share it through other channels.
+func (ic *InvitationController) CreateInvitation(c *gin.Context) {
+	adminID := uint(c.GetFloat64("user_id"))
+
+	var input createInvitationInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	email, err := utils.NormalizeEmail(input.Email)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkGrantableRole(c, logging.RequestDB(c, ic.DB), input.Role, "Failed to create invitation") {
+		return
+	}
+	if input.ManagerID != nil {
+		var manager models.User
+		if err := logging.RequestDB(c, ic.DB).First(&manager, *input.ManagerID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Manager not found"})
+			return
+		}
+	}
+
+	taken, err := emailTaken(logging.RequestDB(c, ic.DB), email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+		return
+	}
+
+	token, hash, err := utils.GenerateToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	invitation := models.Invitation{
+		Email:       email,
+		Name:        input.Name,
+		Role:        input.Role,
+		ManagerID:   input.ManagerID,
+		TokenHash:   hash,
+		InvitedByID: adminID,
+		ExpiresAt:   time.Now().Add(invitationTTL),
+	}
+	if err := logging.RequestDB(c, ic.DB).Create(&invitation).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	// The invitation is stored by now, so a mail failure is logged and the
+	// link returned for the admin to pass on.
+	inviteURL := publicLink(ic.BaseURL, "/invitations/accept?token="+token)
+	mailSent := true
+	if err := ic.Mailer.Send(mail.Message{
+		To:      email,
+		Subject: "You have been invited to Task Manager",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nYou have been invited to join Task Manager as %s. Accept the invitation here:\n\n%s\n\nThe invitation expires on %s.",
+			input.Name, input.Role, inviteURL, invitation.ExpiresAt.Format(time.RFC1123),
+		),
+	}); err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to send invitation email", "invitation_id", invitation.ID, "error", err)
+		mailSent = false
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"invitation": invitation,
+		"invite_url": inviteURL,
+		"mail_sent":  mailSent,
+	})
+}
+
+// GetInvitations lists invitations that have not been accepted yet.
+func (ic *InvitationController) GetInvitations(c *gin.Context) {
+	var invitations []models.Invitation
+	if err := logging.RequestDB(c, ic.DB).Where("accepted_at IS NULL").Order("created_at DESC").Find(&invitations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load invitations"})
+		return
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+func (ic *InvitationController) RevokeInvitation(c *gin.Context) {
+	result := logging.RequestDB(c, ic.DB).Where("accepted_at IS NULL").Delete(&models.Invitation{}, c.Param("id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+}
+
+// GetInvitation shows the details of a pending invitation so a client can
+// render the sign-up form.
+func (ic *InvitationController) GetInvitation(c *gin.Context) {
+	invitation, err := findPendingInvitation(logging.RequestDB(c, ic.DB), c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found or expired"})
+		return
+	}
+	c.JSON(http.StatusOK, invitation)
+}
+
+// AcceptInvitation creates the invited account. Following the link proves
+// ownership of the address, so the email is marked as verified.
+func (ic *InvitationController) AcceptInvitation(c *gin.Context) {
+	var input acceptInvitationInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := utils.ValidatePassword(input.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashed, err := utils.HashPassword(input.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+
+	var user models.User
+	err = logging.RequestDB(c, ic.DB).Transaction(func(tx *gorm.DB) error {
+		invitation, err := findPendingInvitation(tx, input.Token)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		result := tx.Model(&models.Invitation{}).
+			Where("id = ? AND accepted_at IS NULL", invitation.ID).
+			Update("accepted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidUserToken
+		}
+
+		taken, err := emailTaken(tx, invitation.Email)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errEmailTaken
+		}
+
+		name := strings.TrimSpace(input.Name)
+		if name == "" {
+			name = strings.TrimSpace(invitation.Name)
+		}
+		if name == "" {
+			return errNameRequired
+		}
+
+		user = models.User{
+			Name:            name,
+			Email:           invitation.Email,
+			Password:        hashed,
+			Role:            invitation.Role,
+			ManagerID:       invitation.ManagerID,
+			EmailVerifiedAt: &now,
+		}
+		err = tx.Create(&user).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errEmailTaken
+		}
+		return err
+	})
+	switch {
+	case errors.Is(err, errInvalidUserToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+		return
+	case errors.Is(err, errNameRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	case errors.Is(err, errEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+	utils.InvalidateReportCache()
+
+	c.JSON(http.StatusCreated, user)
+}
+
+var (
+	errEmailTaken   = errors.New("email is already registered")
+	errNameRequired = errors.New("name is required")
+)
+
+func findPendingInvitation(db *gorm.DB, token string) (models.Invitation, error) {
+	var invitation models.Invitation
+	if token == "" {
+		return invitation, errInvalidUserToken
+	}
+	if err := db.Where("token_hash = ? AND accepted_at IS NULL", utils.HashToken(token)).
+		First(&invitation).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return invitation, errInvalidUserToken
+		}
+		return invitation, err
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		return invitation, errInvalidUserToken
+	}
+	return invitation, nil
+}
@@ -0,0 +1,21 @@
+package controllers
+
+import (
+	"net/http"
+	"taskmanager/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys that verify our JWTs so other services can
+// validate tokens without sharing a secret.
+func (ac *AuthController) JWKS(c *gin.Context) {
+	jwks, err := utils.JWKS()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Signing keys are not available"})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"taskmanager/constants"
+	"taskmanager/logging"
+	"taskmanager/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// After this many consecutive failures every further attempt has to wait
+	// for an exponentially growing delay.
+	loginDelayThreshold = 3
+	maxLoginDelay       = 5 * time.Minute
+
+	// Reaching this many consecutive failures locks the account.
+	loginLockoutThreshold = 10
+	loginLockoutDuration  = 15 * time.Minute
+
+	// Failed attempts allowed from a single IP within the window, across all
+	// accounts.
+	ipFailureLimit  = 30
+	ipFailureWindow = 15 * time.Minute
+)
+
+// checkIPThrottle aborts the request when the client IP has too many recent
+// failures. It reports whether the login may proceed.
+func (ac *AuthController) checkIPThrottle(c *gin.Context) bool {
+	var failures int64
+	if err := logging.RequestDB(c, ac.DB).Model(&models.LoginAttempt{}).
+		Where("ip = ? AND success = ? AND reason IN ? AND created_at > ?",
+			c.ClientIP(), false,
+			[]string{
+				constants.LoginResultInvalidCredentials,
+				constants.LoginResultInvalidTwoFactor,
+				constants.LoginResultUnknownEmail,
+			},
+			time.Now().Add(-ipFailureWindow)).
+		Count(&failures).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return false
+	}
+
+	if failures >= ipFailureLimit {
+		setRetryAfter(c, ipFailureWindow)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts from this address"})
+		return false
+	}
+	return true
+}
+
+// checkAccountThrottle enforces the lockout and progressive delay of user. It
+// reports whether the password may be checked.
+func (ac *AuthController) checkAccountThrottle(c *gin.Context, user *models.User) bool {
+	now := time.Now()
+
+	if user.LockedUntil != nil {
+		if now.Before(*user.LockedUntil) {
+			ac.recordLoginAttempt(c, user.Email, &user.ID, constants.LoginResultLocked)
+			setRetryAfter(c, user.LockedUntil.Sub(now))
+			c.JSON(http.StatusLocked, gin.H{"error": "Account is temporarily locked"})
+			return false
+		}
+
+		// The lock expired: start counting from scratch.
+		if err := resetLoginFailures(logging.RequestDB(c, ac.DB), user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+			return false
+		}
+	}
+
+	if user.FailedLoginCount >= loginDelayThreshold && user.LastFailedLoginAt != nil {
+		retryAt := user.LastFailedLoginAt.Add(loginDelay(user.FailedLoginCount))
+		if now.Before(retryAt) {
+			ac.recordLoginAttempt(c, user.Email, &user.ID, constants.LoginResultThrottled)
+			setRetryAfter(c, retryAt.Sub(now))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, retry later"})
+			return false
+		}
+	}
+
+	return true
+}
+
+// registerLoginFailure counts a wrong password or second factor against user
+// and locks the account once the threshold is reached.
+func (ac *AuthController) registerLoginFailure(c *gin.Context, user *models.User, reason string) {
+	now := time.Now()
+	ac.recordLoginAttempt(c, user.Email, &user.ID, reason)
+
+	updates := map[string]any{
+		"failed_login_count":   gorm.Expr("failed_login_count + 1"),
+		"last_failed_login_at": now,
+	}
+	if user.FailedLoginCount+1 >= loginLockoutThreshold {
+		updates["locked_until"] = now.Add(loginLockoutDuration)
+	}
+	if err := logging.RequestDB(c, ac.DB).Model(user).Updates(updates).Error; err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to count login failure", "user_id", user.ID, "error", err)
+	}
+}
+
+// registerLoginSuccess clears the failure counters and records the login.
+func (ac *AuthController) registerLoginSuccess(c *gin.Context, user *models.User) {
+	ac.recordLoginAttempt(c, user.Email, &user.ID, constants.LoginResultSuccess)
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		if err := resetLoginFailures(logging.RequestDB(c, ac.DB), user); err != nil {
+			logging.FromContext(c.Request.Context()).Error("failed to reset login failures", "user_id", user.ID, "error", err)
+		}
+	}
+}
+
+func (ac *AuthController) recordLoginAttempt(c *gin.Context, email string, userID *uint, reason string) {
+	err := logging.RequestDB(c, ac.DB).Create(&models.LoginAttempt{
+		Email:   email,
+		UserID:  userID,
+		IP:      c.ClientIP(),
+		Success: reason == constants.LoginResultSuccess,
+		Reason:  reason,
+	}).Error
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to record login attempt", "email", email, "reason", reason, "error", err)
+	}
+}
+
+func resetLoginFailures(db *gorm.DB, user *models.User) error {
+	user.FailedLoginCount = 0
+	user.LastFailedLoginAt = nil
+	user.LockedUntil = nil
+	return db.Model(user).Updates(map[string]any{
+		"failed_login_count":   0,
+		"last_failed_login_at": nil,
+		"locked_until":         nil,
+	}).Error
+}
+
+// loginDelay is the wait imposed after failures consecutive failures: one
+// second at the threshold, doubling with every further failure.
+func loginDelay(failures int) time.Duration {
+	delay := time.Duration(math.Pow(2, float64(failures-loginDelayThreshold))) * time.Second
+	if delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+	return delay
+}
+
+func setRetryAfter(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+}
@@ -0,0 +1,310 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"taskmanager/config"
+	"taskmanager/constants"
+	"taskmanager/logging"
+	"taskmanager/models"
+	"taskmanager/utils"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const oidcLoginStateTTL = 10 * time.Minute
+
+// OIDCController implements single sign-on with the OpenID Connect
+// authorization code flow and PKCE.
+type OIDCController struct {
+	DB     *gorm.DB
+	Config config.OIDCConfig
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+}
+
+// Login redirects the browser to the identity provider.
+func (oc *OIDCController) Login(c *gin.Context) {
+	if !oc.Config.Enabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	oauthConfig, _, err := oc.clients(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	state, _, err := utils.GenerateToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	nonce, _, err := utils.GenerateToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	loginState := models.OIDCLoginState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcLoginStateTTL),
+	}
+	if err := logging.RequestDB(c, oc.DB).Create(&loginState).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	// Drop abandoned logins so the table does not grow forever.
+	if err := logging.RequestDB(c, oc.DB).Where("expires_at < ?", time.Now()).Delete(&models.OIDCLoginState{}).Error; err != nil {
+		logging.FromContext(c.Request.Context()).Warn("failed to drop expired OIDC login states", "error", err)
+	}
+
+	c.Redirect(http.StatusFound, oauthConfig.AuthCodeURL(
+		state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(verifier),
+	))
+}
+
+// Callback finishes the flow: it exchanges the code, verifies the ID token
+// and signs the matching local user in, provisioning it when needed.
+func (oc *OIDCController) Callback(c *gin.Context) {
+	if !oc.Config.Enabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	if idpError := c.Query("error"); idpError != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider returned an error: " + idpError})
+		return
+	}
+
+	loginState, err := oc.consumeLoginState(c, c.Query("state"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	oauthConfig, verifier, err := oc.clients(ctx)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	oauthToken, err := oauthConfig.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(loginState.CodeVerifier))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to exchange authorization code"})
+		return
+	}
+
+	rawIDToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider did not return an ID token"})
+		return
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token"})
+		return
+	}
+
+	var claims oidcClaims
+	var rawClaims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token claims"})
+		return
+	}
+	if err := idToken.Claims(&rawClaims); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token claims"})
+		return
+	}
+	if claims.Nonce != loginState.Nonce {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token nonce"})
+		return
+	}
+
+	user, err := oc.resolveUser(c, idToken.Issuer, idToken.Subject, claims, groupsFromClaims(rawClaims, oc.Config.GroupsClaim))
+	if errors.Is(err, errUnverifiedIdPEmail) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Identity provider did not verify the email address"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+
+	if !user.IsActive() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is deactivated"})
+		return
+	}
+
+	// Second factors are otherwise the identity provider's job, but an
+	// account that enrolled one locally keeps requiring it.
+	if user.HasTwoFactor() {
+		respondTwoFactorChallenge(c, user)
+		return
+	}
+	ac := AuthController{DB: logging.RequestDB(c, oc.DB)}
+	ac.issueSession(c, &user, nil)
+}
+
+var errUnverifiedIdPEmail = errors.New("identity provider email is not verified")
+
+// resolveUser finds the local account for an IdP identity. Known identities
+// are matched by issuer and subject; otherwise an existing account with the
+// same verified email is linked, or a new account is provisioned.
+func (oc *OIDCController) resolveUser(c *gin.Context, issuer, subject string, claims oidcClaims, groups []string) (models.User, error) {
+	var user models.User
+
+	err := logging.RequestDB(c, oc.DB).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("oidc_issuer = ? AND oidc_subject = ?", issuer, subject).First(&user).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		found := err == nil
+
+		email, emailErr := utils.NormalizeEmail(claims.Email)
+		// Providers that do not say the email is verified are not trusted
+		// with it.
+		emailVerified := claims.EmailVerified != nil && *claims.EmailVerified
+
+		if !found {
+			if emailErr != nil || !emailVerified {
+				return errUnverifiedIdPEmail
+			}
+
+			err := tx.Where("email = ?", email).First(&user).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+
+			if err == nil {
+				user.OIDCIssuer = &issuer
+				user.OIDCSubject = &subject
+			} else {
+				user = models.User{
+					Name:        claims.Name,
+					Email:       email,
+					Role:        constants.RoleMember,
+					OIDCIssuer:  &issuer,
+					OIDCSubject: &subject,
+				}
+				if user.Name == "" {
+					user.Name = email
+				}
+			}
+		}
+
+		if user.EmailVerifiedAt == nil {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+		if oc.Config.MapsGroups() {
+			user.Role = oc.roleForGroups(groups)
+		}
+
+		return tx.Save(&user).Error
+	})
+	return user, err
+}
+
+func (oc *OIDCController) roleForGroups(groups []string) string {
+	for _, group := range groups {
+		if slices.Contains(oc.Config.AdminGroups, group) {
+			return constants.RoleAdmin
+		}
+	}
+	for _, group := range groups {
+		if slices.Contains(oc.Config.ManagerGroups, group) {
+			return constants.RoleManager
+		}
+	}
+	return constants.RoleMember
+}
+
+func (oc *OIDCController) consumeLoginState(c *gin.Context, state string) (models.OIDCLoginState, error) {
+	var loginState models.OIDCLoginState
+	if state == "" {
+		return loginState, errInvalidUserToken
+	}
+
+	err := logging.RequestDB(c, oc.DB).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("state = ?", state).First(&loginState).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&loginState)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 || time.Now().After(loginState.ExpiresAt) {
+			return errInvalidUserToken
+		}
+		return nil
+	})
+	return loginState, err
+}
+
+// clients discovers the provider on first use and caches it. Failed
+// discovery is retried on the next request.
+func (oc *OIDCController) clients(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	if oc.provider == nil {
+		provider, err := oidc.NewProvider(ctx, oc.Config.Issuer)
+		if err != nil {
+			return nil, nil, err
+		}
+		oc.provider = provider
+	}
+
+	oauthConfig := &oauth2.Config{
+		ClientID:     oc.Config.ClientID,
+		ClientSecret: oc.Config.ClientSecret,
+		RedirectURL:  oc.Config.RedirectURL,
+		Endpoint:     oc.provider.Endpoint(),
+		Scopes:       oc.Config.Scopes,
+	}
+	verifier := oc.provider.Verifier(&oidc.Config{ClientID: oc.Config.ClientID})
+	return oauthConfig, verifier, nil
+}
+
+// groupsFromClaims reads the groups claim, accepting either a list or a
+// single string.
+func groupsFromClaims(claims map[string]any, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []any:
+		groups := make([]string, 0, len(value))
+		for _, item := range value {
+			if group, ok := item.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+		return groups
+	default:
+		return nil
+	}
+}
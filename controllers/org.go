@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"taskmanager/constants"
+	"taskmanager/logging"
+	"taskmanager/models"
+	"taskmanager/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type OrgController struct {
+	DB *gorm.DB
+}
+
+// orgNode is a user in the org chart together with their workload.
+type orgNode struct {
+	ID            uint       `json:"id"`
+	Name          string     `json:"name"`
+	Email         string     `json:"email"`
+	Role          string     `json:"role"`
+	ManagerID     *uint      `json:"manager_id"`
+	DeactivatedAt *time.Time `json:"deactivated_at"`
+	OpenTasks     int64      `json:"open_tasks"`
+	OverdueTasks  int64      `json:"overdue_tasks"`
+	Reports       []*orgNode `json:"reports"`
+}
+
+type orgTaskCounts struct {
+	AssignedToID uint
+	OpenTasks    int64
+	OverdueTasks int64
+}
+
+// GetTree returns the org chart: the whole company for callers who can see
+// every task, their own subtree for managers.
+func (oc *OrgController) GetTree(c *gin.Context) {
+	subject, ok := currentSubject(c, logging.RequestDB(c, oc.DB))
+	if !ok {
+		return
+	}
+
+	query := logging.RequestDB(c, oc.DB).Order("id")
+	wholeCompany := subject.Has(constants.PermTaskViewAll)
+	switch {
+	case wholeCompany:
+	case subject.Has(constants.PermTaskViewReports):
+		reportIDs := utils.GetRecursiveReportIDs(subject.UserID, logging.RequestDB(c, oc.DB))
+		query = query.Where("id IN ?", append([]uint{subject.UserID}, reportIDs...))
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to view the org chart"})
+		return
+	}
+
+	var users []models.User
+	if err := query.Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load org chart"})
+		return
+	}
+
+	nodes, err := oc.buildNodes(c, users)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load org chart"})
+		return
+	}
+
+	var roots []*orgNode
+	if wholeCompany {
+		roots = linkOrgTree(nodes, nil)
+	} else {
+		roots = linkOrgTree(nodes, &subject.UserID)
+	}
+
+	c.JSON(http.StatusOK, roots)
+}
+
+// GetChain returns the caller's chain of command, nearest manager first.
+func (oc *OrgController) GetChain(c *gin.Context) {
+	userID := uint(c.GetFloat64("user_id"))
+
+	chainIDs, err := utils.LoadManagerChainIDs(userID, logging.RequestDB(c, oc.DB))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load chain of command"})
+		return
+	}
+
+	var users []models.User
+	if err := logging.RequestDB(c, oc.DB).Where("id IN ?", append([]uint{userID}, chainIDs...)).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load chain of command"})
+		return
+	}
+
+	nodes, err := oc.buildNodes(c, users)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load chain of command"})
+		return
+	}
+
+	chain := make([]*orgNode, 0, len(chainIDs))
+	for _, id := range chainIDs {
+		if node, ok := nodes[id]; ok {
+			chain = append(chain, node)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":  nodes[userID],
+		"chain": chain,
+	})
+}
+
+// buildNodes turns users into unlinked nodes carrying their open and
+// overdue task counts, loaded in a single grouped query.
+func (oc *OrgController) buildNodes(c *gin.Context, users []models.User) (map[uint]*orgNode, error) {
+	nodes := make(map[uint]*orgNode, len(users))
+	ids := make([]uint, 0, len(users))
+	for _, user := range users {
+		nodes[user.ID] = &orgNode{
+			ID:            user.ID,
+			Name:          user.Name,
+			Email:         user.Email,
+			Role:          user.Role,
+			ManagerID:     user.ManagerID,
+			DeactivatedAt: user.DeactivatedAt,
+			Reports:       []*orgNode{},
+		}
+		ids = append(ids, user.ID)
+	}
+	if len(ids) == 0 {
+		return nodes, nil
+	}
+
+	var counts []orgTaskCounts
+	if err := logging.RequestDB(c, oc.DB).Model(&models.Task{}).
+		Select(
+			"assigned_to_id, COUNT(*) AS open_tasks, "+
+				"SUM(CASE WHEN deadline IS NOT NULL AND deadline < ? THEN 1 ELSE 0 END) AS overdue_tasks",
+			time.Now(),
+		).
+		Where("assigned_to_id IN ? AND status <> ?", ids, constants.TaskStatusApproved).
+		Group("assigned_to_id").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	for _, count := range counts {
+		if node, ok := nodes[count.AssignedToID]; ok {
+			node.OpenTasks = count.OpenTasks
+			node.OverdueTasks = count.OverdueTasks
+		}
+	}
+
+	return nodes, nil
+}
+
+// linkOrgTree attaches every node to its manager and returns the roots:
+// rootID when given, otherwise everyone without a manager in nodes. Nodes
+// caught in a manager_id cycle would be unreachable, so they become roots
+// as well.
+func linkOrgTree(nodes map[uint]*orgNode, rootID *uint) []*orgNode {
+	ids := make([]uint, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var roots []*orgNode
+	children := map[uint][]*orgNode{}
+	for _, id := range ids {
+		node := nodes[id]
+		_, hasManager := nodes[derefUint(node.ManagerID)]
+		switch {
+		case rootID != nil && id == *rootID:
+			roots = append(roots, node)
+		case rootID == nil && !hasManager:
+			roots = append(roots, node)
+		case hasManager:
+			children[*node.ManagerID] = append(children[*node.ManagerID], node)
+		}
+	}
+
+	linked := map[uint]bool{}
+	var link func(node *orgNode)
+	link = func(node *orgNode) {
+		linked[node.ID] = true
+		for _, child := range children[node.ID] {
+			if !linked[child.ID] {
+				node.Reports = append(node.Reports, child)
+				link(child)
+			}
+		}
+	}
+	for _, root := range roots {
+		link(root)
+	}
+
+	if rootID == nil {
+		for _, id := range ids {
+			if !linked[id] {
+				roots = append(roots, nodes[id])
+				link(nodes[id])
+			}
+		}
+	}
+
+	if roots == nil {
+		roots = []*orgNode{}
+	}
+	return roots
+}
+
+func derefUint(value *uint) uint {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
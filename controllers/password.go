@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"taskmanager/constants"
+	"taskmanager/logging"
+	"taskmanager/mail"
+	"taskmanager/models"
+	"taskmanager/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const passwordResetTTL = time.Hour
+
+type changePasswordInput struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type forgotPasswordInput struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordInput struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// ChangePassword updates the caller's password. All other sessions are
+// revoked and a fresh token is returned for the current one.
+func (ac *AuthController) ChangePassword(c *gin.Context) {
+	userID := uint(c.GetFloat64("user_id"))
+
+	var input changePasswordInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := logging.RequestDB(c, ac.DB).First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !utils.CheckPassword(input.CurrentPassword, user.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if err := utils.ValidatePassword(input.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := setPassword(logging.RequestDB(c, ac.DB), &user, input.NewPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	token, err := utils.GenerateJWT(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password changed",
+		"token":   token,
+	})
+}
+
+// ForgotPassword emails a single-use reset link. The response is the same
+// whether or not the email belongs to an account.
+func (ac *AuthController) ForgotPassword(c *gin.Context) {
+	var input forgotPasswordInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	err := logging.RequestDB(c, ac.DB).Where("email = ?", normalizeLoginEmail(input.Email)).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+		return
+	}
+
+	// Accounts provisioned through single sign-on have no local password.
+	// Failures past this point are only logged: answering them differently
+	// would reveal that the account exists.
+	if err == nil && user.IsActive() && user.Password != "" {
+		if err := ac.sendPasswordReset(c, user); err != nil {
+			logging.FromContext(c.Request.Context()).Error("Failed to send password reset email", "user_id", user.ID, "error", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "If the email is registered, a reset link has been sent",
+	})
+}
+
+func (ac *AuthController) sendPasswordReset(c *gin.Context, user models.User) error {
+	token, err := issueUserToken(logging.RequestDB(c, ac.DB), user.ID, constants.TokenPurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := publicLink(ac.BaseURL, "/password/reset?token="+token)
+	return ac.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nSomeone asked to reset your password. Use the link below to choose a new one:\n\n%s\n\nThe link expires in 1 hour. If you did not ask for this, you can ignore this email.",
+			user.Name, link,
+		),
+	})
+}
+
+// ResetPassword sets a new password using a reset token, revokes every
+// existing session and personal access token of the account and clears its
+// login lockout.
+func (ac *AuthController) ResetPassword(c *gin.Context) {
+	var input resetPasswordInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := utils.ValidatePassword(input.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := logging.RequestDB(c, ac.DB).Transaction(func(tx *gorm.DB) error {
+		userToken, err := consumeUserToken(tx, input.Token, constants.TokenPurposePasswordReset)
+		if err != nil {
+			return err
+		}
+
+		var user models.User
+		if err := tx.First(&user, userToken.UserID).Error; err != nil {
+			return err
+		}
+		if !user.IsActive() {
+			return errInvalidUserToken
+		}
+		if err := setPassword(tx, &user, input.NewPassword); err != nil {
+			return err
+		}
+		// A reset usually follows a compromise, so tokens minted with the
+		// old password must stop working along with its sessions.
+		if err := revokeAccessTokens(tx, user.ID); err != nil {
+			return err
+		}
+		// Proving control of the mailbox lifts a lockout, otherwise the
+		// new password could not be used until it expires.
+		return resetLoginFailures(tx, &user)
+	})
+	if errors.Is(err, errInvalidUserToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+// setPassword hashes and stores password and bumps the token version so
+// every JWT issued before the change stops being accepted.
+func setPassword(db *gorm.DB, user *models.User, password string) error {
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user.Password = hashed
+	user.TokenVersion++
+	return db.Model(user).Updates(map[string]any{
+		"password":      user.Password,
+		"token_version": user.TokenVersion,
+	}).Error
+}
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"taskmanager/authz"
+	"taskmanager/constants"
+	"taskmanager/logging"
+	"taskmanager/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,63}$`)
+
+type RoleController struct {
+	DB *gorm.DB
+}
+
+type roleInput struct {
+	Name        string    `json:"name"`
+	Description *string   `json:"description"`
+	Permissions *[]string `json:"permissions"`
+}
+
+// GetPermissions lists every permission that can be granted to a role.
+func (rc *RoleController) GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, authz.Permissions)
+}
+
+func (rc *RoleController) GetRoles(c *gin.Context) {
+	var roles []models.Role
+	if err := logging.RequestDB(c, rc.DB).Order("name").Find(&roles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load roles"})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// CreateRole defines a custom role.
+func (rc *RoleController) CreateRole(c *gin.Context) {
+	var input roleInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !roleNamePattern.MatchString(input.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role name must be 2-64 lowercase letters, digits, '-' or '_' and start with a letter"})
+		return
+	}
+
+	role := models.Role{Name: input.Name}
+	if input.Description != nil {
+		role.Description = *input.Description
+	}
+	if input.Permissions != nil {
+		permissions, ok := validPermissions(c, *input.Permissions)
+		if !ok {
+			return
+		}
+		role.Permissions = permissions
+	}
+
+	exists, err := roleExists(logging.RequestDB(c, rc.DB), role.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+		return
+	}
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Role already exists"})
+		return
+	}
+
+	if err := logging.RequestDB(c, rc.DB).Create(&role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole changes the description or permissions of a role. Changes apply
+// to the next request of every user with the role.
+func (rc *RoleController) UpdateRole(c *gin.Context) {
+	var role models.Role
+	if err := logging.RequestDB(c, rc.DB).Where("name = ?", c.Param("name")).First(&role).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	var input roleInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Description != nil {
+		role.Description = *input.Description
+	}
+	if input.Permissions != nil {
+		if role.Name == constants.RoleAdmin {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The admin role always has every permission"})
+			return
+		}
+		permissions, ok := validPermissions(c, *input.Permissions)
+		if !ok {
+			return
+		}
+		role.Permissions = permissions
+	}
+
+	if err := logging.RequestDB(c, rc.DB).Save(&role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole removes a custom role that nobody holds anymore.
+func (rc *RoleController) DeleteRole(c *gin.Context) {
+	var role models.Role
+	if err := logging.RequestDB(c, rc.DB).Where("name = ?", c.Param("name")).First(&role).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	if role.BuiltIn {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Built-in roles cannot be deleted"})
+		return
+	}
+
+	var holders int64
+	if err := logging.RequestDB(c, rc.DB).Model(&models.User{}).Where("role = ?", role.Name).Count(&holders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+	if holders > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Role is still assigned to users"})
+		return
+	}
+
+	if err := logging.RequestDB(c, rc.DB).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role = ?", role.Name).Delete(&models.TwoFactorPolicy{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted"})
+}
+
+func validPermissions(c *gin.Context, permissions []string) (models.StringList, bool) {
+	result := make(models.StringList, 0, len(permissions))
+	for _, permission := range permissions {
+		if !authz.IsPermission(permission) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown permission: " + permission})
+			return nil, false
+		}
+		result = append(result, permission)
+	}
+	return result, true
+}
+
+func roleExists(db *gorm.DB, name string) (bool, error) {
+	var count int64
+	err := db.Model(&models.Role{}).Where("name = ?", name).Count(&count).Error
+	return count > 0, err
+}
+
+// checkGrantableRole responds with an error and reports false unless role
+// exists and the caller may grant it (see authz.CanGrant). failure is the
+// message of a 500.
+func checkGrantableRole(c *gin.Context, db *gorm.DB, name, failure string) bool {
+	subject, ok := currentSubject(c, db)
+	if !ok {
+		return false
+	}
+
+	var role models.Role
+	err := db.Where("name = ?", name).First(&role).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+		return false
+	}
+	if !authz.CanGrant(subject, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot grant a role with permissions you do not have"})
+		return false
+	}
+	return true
+}
+
+// currentSubject returns the caller's permissions, reusing the ones loaded
+// by middleware.RequirePermission when available. It responds with an error
+// and reports false when they cannot be loaded.
+func currentSubject(c *gin.Context, db *gorm.DB) (authz.Subject, bool) {
+	if value, ok := c.Get("subject"); ok {
+		return value.(authz.Subject), true
+	}
+
+	subject, err := authz.SubjectFor(db, uint(c.GetFloat64("user_id")), c.GetString("role"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions"})
+		return subject, false
+	}
+	return subject, true
+}
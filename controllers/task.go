@@ -5,649 +5,340 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"taskmanager/constants"
+	"strconv"
+	"strings"
+	"taskmanager/logging"
 	"taskmanager/models"
-	"taskmanager/utils"
-	"time"
+	"taskmanager/repository"
+	"taskmanager/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+// TaskController binds HTTP requests to the TaskService, which holds the
+// workflow rules. DB is only used to load the caller's permissions.
 type TaskController struct {
-	DB *gorm.DB
+	DB    *gorm.DB
+	Tasks *services.TaskService
 }
 
-type updateTaskInput struct {
-	Title              *string    `json:"title"`
-	Description        *string    `json:"description"`
-	AssignedToID       *uint      `json:"assigned_to_id"`
-	Status             *string    `json:"status"`
-	ProgressPercentage *int       `json:"progress_percentage"`
-	Deadline           *time.Time `json:"deadline"`
-}
-
-type taskDecisionInput struct {
-	Comments string `json:"comments"`
-	Reason   string `json:"reason"`
-}
-
-type requestExtensionInput struct {
-	RequestedDeadline *time.Time `json:"requested_deadline"`
-	Reason            string     `json:"reason"`
-}
-
-type extendDeadlineInput struct {
-	NewDeadline *time.Time `json:"new_deadline"`
-	Comments    string     `json:"comments"`
+func (tc *TaskController) service(c *gin.Context) *services.TaskService {
+	return tc.Tasks.WithContext(logging.RequestContext(c))
 }
 
 func (tc *TaskController) CreateTask(c *gin.Context) {
-	userID := uint(c.GetFloat64("user_id"))
-	role := c.GetString("role")
-
-	var task models.Task
-	if err := c.BindJSON(&task); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	subject, ok := currentSubject(c, logging.RequestDB(c, tc.DB))
+	if !ok {
 		return
 	}
 
-	if task.ProgressPercentage < 0 || task.ProgressPercentage > 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "progress_percentage must be between 0 and 100"})
+	var input services.CreateTaskInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if task.AssignedToID != 0 {
-		canAssign, err := utils.CanAssignTask(userID, role, task.AssignedToID, tc.DB)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify assignment permissions"})
-			return
-		}
-		if !canAssign {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to assign a task to this user"})
-			return
-		}
-	}
-
-	task.CreatedByID = userID
-
-	if task.AssignedToID == 0 {
-		task.Status = constants.TaskStatusCreated
-	} else {
-		task.Status = constants.TaskStatusAssigned
-	}
-
-	setDeadlineStatus(&task)
-
-	if err := tc.DB.Create(&task).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create task"})
+	task, err := tc.service(c).Create(subject, input)
+	if err != nil {
+		respondServiceError(c, err, "Failed to create task")
 		return
 	}
 
+	c.Header("ETag", taskETag(task))
 	c.JSON(http.StatusOK, task)
 }
 
 func (tc *TaskController) GetTasks(c *gin.Context) {
-	userID := uint(c.GetFloat64("user_id"))
-	role := c.GetString("role")
-
-	var tasks []models.Task
-	query := tc.DB.Preload("AuditTrail")
-
-	switch role {
-	case constants.RoleAdmin:
-		query.Find(&tasks)
-
-	case constants.RoleManager:
-		memberIDs := utils.GetRecursiveReportIDs(userID, tc.DB)
-		query.Where(
-			"assigned_to_id IN ? OR created_by_id = ? OR assigned_to_id = ?",
-			memberIDs, userID, userID,
-		).Find(&tasks)
-
-	case constants.RoleMember:
-		query.Where(
-			"created_by_id = ? OR assigned_to_id = ?",
-			userID, userID,
-		).Find(&tasks)
+	subject, ok := currentSubject(c, logging.RequestDB(c, tc.DB))
+	if !ok {
+		return
+	}
 
-	default:
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized role"})
+	filter, ok := taskFilter(c)
+	if !ok {
 		return
 	}
 
-	for i := range tasks {
-		if err := tc.refreshTaskDeadlineStatus(&tasks[i]); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate deadline status"})
-			return
-		}
+	tasks, err := tc.service(c).List(subject, filter)
+	if err != nil {
+		respondServiceError(c, err, "Failed to load tasks")
+		return
 	}
 
 	c.JSON(http.StatusOK, tasks)
 }
 
-func (tc *TaskController) GetTask(c *gin.Context) {
-	id := c.Param("id")
-	userID := uint(c.GetFloat64("user_id"))
-	role := c.GetString("role")
-
-	var task models.Task
-	if err := tc.DB.Preload("AuditTrail").First(&task, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+// GetPool lists the unassigned tasks of the caller's teams, oldest first,
+// optionally only those of ?team_id=.
+func (tc *TaskController) GetPool(c *gin.Context) {
+	subject, ok := currentSubject(c, logging.RequestDB(c, tc.DB))
+	if !ok {
 		return
 	}
 
-	if !utils.CanAccessTask(task, userID, role, tc.DB) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized access"})
+	filter, ok := taskFilter(c)
+	if !ok {
 		return
 	}
 
-	if err := tc.refreshTaskDeadlineStatus(&task); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate deadline status"})
+	tasks, err := tc.service(c).Pool(subject, filter)
+	if err != nil {
+		respondServiceError(c, err, "Failed to load tasks")
 		return
 	}
 
-	c.JSON(http.StatusOK, task)
+	c.JSON(http.StatusOK, tasks)
 }
 
-func (tc *TaskController) UpdateTask(c *gin.Context) {
-	id := c.Param("id")
-	userID := uint(c.GetFloat64("user_id"))
-	role := c.GetString("role")
-
-	var task models.Task
-	if err := tc.DB.First(&task, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
-		return
-	}
-
-	if !utils.CanAccessTask(task, userID, role, tc.DB) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized access"})
+// ClaimTask assigns an unassigned team task to the calling member. Of
+// several concurrent claims exactly one succeeds and the others get 409.
+func (tc *TaskController) ClaimTask(c *gin.Context) {
+	subject, ok := currentSubject(c, logging.RequestDB(c, tc.DB))
+	if !ok {
 		return
 	}
-
-	if err := tc.refreshTaskDeadlineStatus(&task); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate deadline status"})
+	id, ok := taskID(c)
+	if !ok {
 		return
 	}
 
-	var input updateTaskInput
-	if err := c.BindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	task, err := tc.service(c).Claim(subject, id)
+	if err != nil {
+		respondServiceError(c, err, "Failed to claim task")
 		return
 	}
 
-	if role == constants.RoleMember {
-		if task.AssignedToID != userID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Members can only update tasks assigned to themselves"})
-			return
-		}
-		if input.Title != nil || input.Description != nil || input.AssignedToID != nil || input.Deadline != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Members can only update progress and status on their own tasks"})
-			return
-		}
-	}
-
-	if input.AssignedToID != nil {
-		canAssign, err := utils.CanAssignTask(userID, role, *input.AssignedToID, tc.DB)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify assignment permissions"})
-			return
-		}
-		if !canAssign {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to assign a task to this user"})
-			return
-		}
-	}
-
-	if input.ProgressPercentage != nil {
-		if *input.ProgressPercentage < 0 || *input.ProgressPercentage > 100 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "progress_percentage must be between 0 and 100"})
-			return
-		}
-		task.ProgressPercentage = *input.ProgressPercentage
-	}
-
-	if input.Title != nil {
-		task.Title = *input.Title
-	}
-	if input.Description != nil {
-		task.Description = *input.Description
-	}
-	if input.AssignedToID != nil {
-		task.AssignedToID = *input.AssignedToID
-		if task.Status == constants.TaskStatusCreated && task.AssignedToID != 0 {
-			task.Status = constants.TaskStatusAssigned
-		}
-	}
-	if input.Deadline != nil {
-		task.Deadline = input.Deadline
-		task.ExtensionRequested = false
-		task.ExtensionRequestedByID = nil
-		task.ExtensionRequestedAt = nil
-		task.ExtensionRequestedDeadline = nil
-		task.ExtensionReason = ""
-	}
-
-	if input.Status != nil {
-		mappedStatus := normalizeStatus(*input.Status)
-		if mappedStatus == constants.TaskStatusApproved || mappedStatus == constants.TaskStatusRejected {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Use /tasks/:id/approve or /tasks/:id/reject for approval decisions"})
-			return
-		}
-		if !isValidStatus(mappedStatus) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task status"})
-			return
-		}
-		if !isAllowedStatusTransition(task.Status, mappedStatus) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status transition"})
-			return
-		}
-		if mappedStatus == constants.TaskStatusPendingApproval && task.ProgressPercentage < 100 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "progress_percentage must be 100 before moving to pending_approval"})
-			return
-		}
-		if task.Status == constants.TaskStatusApproved && mappedStatus != constants.TaskStatusApproved {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Approved tasks are locked"})
-			return
-		}
+	c.Header("ETag", taskETag(task))
+	c.JSON(http.StatusOK, task)
+}
 
-		now := time.Now()
-		if mappedStatus == constants.TaskStatusPendingApproval {
-			if task.CompletedAt == nil {
-				task.CompletedAt = &now
-			}
-			task.PendingApprovalNotifiedAt = &now
-		}
-		if mappedStatus == constants.TaskStatusInProgress && task.Status == constants.TaskStatusRejected {
-			task.RejectionReason = ""
-			task.RejectedByID = nil
-			task.RejectedAt = nil
-		}
-		task.Status = mappedStatus
+func (tc *TaskController) GetTask(c *gin.Context) {
+	subject, ok := currentSubject(c, logging.RequestDB(c, tc.DB))
+	if !ok {
+		return
 	}
-
-	if task.CompletionLocked {
-		if input.Status != nil && *input.Status != constants.TaskStatusApproved {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Completion date is locked for approved tasks"})
-			return
-		}
+	id, ok := taskID(c)
+	if !ok {
+		return
 	}
 
-	setDeadlineStatus(&task)
-
-	if err := tc.DB.Save(&task).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update task"})
+	task, err := tc.service(c).Get(subject, id)
+	if err != nil {
+		respondServiceError(c, err, "Failed to load task")
 		return
 	}
 
+	c.Header("ETag", taskETag(task))
 	c.JSON(http.StatusOK, task)
 }
 
-func (tc *TaskController) RequestExtension(c *gin.Context) {
-	id := c.Param("id")
-	userID := uint(c.GetFloat64("user_id"))
-	role := c.GetString("role")
-
-	var task models.Task
-	if err := tc.DB.First(&task, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+func (tc *TaskController) UpdateTask(c *gin.Context) {
+	subject, ok := currentSubject(c, logging.RequestDB(c, tc.DB))
+	if !ok {
 		return
 	}
-
-	if !utils.CanAccessTask(task, userID, role, tc.DB) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized access"})
+	id, ok := taskID(c)
+	if !ok {
 		return
 	}
 
-	if task.AssignedToID != userID || role != constants.RoleMember {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only assigned member can request extension"})
+	var input services.UpdateTaskInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	input.ExpectedVersions = ifMatchVersions(c)
 
-	if err := tc.refreshTaskDeadlineStatus(&task); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate deadline status"})
+	task, err := tc.service(c).Update(subject, id, input)
+	if err != nil {
+		if task != nil {
+			c.Header("ETag", taskETag(task))
+		}
+		respondServiceError(c, err, "Failed to update task")
 		return
 	}
 
-	if task.Deadline == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Task has no deadline"})
+	c.Header("ETag", taskETag(task))
+	c.JSON(http.StatusOK, task)
+}
+
+func (tc *TaskController) RequestExtension(c *gin.Context) {
+	subject, ok := currentSubject(c, logging.RequestDB(c, tc.DB))
+	if !ok {
 		return
 	}
-	if task.DeadlineStatus != constants.DeadlineStatusOverdue {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Extension can only be requested for overdue tasks"})
+	id, ok := taskID(c)
+	if !ok {
 		return
 	}
 
-	var input requestExtensionInput
+	var input services.RequestExtensionInput
 	if err := c.BindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if input.RequestedDeadline == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "requested_deadline is required"})
-		return
-	}
-	if !input.RequestedDeadline.After(*task.Deadline) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "requested_deadline must be after current deadline"})
-		return
-	}
 
-	now := time.Now()
-	task.ExtensionRequested = true
-	task.ExtensionRequestedByID = &userID
-	task.ExtensionRequestedAt = &now
-	task.ExtensionRequestedDeadline = input.RequestedDeadline
-	task.ExtensionReason = input.Reason
-
-	if err := tc.DB.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Save(&task).Error; err != nil {
-			return err
-		}
-
-		audit := models.TaskAudit{
-			TaskID:   task.ID,
-			Action:   "extension_requested",
-			ActorID:  userID,
-			Comments: fmt.Sprintf("requested_deadline=%s; reason=%s", input.RequestedDeadline.Format(time.RFC3339), input.Reason),
-		}
-		return tx.Create(&audit).Error
-	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request extension"})
+	task, err := tc.service(c).RequestExtension(subject, id, input)
+	if err != nil {
+		respondServiceError(c, err, "Failed to request extension")
 		return
 	}
 
+	c.Header("ETag", taskETag(task))
 	c.JSON(http.StatusOK, task)
 }
 
 func (tc *TaskController) ExtendDeadline(c *gin.Context) {
-	id := c.Param("id")
-	userID := uint(c.GetFloat64("user_id"))
-	role := c.GetString("role")
-
-	var task models.Task
-	if err := tc.DB.First(&task, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
-		return
-	}
-
-	if !utils.CanAccessTask(task, userID, role, tc.DB) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized access"})
-		return
-	}
-
-	if role == constants.RoleMember {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Members cannot extend deadline"})
+	subject, ok := currentSubject(c, logging.RequestDB(c, tc.DB))
+	if !ok {
 		return
 	}
-
-	if userID != task.CreatedByID && role != constants.RoleAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only task assigner or admin can extend deadline"})
+	id, ok := taskID(c)
+	if !ok {
 		return
 	}
 
-	var input extendDeadlineInput
+	var input services.ExtendDeadlineInput
 	if err := c.BindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if input.NewDeadline == nil {
-		input.NewDeadline = task.ExtensionRequestedDeadline
-	}
-	if input.NewDeadline == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "new_deadline is required"})
-		return
-	}
-	if task.Deadline != nil && !input.NewDeadline.After(*task.Deadline) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "new_deadline must be after current deadline"})
-		return
-	}
-
-	now := time.Now()
-	task.Deadline = input.NewDeadline
-	task.ExtensionRequested = false
-	task.ExtensionApprovedByID = &userID
-	task.ExtensionApprovedAt = &now
-	task.ExtensionRequestedByID = nil
-	task.ExtensionRequestedAt = nil
-	task.ExtensionRequestedDeadline = nil
-	task.ExtensionReason = ""
-	setDeadlineStatus(&task)
-
-	if err := tc.DB.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Save(&task).Error; err != nil {
-			return err
-		}
-
-		audit := models.TaskAudit{
-			TaskID:   task.ID,
-			Action:   "deadline_extended",
-			ActorID:  userID,
-			Comments: fmt.Sprintf("new_deadline=%s; comments=%s", input.NewDeadline.Format(time.RFC3339), input.Comments),
-		}
-		return tx.Create(&audit).Error
-	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extend deadline"})
+	task, err := tc.service(c).ExtendDeadline(subject, id, input)
+	if err != nil {
+		respondServiceError(c, err, "Failed to extend deadline")
 		return
 	}
 
+	c.Header("ETag", taskETag(task))
 	c.JSON(http.StatusOK, task)
 }
 
 func (tc *TaskController) ApproveTask(c *gin.Context) {
-	id := c.Param("id")
-	userID := uint(c.GetFloat64("user_id"))
-	role := c.GetString("role")
-
-	if role != constants.RoleManager && role != constants.RoleAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only manager/admin can approve tasks"})
-		return
-	}
-
-	var task models.Task
-	if err := tc.DB.First(&task, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+	subject, ok := currentSubject(c, logging.RequestDB(c, tc.DB))
+	if !ok {
 		return
 	}
-
-	if !utils.CanAccessTask(task, userID, role, tc.DB) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized access"})
+	id, ok := taskID(c)
+	if !ok {
 		return
 	}
 
-	if task.Status != constants.TaskStatusPendingApproval {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Only pending_approval tasks can be approved"})
-		return
-	}
-
-	var input taskDecisionInput
+	var input services.DecisionInput
 	if err := c.BindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	now := time.Now()
-	task.Status = constants.TaskStatusApproved
-	task.ApprovedByID = &userID
-	task.ApprovedAt = &now
-	task.CompletionLocked = true
-	if task.CompletedAt == nil {
-		task.CompletedAt = &now
-	}
-	setDeadlineStatus(&task)
-
-	if err := tc.DB.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Save(&task).Error; err != nil {
-			return err
-		}
-
-		audit := models.TaskAudit{
-			TaskID:   task.ID,
-			Action:   constants.TaskStatusApproved,
-			ActorID:  userID,
-			Comments: input.Comments,
-		}
-		return tx.Create(&audit).Error
-	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve task"})
+	task, err := tc.service(c).Approve(subject, id, input)
+	if err != nil {
+		respondServiceError(c, err, "Failed to approve task")
 		return
 	}
 
+	c.Header("ETag", taskETag(task))
 	c.JSON(http.StatusOK, task)
 }
 
 func (tc *TaskController) RejectTask(c *gin.Context) {
-	id := c.Param("id")
-	userID := uint(c.GetFloat64("user_id"))
-	role := c.GetString("role")
-
-	if role != constants.RoleManager && role != constants.RoleAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only manager/admin can reject tasks"})
-		return
-	}
-
-	var task models.Task
-	if err := tc.DB.First(&task, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
-		return
-	}
-
-	if !utils.CanAccessTask(task, userID, role, tc.DB) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized access"})
+	subject, ok := currentSubject(c, logging.RequestDB(c, tc.DB))
+	if !ok {
 		return
 	}
-
-	if task.Status != constants.TaskStatusPendingApproval {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Only pending_approval tasks can be rejected"})
+	id, ok := taskID(c)
+	if !ok {
 		return
 	}
 
-	var input taskDecisionInput
+	var input services.DecisionInput
 	if err := c.BindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if input.Reason == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "rejection reason is required"})
-		return
-	}
-
-	now := time.Now()
-	task.Status = constants.TaskStatusRejected
-	task.RejectedByID = &userID
-	task.RejectedAt = &now
-	task.RejectionReason = input.Reason
-	task.CompletionLocked = false
-	task.ApprovedByID = nil
-	task.ApprovedAt = nil
-	setDeadlineStatus(&task)
-
-	if err := tc.DB.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Save(&task).Error; err != nil {
-			return err
-		}
-
-		audit := models.TaskAudit{
-			TaskID:   task.ID,
-			Action:   constants.TaskStatusRejected,
-			ActorID:  userID,
-			Comments: input.Comments,
-		}
-		if input.Comments == "" {
-			audit.Comments = input.Reason
-		}
-		return tx.Create(&audit).Error
-	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject task"})
+	task, err := tc.service(c).Reject(subject, id, input)
+	if err != nil {
+		respondServiceError(c, err, "Failed to reject task")
 		return
 	}
 
+	c.Header("ETag", taskETag(task))
 	c.JSON(http.StatusOK, task)
 }
 
 func (tc *TaskController) DeleteTask(c *gin.Context) {
-	id := c.Param("id")
-	userID := uint(c.GetFloat64("user_id"))
-	role := c.GetString("role")
-
-	var task models.Task
-	if err := tc.DB.First(&task, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+	subject, ok := currentSubject(c, logging.RequestDB(c, tc.DB))
+	if !ok {
+		return
+	}
+	id, ok := taskID(c)
+	if !ok {
 		return
 	}
 
-	if !utils.CanAccessTask(task, userID, role, tc.DB) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized access"})
+	if err := tc.service(c).Delete(subject, id); err != nil {
+		respondServiceError(c, err, "Failed to delete task")
 		return
 	}
 
-	tc.DB.Delete(&models.Task{}, id)
 	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
 }
 
-func isValidStatus(status string) bool {
-	switch status {
-	case constants.TaskStatusCreated,
-		constants.TaskStatusAssigned,
-		constants.TaskStatusInProgress,
-		constants.TaskStatusPendingApproval,
-		constants.TaskStatusApproved,
-		constants.TaskStatusRejected:
-		return true
-	default:
-		return false
+// taskID parses the :id path parameter, responding with 404 when it is not
+// a task id.
+func taskID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+		return 0, false
 	}
+	return uint(id), true
 }
 
-func normalizeStatus(status string) string {
-	if status == "completed" {
-		return constants.TaskStatusPendingApproval
+// taskFilter reads the listing filters from the query string.
+func taskFilter(c *gin.Context) (repository.TaskFilter, bool) {
+	var filter repository.TaskFilter
+	if raw := c.Query("team_id"); raw != "" {
+		teamID, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid team_id"})
+			return filter, false
+		}
+		id := uint(teamID)
+		filter.TeamID = &id
 	}
-	return status
+	return filter, true
 }
 
-func isAllowedStatusTransition(from, to string) bool {
-	if from == to {
-		return true
-	}
-
-	switch from {
-	case constants.TaskStatusCreated:
-		return to == constants.TaskStatusAssigned
-	case constants.TaskStatusAssigned:
-		return to == constants.TaskStatusInProgress
-	case constants.TaskStatusInProgress:
-		return to == constants.TaskStatusPendingApproval
-	case constants.TaskStatusPendingApproval:
-		return to == constants.TaskStatusApproved || to == constants.TaskStatusRejected
-	case constants.TaskStatusRejected:
-		return to == constants.TaskStatusInProgress
-	case constants.TaskStatusApproved:
-		return false
-	default:
-		return false
-	}
+// taskETag is the entity tag of the task's current version.
+func taskETag(task *models.Task) string {
+	return fmt.Sprintf(`"%d"`, task.Version)
 }
 
-func (tc *TaskController) refreshTaskDeadlineStatus(task *models.Task) error {
-	previous := task.DeadlineStatus
-	setDeadlineStatus(task)
-	if previous == task.DeadlineStatus {
+// ifMatchVersions returns the task versions listed in the If-Match header,
+// or nil when any version is acceptable. Tags that are not task versions
+// can never match, and neither can weak tags: If-Match uses the strong
+// comparison (RFC 9110, section 13.1.1).
+func ifMatchVersions(c *gin.Context) []uint {
+	header := c.GetHeader("If-Match")
+	if header == "" {
 		return nil
 	}
-	return tc.DB.Model(task).Update("deadline_status", task.DeadlineStatus).Error
-}
 
-func setDeadlineStatus(task *models.Task) {
-	if task.Deadline == nil {
-		task.DeadlineStatus = constants.DeadlineStatusOnTime
-		return
-	}
-	if time.Now().After(*task.Deadline) {
-		task.DeadlineStatus = constants.DeadlineStatusOverdue
-		return
+	versions := []uint{}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return nil
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		version, err := strconv.ParseUint(strings.Trim(candidate, `"`), 10, 64)
+		if err == nil {
+			versions = append(versions, uint(version))
+		}
 	}
-	task.DeadlineStatus = constants.DeadlineStatusOnTime
+	return versions
 }
@@ -0,0 +1,354 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"taskmanager/logging"
+	"taskmanager/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TeamController struct {
+	DB *gorm.DB
+}
+
+type departmentInput struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+type teamInput struct {
+	Name         *string `json:"name"`
+	Description  *string `json:"description"`
+	DepartmentID *uint   `json:"department_id"`
+}
+
+// teamMemberInput leaves the lead flag unchanged when IsLead is absent; new
+// members are not leads.
+type teamMemberInput struct {
+	IsLead *bool `json:"is_lead"`
+}
+
+func (tc *TeamController) GetDepartments(c *gin.Context) {
+	var departments []models.Department
+	if err := logging.RequestDB(c, tc.DB).Order("name").Find(&departments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load departments"})
+		return
+	}
+	c.JSON(http.StatusOK, departments)
+}
+
+// GetDepartment returns a department with its teams.
+func (tc *TeamController) GetDepartment(c *gin.Context) {
+	var department models.Department
+	if err := logging.RequestDB(c, tc.DB).Preload("Teams").First(&department, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+		return
+	}
+	c.JSON(http.StatusOK, department)
+}
+
+func (tc *TeamController) CreateDepartment(c *gin.Context) {
+	var input departmentInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var department models.Department
+	if !applyDepartmentInput(c, &department, input) {
+		return
+	}
+	if department.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	if !tc.nameAvailable(c, &models.Department{}, department.Name, 0) {
+		return
+	}
+
+	if err := logging.RequestDB(c, tc.DB).Create(&department).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create department"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, department)
+}
+
+func (tc *TeamController) UpdateDepartment(c *gin.Context) {
+	var department models.Department
+	if err := logging.RequestDB(c, tc.DB).First(&department, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+		return
+	}
+
+	var input departmentInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !applyDepartmentInput(c, &department, input) {
+		return
+	}
+	if !tc.nameAvailable(c, &models.Department{}, department.Name, department.ID) {
+		return
+	}
+
+	if err := logging.RequestDB(c, tc.DB).Save(&department).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update department"})
+		return
+	}
+
+	c.JSON(http.StatusOK, department)
+}
+
+// DeleteDepartment removes a department. Its teams are kept without one.
+func (tc *TeamController) DeleteDepartment(c *gin.Context) {
+	var department models.Department
+	if err := logging.RequestDB(c, tc.DB).First(&department, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+		return
+	}
+
+	if err := logging.RequestDB(c, tc.DB).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Team{}).Where("department_id = ?", department.ID).
+			Update("department_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&department).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete department"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Department deleted"})
+}
+
+// GetTeams lists teams, optionally only those of ?department_id=.
+func (tc *TeamController) GetTeams(c *gin.Context) {
+	query := logging.RequestDB(c, tc.DB).Order("name")
+	if departmentID := c.Query("department_id"); departmentID != "" {
+		query = query.Where("department_id = ?", departmentID)
+	}
+
+	var teams []models.Team
+	if err := query.Find(&teams).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load teams"})
+		return
+	}
+	c.JSON(http.StatusOK, teams)
+}
+
+// GetTeam returns a team with its members.
+func (tc *TeamController) GetTeam(c *gin.Context) {
+	var team models.Team
+	if err := logging.RequestDB(c, tc.DB).Preload("Members").First(&team, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+		return
+	}
+	c.JSON(http.StatusOK, team)
+}
+
+func (tc *TeamController) CreateTeam(c *gin.Context) {
+	var input teamInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var team models.Team
+	if !tc.applyTeamInput(c, &team, input) {
+		return
+	}
+	if team.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	if !tc.nameAvailable(c, &models.Team{}, team.Name, 0) {
+		return
+	}
+
+	if err := logging.RequestDB(c, tc.DB).Create(&team).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create team"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, team)
+}
+
+func (tc *TeamController) UpdateTeam(c *gin.Context) {
+	var team models.Team
+	if err := logging.RequestDB(c, tc.DB).First(&team, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+		return
+	}
+
+	var input teamInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !tc.applyTeamInput(c, &team, input) {
+		return
+	}
+	if !tc.nameAvailable(c, &models.Team{}, team.Name, team.ID) {
+		return
+	}
+
+	if err := logging.RequestDB(c, tc.DB).Save(&team).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update team"})
+		return
+	}
+
+	c.JSON(http.StatusOK, team)
+}
+
+// DeleteTeam removes a team and its memberships. Its tasks are kept and
+// fall back to their creator and assignee for visibility.
+func (tc *TeamController) DeleteTeam(c *gin.Context) {
+	var team models.Team
+	if err := logging.RequestDB(c, tc.DB).First(&team, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+		return
+	}
+
+	if err := logging.RequestDB(c, tc.DB).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Task{}).Where("team_id = ?", team.ID).
+			Updates(map[string]interface{}{"team_id": nil, "version": gorm.Expr("version + 1")}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("team_id = ?", team.ID).Delete(&models.TeamMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&team).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete team"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Team deleted"})
+}
+
+// PutTeamMember adds a user to a team or changes whether they lead it.
+func (tc *TeamController) PutTeamMember(c *gin.Context) {
+	var team models.Team
+	if err := logging.RequestDB(c, tc.DB).First(&team, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		return
+	}
+	var user models.User
+	if err := logging.RequestDB(c, tc.DB).First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !user.IsActive() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot add a deactivated user to a team"})
+		return
+	}
+
+	var input teamMemberInput
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	member := models.TeamMember{TeamID: team.ID, UserID: user.ID}
+	if err := logging.RequestDB(c, tc.DB).Where(&member).FirstOrInit(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update team membership"})
+		return
+	}
+	if input.IsLead != nil {
+		member.IsLead = *input.IsLead
+	}
+	if err := logging.RequestDB(c, tc.DB).Save(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update team membership"})
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+func (tc *TeamController) DeleteTeamMember(c *gin.Context) {
+	result := logging.RequestDB(c, tc.DB).Where("team_id = ? AND user_id = ?", c.Param("id"), c.Param("user_id")).
+		Delete(&models.TeamMember{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update team membership"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team member not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Team member removed"})
+}
+
+func applyDepartmentInput(c *gin.Context, department *models.Department, input departmentInput) bool {
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" || len(name) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be 1-100 characters"})
+			return false
+		}
+		department.Name = name
+	}
+	if input.Description != nil {
+		department.Description = *input.Description
+	}
+	return true
+}
+
+func (tc *TeamController) applyTeamInput(c *gin.Context, team *models.Team, input teamInput) bool {
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" || len(name) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be 1-100 characters"})
+			return false
+		}
+		team.Name = name
+	}
+	if input.Description != nil {
+		team.Description = *input.Description
+	}
+	if input.DepartmentID != nil {
+		var count int64
+		if err := logging.RequestDB(c, tc.DB).Model(&models.Department{}).Where("id = ?", *input.DepartmentID).Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify department"})
+			return false
+		}
+		if count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Department not found"})
+			return false
+		}
+		team.DepartmentID = input.DepartmentID
+	}
+	return true
+}
+
+// nameAvailable responds with 409 and reports false when another row of
+// model already uses name.
+func (tc *TeamController) nameAvailable(c *gin.Context, model interface{}, name string, exceptID uint) bool {
+	var count int64
+	if err := logging.RequestDB(c, tc.DB).Model(model).Where("name = ? AND id <> ?", name, exceptID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check name"})
+		return false
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Name is already taken"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,553 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"taskmanager/constants"
+	"taskmanager/logging"
+	"taskmanager/models"
+	"taskmanager/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer        = "Task Manager"
+	recoveryCodeCount = 10
+)
+
+var (
+	errTwoFactorNotStarted  = errors.New("two-factor enrollment has not been started")
+	errInvalidTwoFactorCode = errors.New("invalid two-factor code")
+)
+
+type twoFactorCodeInput struct {
+	Code string `json:"code"`
+}
+
+type twoFactorLoginInput struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+type twoFactorEnrollInput struct {
+	EnrollmentToken string `json:"enrollment_token"`
+	Code            string `json:"code"`
+}
+
+type disableTwoFactorInput struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type twoFactorPolicyInput struct {
+	Required bool `json:"required"`
+}
+
+// LoginTwoFactor completes a login started by Login with either a TOTP code
+// or one of the user's recovery codes.
+func (ac *AuthController) LoginTwoFactor(c *gin.Context) {
+	var input twoFactorLoginInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := ac.userFromChallenge(c, input.ChallengeToken, constants.TokenPurposeTwoFactorLogin)
+	if !ok {
+		return
+	}
+	if !user.HasTwoFactor() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token"})
+		return
+	}
+
+	if !ac.checkIPThrottle(c) || !ac.checkAccountThrottle(c, &user) {
+		return
+	}
+
+	var valid bool
+	var err error
+	if input.RecoveryCode != "" {
+		valid, err = useRecoveryCode(logging.RequestDB(c, ac.DB), user.ID, input.RecoveryCode)
+	} else {
+		valid, err = useTOTPCode(logging.RequestDB(c, ac.DB), &user, input.Code)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify two-factor code"})
+		return
+	}
+	if !valid {
+		ac.registerLoginFailure(c, &user, constants.LoginResultInvalidTwoFactor)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	ac.issueSession(c, &user, nil)
+}
+
+// LoginStartEnrollment lets a user whose role requires two-factor
+// authentication enroll during login, before any JWT is issued.
+func (ac *AuthController) LoginStartEnrollment(c *gin.Context) {
+	var input twoFactorEnrollInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := ac.userFromChallenge(c, input.EnrollmentToken, constants.TokenPurposeTwoFactorEnroll)
+	if !ok {
+		return
+	}
+	if user.HasTwoFactor() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	ac.respondWithNewSecret(c, &user)
+}
+
+// LoginConfirmEnrollment finishes enrollment during login and issues the JWT
+// together with the recovery codes.
+func (ac *AuthController) LoginConfirmEnrollment(c *gin.Context) {
+	var input twoFactorEnrollInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := ac.userFromChallenge(c, input.EnrollmentToken, constants.TokenPurposeTwoFactorEnroll)
+	if !ok {
+		return
+	}
+	if user.HasTwoFactor() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if !ac.checkAccountThrottle(c, &user) {
+		return
+	}
+
+	codes, err := confirmTwoFactor(logging.RequestDB(c, ac.DB), &user, input.Code)
+	if errors.Is(err, errInvalidTwoFactorCode) {
+		ac.registerLoginFailure(c, &user, constants.LoginResultInvalidTwoFactor)
+	}
+	if !respondTwoFactorError(c, err) {
+		return
+	}
+
+	ac.issueSession(c, &user, gin.H{"recovery_codes": codes})
+}
+
+// GetTwoFactorStatus reports the caller's two-factor state.
+func (ac *AuthController) GetTwoFactorStatus(c *gin.Context) {
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+
+	required, err := twoFactorRequired(logging.RequestDB(c, ac.DB), user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor policy"})
+		return
+	}
+
+	var remaining int64
+	if err := logging.RequestDB(c, ac.DB).Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", user.ID).
+		Count(&remaining).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  user.HasTwoFactor(),
+		"required":                 required,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// StartEnrollment generates a new TOTP secret for the caller. It only takes
+// effect once confirmed with a code from the authenticator app.
+func (ac *AuthController) StartEnrollment(c *gin.Context) {
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.HasTwoFactor() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	ac.respondWithNewSecret(c, &user)
+}
+
+func (ac *AuthController) ConfirmEnrollment(c *gin.Context) {
+	var input twoFactorCodeInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.HasTwoFactor() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	codes, err := confirmTwoFactor(logging.RequestDB(c, ac.DB), &user, input.Code)
+	if !respondTwoFactorError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTwoFactor turns two-factor authentication off for the caller unless
+// their role requires it. A wrong password or code counts as a failed login,
+// so a stolen session cannot be used to guess the second factor.
+func (ac *AuthController) DisableTwoFactor(c *gin.Context) {
+	var input disableTwoFactorInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+	if !user.HasTwoFactor() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	required, err := twoFactorRequired(logging.RequestDB(c, ac.DB), user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor policy"})
+		return
+	}
+	if required {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for your role"})
+		return
+	}
+
+	if !ac.checkIPThrottle(c) || !ac.checkAccountThrottle(c, &user) {
+		return
+	}
+	if !utils.CheckPassword(input.Password, user.Password) {
+		ac.registerLoginFailure(c, &user, constants.LoginResultInvalidCredentials)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Password is incorrect"})
+		return
+	}
+	valid, err := useTOTPCode(logging.RequestDB(c, ac.DB), &user, input.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify two-factor code"})
+		return
+	}
+	if !valid {
+		ac.registerLoginFailure(c, &user, constants.LoginResultInvalidTwoFactor)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	if err := clearTwoFactor(logging.RequestDB(c, ac.DB), &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the caller. Wrong
+// codes count as failed logins.
+func (ac *AuthController) RegenerateRecoveryCodes(c *gin.Context) {
+	var input twoFactorCodeInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+	if !user.HasTwoFactor() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	if !ac.checkIPThrottle(c) || !ac.checkAccountThrottle(c, &user) {
+		return
+	}
+	valid, err := useTOTPCode(logging.RequestDB(c, ac.DB), &user, input.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify two-factor code"})
+		return
+	}
+	if !valid {
+		ac.registerLoginFailure(c, &user, constants.LoginResultInvalidTwoFactor)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	var codes []string
+	err = logging.RequestDB(c, ac.DB).Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// GetTwoFactorPolicies lists for every role whether two-factor
+// authentication is mandatory.
+func (ac *AuthController) GetTwoFactorPolicies(c *gin.Context) {
+	var policies []models.TwoFactorPolicy
+	if err := logging.RequestDB(c, ac.DB).Find(&policies).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load two-factor policies"})
+		return
+	}
+
+	byRole := map[string]models.TwoFactorPolicy{}
+	for _, policy := range policies {
+		byRole[policy.Role] = policy
+	}
+
+	var roles []models.Role
+	if err := logging.RequestDB(c, ac.DB).Order("name").Find(&roles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load two-factor policies"})
+		return
+	}
+
+	result := make([]models.TwoFactorPolicy, 0, len(roles))
+	for _, role := range roles {
+		policy, ok := byRole[role.Name]
+		if !ok {
+			policy = models.TwoFactorPolicy{Role: role.Name}
+		}
+		result = append(result, policy)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (ac *AuthController) UpdateTwoFactorPolicy(c *gin.Context) {
+	role := c.Param("role")
+	validRole, err := roleExists(logging.RequestDB(c, ac.DB), role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update two-factor policy"})
+		return
+	}
+	if !validRole {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	var input twoFactorPolicyInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy := models.TwoFactorPolicy{Role: role, Required: input.Required}
+	if err := logging.RequestDB(c, ac.DB).Save(&policy).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update two-factor policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// ResetTwoFactor lets an admin remove the second factor of a user who lost
+// both their device and their recovery codes.
+func (ac *AuthController) ResetTwoFactor(c *gin.Context) {
+	var user models.User
+	if err := logging.RequestDB(c, ac.DB).First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := clearTwoFactor(logging.RequestDB(c, ac.DB), &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (ac *AuthController) currentUser(c *gin.Context) (models.User, bool) {
+	var user models.User
+	if err := logging.RequestDB(c, ac.DB).First(&user, uint(c.GetFloat64("user_id"))).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return user, false
+	}
+	return user, true
+}
+
+// userFromChallenge resolves the user a login challenge token was issued to.
+func (ac *AuthController) userFromChallenge(c *gin.Context, token, purpose string) (models.User, bool) {
+	var user models.User
+
+	userID, version, err := utils.ParseChallengeJWT(token, purpose)
+	if err == nil {
+		err = logging.RequestDB(c, ac.DB).First(&user, userID).Error
+	}
+	if err != nil || user.TokenVersion != version || !user.IsActive() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token"})
+		return user, false
+	}
+	return user, true
+}
+
+func (ac *AuthController) respondWithNewSecret(c *gin.Context, user *models.User) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	if err := logging.RequestDB(c, ac.DB).Model(user).Update("totp_secret", secret).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": utils.TOTPProvisioningURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// respondTwoFactorError writes the response for an error returned by
+// confirmTwoFactor. It reports whether err was nil.
+func respondTwoFactorError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errTwoFactorNotStarted):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor enrollment has not been started"})
+	case errors.Is(err, errInvalidTwoFactorCode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+	}
+	return false
+}
+
+// confirmTwoFactor enables two-factor authentication once the user proved
+// they can generate codes for the pending secret, and returns fresh recovery
+// codes.
+func confirmTwoFactor(db *gorm.DB, user *models.User, code string) ([]string, error) {
+	if user.TOTPSecret == "" {
+		return nil, errTwoFactorNotStarted
+	}
+
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, errInvalidTwoFactorCode
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		user.TwoFactorEnabledAt = &now
+		user.TOTPLastStep = step
+		if err := tx.Model(user).Updates(map[string]any{
+			"two_factor_enabled_at": now,
+			"totp_last_step":        step,
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// useTOTPCode checks a TOTP code of user and records its time step so the
+// same code cannot be replayed.
+func useTOTPCode(db *gorm.DB, user *models.User, code string) (bool, error) {
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return false, nil
+	}
+
+	result := db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	user.TOTPLastStep = step
+	return result.RowsAffected == 1, nil
+}
+
+func useRecoveryCode(db *gorm.DB, userID uint, code string) (bool, error) {
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, utils.HashToken(utils.NormalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if err := tx.Create(&models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: utils.HashToken(utils.NormalizeRecoveryCode(code)),
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func clearTwoFactor(db *gorm.DB, user *models.User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		user.TOTPSecret = ""
+		user.TOTPLastStep = 0
+		user.TwoFactorEnabledAt = nil
+		if err := tx.Model(user).Updates(map[string]any{
+			"totp_secret":           "",
+			"totp_last_step":        0,
+			"two_factor_enabled_at": nil,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+func twoFactorRequired(db *gorm.DB, role string) (bool, error) {
+	var policy models.TwoFactorPolicy
+	if err := db.Where("role = ?", role).First(&policy).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return policy.Required, nil
+}
@@ -1,54 +1,353 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"taskmanager/authz"
+	"taskmanager/constants"
+	"taskmanager/logging"
 	"taskmanager/models"
+	"taskmanager/services"
+	"taskmanager/utils"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type UserController struct {
-	DB *gorm.DB
+	DB    *gorm.DB
+	Users *services.UserService
+}
+
+type offboardUserInput struct {
+	SuccessorID uint `json:"successor_id"`
 }
 
 func (uc *UserController) GetUsers(c *gin.Context) {
 	var users []models.User
-	uc.DB.Find(&users)
+	if err := logging.RequestDB(c, uc.DB).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load users"})
+		return
+	}
 	c.JSON(http.StatusOK, users)
 }
 
+func (uc *UserController) service(c *gin.Context) *services.UserService {
+	return uc.Users.WithContext(logging.RequestContext(c))
+}
+
+func (uc *UserController) CreateUser(c *gin.Context) {
+	var input services.CreateUserInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkGrantableRole(c, logging.RequestDB(c, uc.DB), input.Role, "Failed to create user") {
+		return
+	}
+
+	user, err := uc.service(c).Create(input)
+	if err != nil {
+		respondServiceError(c, err, "Failed to create user")
+		return
+	}
+
+	c.JSON(http.StatusCreated, user)
+}
+
 func (uc *UserController) UpdateUser(c *gin.Context) {
-	id := c.Param("id")
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var input services.UpdateUserInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Changing a role takes one from the user and gives them another, so
+	// the caller must hold the permissions of both.
+	if input.Role != "" {
+		current, err := uc.service(c).Get(uint(id))
+		if err != nil {
+			respondServiceError(c, err, "Failed to update user")
+			return
+		}
+		if current.Role != input.Role {
+			subject, ok := currentSubject(c, logging.RequestDB(c, uc.DB))
+			if !ok {
+				return
+			}
+			held, err := authz.SubjectFor(logging.RequestDB(c, uc.DB), current.ID, current.Role)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+				return
+			}
+			if !authz.CanGrant(subject, models.Role{Permissions: held.Permissions}) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change the role of a user with permissions you do not have"})
+				return
+			}
+			if !checkGrantableRole(c, logging.RequestDB(c, uc.DB), input.Role, "Failed to update user") {
+				return
+			}
+		}
+	}
+
+	user, err := uc.service(c).Update(uint(id), input)
+	if err != nil {
+		respondServiceError(c, err, "Failed to update user")
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (uc *UserController) DeactivateUser(c *gin.Context) {
+	adminID := uint(c.GetFloat64("user_id"))
+
+	var user models.User
+	if err := logging.RequestDB(c, uc.DB).First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.ID == adminID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot deactivate your own account"})
+		return
+	}
+
+	if user.IsActive() {
+		now := time.Now()
+		user.DeactivatedAt = &now
+		if err := logging.RequestDB(c, uc.DB).Model(&user).Update("deactivated_at", user.DeactivatedAt).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate user"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (uc *UserController) ReactivateUser(c *gin.Context) {
+	var user models.User
+	if err := logging.RequestDB(c, uc.DB).First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !user.IsActive() {
+		user.DeactivatedAt = nil
+		if err := logging.RequestDB(c, uc.DB).Model(&user).Update("deactivated_at", nil).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reactivate user"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UnlockUser lifts a login lockout and clears the failed attempt counter.
+func (uc *UserController) UnlockUser(c *gin.Context) {
 	var user models.User
+	if err := logging.RequestDB(c, uc.DB).First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := resetLoginFailures(logging.RequestDB(c, uc.DB), &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// GetLoginAttempts returns the most recent login attempts for a user.
+func (uc *UserController) GetLoginAttempts(c *gin.Context) {
+	var user models.User
+	if err := logging.RequestDB(c, uc.DB).First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var attempts []models.LoginAttempt
+	if err := logging.RequestDB(c, uc.DB).
+		Where("user_id = ?", user.ID).
+		Order("created_at DESC, id DESC").
+		Limit(100).
+		Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load login attempts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, attempts)
+}
+
+// DeleteUser removes an account that no longer owns any work. Users with open
+// tasks or direct reports have to be offboarded first so nothing is orphaned.
+func (uc *UserController) DeleteUser(c *gin.Context) {
+	adminID := uint(c.GetFloat64("user_id"))
+
+	var user models.User
+	if err := logging.RequestDB(c, uc.DB).First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.ID == adminID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot delete your own account"})
+		return
+	}
 
-	if err := uc.DB.First(&user, id).Error; err != nil {
+	var openTasks, reports int64
+	if err := logging.RequestDB(c, uc.DB).Model(&models.Task{}).
+		Where("assigned_to_id = ? AND status <> ?", user.ID, constants.TaskStatusApproved).
+		Count(&openTasks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+	if err := logging.RequestDB(c, uc.DB).Model(&models.User{}).Where("manager_id = ?", user.ID).Count(&reports).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+	if openTasks > 0 || reports > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "User still has open tasks or direct reports; offboard them first"})
+		return
+	}
+
+	if err := logging.RequestDB(c, uc.DB).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.TeamMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+	utils.InvalidateReportCache()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
+}
+
+// OffboardUser deactivates a user and hands their open tasks and direct
+// reports to a successor in a single transaction.
+func (uc *UserController) OffboardUser(c *gin.Context) {
+	adminID := uint(c.GetFloat64("user_id"))
+
+	var user models.User
+	if err := logging.RequestDB(c, uc.DB).First(&user, c.Param("id")).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	var input struct {
-		Role      string `json:"role"`
-		ManagerID *uint  `json:"manager_id"`
+	if user.ID == adminID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot offboard your own account"})
+		return
 	}
 
+	var input offboardUserInput
 	if err := c.BindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if input.SuccessorID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "successor_id is required"})
+		return
+	}
+	if input.SuccessorID == user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Successor must be a different user"})
+		return
+	}
 
-	// Simple validation to prevent a user from being their own manager
-	if input.ManagerID != nil && *input.ManagerID == user.ID {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User cannot be their own manager"})
+	var successor models.User
+	if err := logging.RequestDB(c, uc.DB).First(&successor, input.SuccessorID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Successor not found"})
+		return
+	}
+	if !successor.IsActive() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Successor is deactivated"})
 		return
 	}
 
-	if input.Role != "" {
-		user.Role = input.Role
+	// Moving the direct reports under someone deeper in the same subtree
+	// would create a reporting cycle.
+	successorIsDirectReport := successor.ManagerID != nil && *successor.ManagerID == user.ID
+	if !successorIsDirectReport {
+		reportIDs, err := utils.LoadReportIDs(user.ID, logging.RequestDB(c, uc.DB))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to offboard user"})
+			return
+		}
+		if slices.Contains(reportIDs, successor.ID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Successor cannot be an indirect report of the offboarded user"})
+			return
+		}
 	}
-	user.ManagerID = input.ManagerID
 
-	uc.DB.Save(&user)
+	var reassignedTasks, reassignedReports int64
+	now := time.Now()
 
-	c.JSON(http.StatusOK, user)
+	err := logging.RequestDB(c, uc.DB).Transaction(func(tx *gorm.DB) error {
+		var tasks []models.Task
+		if err := tx.Where("assigned_to_id = ? AND status <> ?", user.ID, constants.TaskStatusApproved).
+			Find(&tasks).Error; err != nil {
+			return err
+		}
+		for _, task := range tasks {
+			if err := tx.Model(&task).Updates(map[string]interface{}{
+				"assigned_to_id": successor.ID,
+				"version":        gorm.Expr("version + 1"),
+			}).Error; err != nil {
+				return err
+			}
+			audit := models.TaskAudit{
+				TaskID:   task.ID,
+				Action:   "reassigned",
+				ActorID:  adminID,
+				Comments: fmt.Sprintf("offboarded user %d; reassigned to %d", user.ID, successor.ID),
+			}
+			if err := tx.Create(&audit).Error; err != nil {
+				return err
+			}
+		}
+		reassignedTasks = int64(len(tasks))
+
+		if successorIsDirectReport {
+			// The successor takes the departing user's place in the hierarchy.
+			if err := tx.Model(&successor).Update("manager_id", user.ManagerID).Error; err != nil {
+				return err
+			}
+		}
+
+		result := tx.Model(&models.User{}).
+			Where("manager_id = ? AND id <> ?", user.ID, successor.ID).
+			Update("manager_id", successor.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		reassignedReports = result.RowsAffected
+
+		if user.IsActive() {
+			user.DeactivatedAt = &now
+			return tx.Model(&user).Update("deactivated_at", user.DeactivatedAt).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to offboard user"})
+		return
+	}
+	utils.InvalidateReportCache()
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":               user,
+		"successor_id":       successor.ID,
+		"reassigned_tasks":   reassignedTasks,
+		"reassigned_reports": reassignedReports,
+	})
 }
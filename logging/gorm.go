@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	gormutils "gorm.io/gorm/utils"
+)
+
+// GormLogger writes GORM's log through the logger of the context a query
+// runs with, so queries made with db.WithContext(ctx) during a request are
+// logged with its request ID and caller.
+//
+// Failed queries are logged as errors, except for ErrRecordNotFound which
+// callers handle, and queries slower than SlowThreshold as warnings. Every
+// other query is logged at debug level. Queries are logged with their
+// placeholders, never with the values bound to them, which include
+// password hashes and token hashes.
+type GormLogger struct {
+	SlowThreshold time.Duration
+
+	level gormlogger.LogLevel
+}
+
+// NewGormLogger returns a GormLogger at GORM's Warn level: errors and slow
+// queries. db.Debug() raises it to Info, which logs every query at info
+// level.
+func NewGormLogger(slowThreshold time.Duration) *GormLogger {
+	return &GormLogger{SlowThreshold: slowThreshold, level: gormlogger.Warn}
+}
+
+func (l *GormLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	copied := *l
+	copied.level = level
+	return &copied
+}
+
+func (l *GormLogger) Info(ctx context.Context, msg string, args ...any) {
+	if l.level >= gormlogger.Info {
+		FromContext(ctx).InfoContext(ctx, fmt.Sprintf(msg, args...), "caller", gormutils.FileWithLineNum())
+	}
+}
+
+func (l *GormLogger) Warn(ctx context.Context, msg string, args ...any) {
+	if l.level >= gormlogger.Warn {
+		FromContext(ctx).WarnContext(ctx, fmt.Sprintf(msg, args...), "caller", gormutils.FileWithLineNum())
+	}
+}
+
+func (l *GormLogger) Error(ctx context.Context, msg string, args ...any) {
+	if l.level >= gormlogger.Error {
+		FromContext(ctx).ErrorContext(ctx, fmt.Sprintf(msg, args...), "caller", gormutils.FileWithLineNum())
+	}
+}
+
+func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= gormlogger.Silent {
+		return
+	}
+	logger := FromContext(ctx)
+	elapsed := time.Since(begin)
+
+	var (
+		level slog.Level
+		msg   string
+		attrs []any
+	)
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= gormlogger.Error:
+		level, msg = slog.LevelError, "database query failed"
+		attrs = []any{"error", err.Error()}
+	case l.SlowThreshold > 0 && elapsed > l.SlowThreshold && l.level >= gormlogger.Warn:
+		level, msg = slog.LevelWarn, "slow database query"
+	case l.level >= gormlogger.Info:
+		level, msg = slog.LevelInfo, "database query"
+	default:
+		level, msg = slog.LevelDebug, "database query"
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	sql, rows := fc()
+	attrs = append(attrs,
+		"sql", sql,
+		"rows", rows,
+		"duration_ms", float64(elapsed.Microseconds())/1000,
+		"caller", gormutils.FileWithLineNum(),
+	)
+	logger.Log(ctx, level, msg, attrs...)
+}
+
+// ParamsFilter drops the values bound to a query before it is logged.
+func (l *GormLogger) ParamsFilter(ctx context.Context, sql string, params ...any) (string, []any) {
+	return sql, nil
+}
+
+// RequestDB returns db bound to the request handled by c, so that its
+// queries are logged with the request's logger.
+func RequestDB(c *gin.Context, db *gorm.DB) *gorm.DB {
+	return db.WithContext(RequestContext(c))
+}
+
+// RequestContext returns the context of the request handled by c without
+// its cancellation: a client hanging up must not abort a handler halfway
+// through its writes.
+func RequestContext(c *gin.Context) context.Context {
+	return context.WithoutCancel(c.Request.Context())
+}
@@ -0,0 +1,36 @@
+// Package logging provides the structured logger of the application and
+// carries request-scoped loggers through contexts.
+//
+// The logger of a request holds its request ID and, once authenticated, the
+// caller's user ID and role, so every line logged while handling it can be
+// traced back to both.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// New returns a logger writing JSON lines at level or above to w.
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger when
+// there is none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the ID of a request, from the client or a proxy
+// in front of the server, and back in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDPattern bounds the request IDs accepted from clients, which end
+// up in every log line of the request.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:/+=-]{1,128}$`)
+
+// Middleware gives every request an ID and a logger, and logs the request
+// once it has been handled. The ID is taken from the X-Request-ID header
+// when it looks like one, generated otherwise, and returned in the response
+// header. Handlers get the request's logger with FromContext on the request
+// context.
+func Middleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = rand.Text()
+		}
+		c.Header(RequestIDHeader, requestID)
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), logger.With("request_id", requestID)))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.Errors())
+		}
+		ctx := c.Request.Context()
+		FromContext(ctx).Log(ctx, level, "request", attrs...)
+	}
+}
+
+// With adds args to the logger of the request, for everything logged after
+// it. AuthMiddleware uses it to add the caller.
+func With(c *gin.Context, args ...any) {
+	ctx := c.Request.Context()
+	c.Request = c.Request.WithContext(NewContext(ctx, FromContext(ctx).With(args...)))
+}
+
+// Recovery turns a panic in a handler into a 500 response and logs it,
+// with the stack, through the logger of the request.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		FromContext(c.Request.Context()).Error("panic while handling request", "panic", fmt.Sprint(err), "stack", string(debug.Stack()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	})
+}
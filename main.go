@@ -1,10 +1,20 @@
 package main
 
 import (
+	"context"
+	"log"
+	"log/slog"
+	"net"
 	"os"
+	"os/signal"
+	"syscall"
+	"taskmanager/authz"
 	"taskmanager/config"
-	"taskmanager/models"
+	"taskmanager/logging"
+	"taskmanager/migrations"
 	"taskmanager/routes"
+	"taskmanager/utils"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,16 +22,59 @@ import (
 func main() {
 	_ = godotenv.Load()
 
-	db := config.ConnectDB()
-	db.AutoMigrate(
-		&models.Task{},
-		&models.TaskAudit{},
-		&models.User{},
-	)
-	r := routes.SetupRouter(db)
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8000"
-	}
-	r.Run(":" + port)
+	cfg, err := config.Load()
+	if err != nil {
+		// Not logged as JSON: the problems are listed one per line for
+		// whoever is fixing the configuration.
+		log.Fatalf("config: %v", err)
+	}
+	slog.SetDefault(logging.New(os.Stderr, cfg.Log.SlogLevel()))
+
+	db, err := config.ConnectDB(cfg.Database)
+	if err != nil {
+		fatal("failed to connect to the database", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] != "serve" {
+		if err := runCommand(db, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+			fatal(os.Args[1]+" failed", err)
+		}
+		return
+	}
+
+	if err := utils.InitJWTKeys(cfg.Auth.JWTKeysDir, cfg.Auth.JWTActiveKID, cfg.Production()); err != nil {
+		fatal("failed to load JWT keys", err)
+	}
+
+	// Schema changes are applied by "migrate up", not on boot, so that a
+	// release never serves against a schema it was not written for.
+	if err := migrations.Check(db); err != nil {
+		fatal("database schema is not up to date; run \"migrate up\" first", err)
+	}
+	if err := authz.SeedDefaultRoles(db); err != nil {
+		fatal("failed to seed roles", err)
+	}
+
+	srv := newHTTPServer(cfg.Server, routes.SetupRouter(db, cfg))
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		fatal("failed to listen", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := serve(ctx, srv, ln, backgroundWorkers(cfg, db), time.Duration(cfg.Server.ShutdownTimeout)); err != nil {
+		fatal("server failed", err)
+	}
+
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+	slog.Info("stopped")
+}
+
+// fatal logs err and exits with status 1.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,138 @@
+// Package metrics defines the Prometheus metrics of the application.
+//
+// Counters are package-level so that any layer can record events; the
+// registry that exposes them is built per database by NewRegistry, which
+// adds the metrics read from that database at scrape time.
+package metrics
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"taskmanager/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+const namespace = "taskmanager"
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests handled, by route and status.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	TasksCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_created_total",
+		Help:      "Tasks created.",
+	})
+
+	TaskReviews = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_reviews_total",
+		Help:      "Completed tasks reviewed, by outcome (approved or rejected).",
+	}, []string{"outcome"})
+
+	ExtensionRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_extension_requests_total",
+		Help:      "Deadline extensions requested by assignees.",
+	})
+
+	DeadlineExtensions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_deadline_extensions_total",
+		Help:      "Task deadlines extended.",
+	})
+)
+
+var overdueDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "tasks_overdue"),
+	"Unapproved tasks whose deadline has passed, by status.",
+	[]string{"status"}, nil,
+)
+
+// NewRegistry returns a registry holding the process and Go runtime
+// metrics, the counters above, and the connection pool statistics and
+// overdue task counts of db. Pool statistics are left out when db is not
+// backed by a connection pool, as inside a transaction.
+func NewRegistry(db *gorm.DB) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, HTTPRequestDuration,
+		TasksCreated, TaskReviews, ExtensionRequests, DeadlineExtensions,
+		overdueCollector{tasks: repository.GormTaskRepository{DB: db}},
+	)
+
+	if sqlDB, err := db.DB(); err == nil {
+		registry.MustRegister(collectors.NewDBStatsCollector(sqlDB, db.Dialector.Name()))
+	}
+	return registry
+}
+
+// Handler serves the metrics in registry. A failing collector is reported
+// in the log and leaves the other metrics intact.
+func Handler(registry *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		ErrorHandling: promhttp.ContinueOnError,
+		ErrorLog:      slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
+	})
+}
+
+// Middleware records HTTP request counts and latencies. Requests are
+// labelled with the route pattern, not the path, so that ids do not create
+// a series each.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		HTTPRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		HTTPRequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// overdueCollector counts overdue tasks when scraped, so the numbers are
+// current even when the overdue sweep is disabled.
+type overdueCollector struct {
+	tasks repository.TaskRepository
+}
+
+func (c overdueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- overdueDesc
+}
+
+func (c overdueCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := c.tasks.CountOverdue(time.Now())
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(overdueDesc, err)
+		return
+	}
+	for status, count := range counts {
+		ch <- prometheus.MustNewConstMetric(overdueDesc, prometheus.GaugeValue, float64(count), status)
+	}
+}
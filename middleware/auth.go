@@ -2,14 +2,20 @@ package middleware
 
 import (
 	"net/http"
+	"slices"
 	"strings"
+	"taskmanager/authz"
+	"taskmanager/logging"
+	"taskmanager/models"
 	"taskmanager/utils"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		authHeader := c.GetHeader("Authorization")
@@ -31,13 +37,13 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		tokenString := parts[1]
 
+		if utils.IsAccessToken(tokenString) {
+			authenticateAccessToken(c, logging.RequestDB(c, db), tokenString)
+			return
+		}
+
 		// Parse token
-		token, err := jwt.Parse(
-			tokenString,
-			func(token *jwt.Token) (interface{}, error) {
-				return utils.JwtSecret(), nil
-			},
-		)
+		token, err := utils.ParseJWT(tokenString)
 
 		if err != nil || !token.Valid {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
@@ -53,36 +59,137 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Deactivated users keep their unexpired tokens, so check the account on
+		// every request instead of trusting the claims alone. The role is read
+		// here too, so that a role change applies to existing sessions.
+		var user models.User
+		if err := logging.RequestDB(c, db).Select("id", "role", "deactivated_at", "token_version").First(&user, claims["user_id"]).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+		if !user.IsActive() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is deactivated"})
+			c.Abort()
+			return
+		}
+
+		// Password changes bump the token version, revoking older sessions.
+		version, _ := claims["ver"].(float64)
+		if uint(version) != user.TokenVersion {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			c.Abort()
+			return
+		}
+
 		c.Set("user_id", claims["user_id"])
-		c.Set("role", claims["role"])
+		c.Set("role", user.Role)
+		logging.With(c, "user_id", user.ID, "role", user.Role)
+		c.Next()
+	}
+}
+
+// accessTokenTouchInterval limits how often last_used_at is written for a
+// busy token.
+const accessTokenTouchInterval = time.Minute
+
+func authenticateAccessToken(c *gin.Context, db *gorm.DB, tokenString string) {
+	now := time.Now()
+
+	var accessToken models.AccessToken
+	if err := db.Where("token_hash = ?", utils.HashToken(tokenString)).First(&accessToken).Error; err != nil || !accessToken.IsUsable(now) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return
+	}
+
+	var user models.User
+	if err := db.Select("id", "role", "deactivated_at").First(&user, accessToken.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return
+	}
+	if !user.IsActive() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is deactivated"})
+		c.Abort()
+		return
+	}
+
+	// A stale last_used_at is no reason to turn the request away.
+	if err := db.Model(&models.AccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", accessToken.ID, now.Add(-accessTokenTouchInterval)).
+		Update("last_used_at", now).Error; err != nil {
+		logging.FromContext(c.Request.Context()).Warn("failed to record access token use", "token_id", accessToken.ID, "error", err)
+	}
+
+	// Same types as the JWT claims so handlers need not care how the caller
+	// authenticated.
+	c.Set("user_id", float64(user.ID))
+	c.Set("role", user.Role)
+	c.Set("token_scopes", accessToken.ScopeList())
+	logging.With(c, "user_id", user.ID, "role", user.Role, "token_id", accessToken.ID)
+	c.Next()
+}
+
+// ScopeMiddleware limits requests made with personal access tokens to the
+// granted scopes: GET and HEAD need readScope, anything else writeScope.
+// Requests authenticated with a JWT are not affected.
+func ScopeMiddleware(readScope, writeScope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, isAccessToken := c.Get("token_scopes")
+		if !isAccessToken {
+			c.Next()
+			return
+		}
+
+		required := writeScope
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			required = readScope
+		}
+
+		// Write access implies read access.
+		granted := scopes.([]string)
+		if !slices.Contains(granted, required) && !slices.Contains(granted, writeScope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing the " + required + " scope"})
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
 
-func RoleMiddleware(allowedRoles ...string) gin.HandlerFunc {
+// SessionOnlyMiddleware rejects personal access tokens on routes that manage
+// the account itself, such as passwords, 2FA and the tokens themselves.
+func SessionOnlyMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("role")
-		if !exists {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Role not found in context"})
+		if _, isAccessToken := c.Get("token_scopes"); isAccessToken {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint requires a login session"})
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
 
-		userRole := role.(string)
-		isAllowed := false
-		for _, allowedRole := range allowedRoles {
-			if userRole == allowedRole {
-				isAllowed = true
-				break
-			}
+// RequirePermission aborts with 403 unless the caller's role grants
+// permission. The loaded authz.Subject is stored as "subject" for handlers.
+func RequirePermission(db *gorm.DB, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subject, err := authz.SubjectFor(logging.RequestDB(c, db), uint(c.GetFloat64("user_id")), c.GetString("role"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions"})
+			c.Abort()
+			return
 		}
 
-		if !isAllowed {
+		if !subject.Has(permission) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to access this resource"})
 			c.Abort()
 			return
 		}
 
+		c.Set("subject", subject)
 		c.Next()
 	}
 }
@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"taskmanager/logging"
+	"taskmanager/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// IdempotencyTTL is how long a stored response is replayed for.
+const IdempotencyTTL = 24 * time.Hour
+
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders are stored with a response and sent again on replay.
+// Content-Type has a column of its own.
+var replayedHeaders = []string{"ETag", "Location"}
+
+// responseRecorder keeps a copy of the response body for storage.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware makes POST requests carrying an Idempotency-Key
+// header safe to retry. Keys are scoped to the caller, so it must run after
+// AuthMiddleware, and after the route's permission checks, so that a caller
+// who lost a permission is refused instead of replayed. The first request runs and its response is stored; replays
+// with the same method, path and body get the stored response, while reuse
+// of the key for a different request, or while the first is still running,
+// gets 409. Server errors are not stored so the client can retry them.
+func IdempotencyMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := sha256.New()
+		fingerprint.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+		fingerprint.Write(body)
+		requestHash := hex.EncodeToString(fingerprint.Sum(nil))
+
+		userID := uint(c.GetFloat64("user_id"))
+		now := time.Now()
+		db := logging.RequestDB(c, db)
+		logger := logging.FromContext(c.Request.Context())
+
+		var record models.IdempotencyRecord
+		err = db.Where("user_id = ? AND idempotency_key = ? AND expires_at > ?", userID, key, now).First(&record).Error
+		if err == nil {
+			replayIdempotent(c, &record, requestHash)
+			return
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check Idempotency-Key"})
+			c.Abort()
+			return
+		}
+
+		// Expired records are dropped as new keys come in, which also frees
+		// this key if it was used before.
+		if err := db.Where("expires_at <= ?", now).Delete(&models.IdempotencyRecord{}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check Idempotency-Key"})
+			c.Abort()
+			return
+		}
+
+		record = models.IdempotencyRecord{
+			UserID:      userID,
+			Key:         key,
+			RequestHash: requestHash,
+			ExpiresAt:   now.Add(IdempotencyTTL),
+		}
+		if err := db.Create(&record).Error; err != nil {
+			// Lost the race against a concurrent request with the same key
+			var existing models.IdempotencyRecord
+			if db.Where("user_id = ? AND idempotency_key = ?", userID, key).First(&existing).Error == nil {
+				replayIdempotent(c, &existing, requestHash)
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store Idempotency-Key"})
+			c.Abort()
+			return
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				if err := db.Delete(&record).Error; err != nil {
+					logger.Error("failed to release Idempotency-Key after a panic", "idempotency_key", key, "error", err)
+				}
+				panic(r)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			if err := db.Delete(&record).Error; err != nil {
+				logger.Error("failed to release Idempotency-Key after a server error; retries get 409 until it expires", "idempotency_key", key, "error", err)
+			}
+			return
+		}
+		headers := map[string]string{}
+		for _, name := range replayedHeaders {
+			if value := recorder.Header().Get(name); value != "" {
+				headers[name] = value
+			}
+		}
+		encodedHeaders, _ := json.Marshal(headers)
+		if err := db.Model(&record).Updates(map[string]interface{}{
+			"status_code":      status,
+			"content_type":     recorder.Header().Get("Content-Type"),
+			"response_body":    recorder.body.String(),
+			"response_headers": string(encodedHeaders),
+		}).Error; err != nil {
+			logger.Error("failed to store the response for Idempotency-Key; retries get 409 until it expires", "idempotency_key", key, "error", err)
+		}
+	}
+}
+
+func replayIdempotent(c *gin.Context, record *models.IdempotencyRecord, requestHash string) {
+	switch {
+	case record.RequestHash != requestHash:
+		c.JSON(http.StatusConflict, gin.H{"error": "Idempotency-Key was already used for a different request"})
+	case record.StatusCode == 0:
+		c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+	default:
+		// Records stored before headers were kept have none to replay
+		var headers map[string]string
+		_ = json.Unmarshal([]byte(record.ResponseHeaders), &headers)
+		for name, value := range headers {
+			c.Header(name, value)
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(record.StatusCode, record.ContentType, []byte(record.ResponseBody))
+	}
+	c.Abort()
+}
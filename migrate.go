@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"taskmanager/migrations"
+
+	"gorm.io/gorm"
+)
+
+const migrateUsage = "usage: migrate up | down [steps] | status"
+
+// runMigrate implements the "migrate" command.
+func runMigrate(db *gorm.DB, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+		ran, err := migrations.Up(db)
+		for _, migration := range ran {
+			fmt.Fprintf(out, "applied %d %s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(ran) == 0 {
+			fmt.Fprintln(out, "database is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("steps must be a positive number, got %q", args[1])
+			}
+			steps = n
+		} else if len(args) > 2 {
+			return errors.New(migrateUsage)
+		}
+		ran, err := migrations.Down(db, steps)
+		for _, migration := range ran {
+			fmt.Fprintf(out, "rolled back %d %s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(ran) == 0 {
+			fmt.Fprintln(out, "no migrations to roll back")
+		}
+		return err
+	case "status":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+		statuses, err := migrations.Statuses(db)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if status.Unknown {
+				state += " (unknown to this build)"
+			}
+			fmt.Fprintf(out, "%4d  %-30s %s\n", status.Version, status.Name, state)
+		}
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
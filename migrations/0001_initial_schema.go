@@ -0,0 +1,207 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// initialSchema is the schema AutoMigrate produced before migrations were
+// introduced. On a database created that way it only adds what is missing,
+// so existing installations adopt migrations by running "migrate up" once.
+var initialSchema = Migration{
+	Version: 1,
+	Name:    "initial_schema",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(initialTables()...)
+	},
+	Down: func(tx *gorm.DB) error {
+		// Dropped one by one on tx, children before the tables their
+		// foreign keys point to. Migrator.DropTable would use a connection
+		// of its own on MySQL.
+		for _, table := range []string{
+			"idempotency_records", "team_members", "teams", "departments",
+			"roles", "access_tokens", "oidc_login_states", "two_factor_policies",
+			"recovery_codes", "login_attempts", "invitations", "user_tokens",
+			"users", "task_audits", "tasks",
+		} {
+			if err := tx.Exec("DROP TABLE IF EXISTS ?", clause.Table{Name: table}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func initialTables() []interface{} {
+	type TaskAudit struct {
+		ID        uint `gorm:"primaryKey"`
+		TaskID    uint
+		Action    string
+		ActorID   uint
+		Comments  string
+		CreatedAt time.Time
+	}
+	type Task struct {
+		ID                         uint `gorm:"primaryKey"`
+		Title                      string
+		Description                string
+		Status                     string
+		Deadline                   *time.Time
+		DeadlineStatus             string `gorm:"default:'on_time'"`
+		ProgressPercentage         int    `gorm:"default:0"`
+		CreatedByID                uint
+		AssignedToID               uint
+		TeamID                     *uint `gorm:"index"`
+		ExtensionRequested         bool  `gorm:"default:false"`
+		ExtensionRequestedByID     *uint
+		ExtensionRequestedAt       *time.Time
+		ExtensionRequestedDeadline *time.Time
+		ExtensionReason            string
+		ExtensionApprovedByID      *uint
+		ExtensionApprovedAt        *time.Time
+		CompletedAt                *time.Time
+		CompletionLocked           bool `gorm:"default:false"`
+		PendingApprovalNotifiedAt  *time.Time
+		ApprovedByID               *uint
+		ApprovedAt                 *time.Time
+		RejectedByID               *uint
+		RejectedAt                 *time.Time
+		RejectionReason            string
+		Version                    uint `gorm:"not null;default:1"`
+		CreatedAt                  time.Time
+		AuditTrail                 []TaskAudit
+	}
+	type User struct {
+		ID                 uint `gorm:"primaryKey"`
+		Name               string
+		Email              string `gorm:"unique"`
+		Password           string
+		TokenVersion       uint   `gorm:"default:0"`
+		TOTPSecret         string `gorm:"size:64"`
+		TOTPLastStep       int64  `gorm:"default:0"`
+		TwoFactorEnabledAt *time.Time
+		OIDCIssuer         *string `gorm:"column:oidc_issuer;size:255;uniqueIndex:idx_users_oidc_identity"`
+		OIDCSubject        *string `gorm:"column:oidc_subject;size:255;uniqueIndex:idx_users_oidc_identity"`
+		Role               string
+		ServiceAccount     bool `gorm:"default:false"`
+		ManagerID          *uint
+		EmailVerifiedAt    *time.Time
+		CalendarTokenHash  *string `gorm:"size:64;uniqueIndex"`
+		DeactivatedAt      *time.Time
+		FailedLoginCount   int `gorm:"default:0"`
+		LastFailedLoginAt  *time.Time
+		LockedUntil        *time.Time
+		CreatedAt          time.Time
+	}
+	type UserToken struct {
+		ID        uint   `gorm:"primaryKey"`
+		UserID    uint   `gorm:"index"`
+		Purpose   string `gorm:"size:32"`
+		TokenHash string `gorm:"size:64;uniqueIndex"`
+		ExpiresAt time.Time
+		UsedAt    *time.Time
+		CreatedAt time.Time
+	}
+	type Invitation struct {
+		ID          uint   `gorm:"primaryKey"`
+		Email       string `gorm:"size:255;index"`
+		Name        string
+		Role        string
+		ManagerID   *uint
+		TokenHash   string `gorm:"size:64;uniqueIndex"`
+		InvitedByID uint
+		ExpiresAt   time.Time
+		AcceptedAt  *time.Time
+		CreatedAt   time.Time
+	}
+	type LoginAttempt struct {
+		ID        uint   `gorm:"primaryKey"`
+		Email     string `gorm:"size:255;index"`
+		UserID    *uint  `gorm:"index"`
+		IP        string `gorm:"size:64;index"`
+		Success   bool
+		Reason    string    `gorm:"size:32"`
+		CreatedAt time.Time `gorm:"index"`
+	}
+	type RecoveryCode struct {
+		ID        uint   `gorm:"primaryKey"`
+		UserID    uint   `gorm:"index"`
+		CodeHash  string `gorm:"size:64"`
+		UsedAt    *time.Time
+		CreatedAt time.Time
+	}
+	type TwoFactorPolicy struct {
+		Role      string `gorm:"primaryKey;size:64"`
+		Required  bool
+		UpdatedAt time.Time
+	}
+	type OIDCLoginState struct {
+		ID           uint   `gorm:"primaryKey"`
+		State        string `gorm:"size:64;uniqueIndex"`
+		Nonce        string `gorm:"size:64"`
+		CodeVerifier string `gorm:"size:128"`
+		ExpiresAt    time.Time
+		CreatedAt    time.Time
+	}
+	type AccessToken struct {
+		ID          uint   `gorm:"primaryKey"`
+		UserID      uint   `gorm:"index"`
+		Name        string `gorm:"size:100"`
+		TokenPrefix string `gorm:"size:16"`
+		TokenHash   string `gorm:"size:64;uniqueIndex"`
+		Scopes      string `gorm:"size:255"`
+		CreatedByID uint
+		ExpiresAt   *time.Time
+		LastUsedAt  *time.Time
+		RevokedAt   *time.Time
+		CreatedAt   time.Time
+	}
+	type Role struct {
+		Name        string `gorm:"primaryKey;size:64"`
+		Description string `gorm:"size:255"`
+		Permissions string `gorm:"type:text"`
+		BuiltIn     bool   `gorm:"default:false"`
+		CreatedAt   time.Time
+		UpdatedAt   time.Time
+	}
+	type TeamMember struct {
+		TeamID    uint `gorm:"primaryKey"`
+		UserID    uint `gorm:"primaryKey;index"`
+		IsLead    bool `gorm:"default:false"`
+		CreatedAt time.Time
+	}
+	type Team struct {
+		ID           uint   `gorm:"primaryKey"`
+		Name         string `gorm:"size:100;uniqueIndex"`
+		Description  string
+		DepartmentID *uint `gorm:"index"`
+		CreatedAt    time.Time
+		Members      []TeamMember
+	}
+	type Department struct {
+		ID          uint   `gorm:"primaryKey"`
+		Name        string `gorm:"size:100;uniqueIndex"`
+		Description string
+		CreatedAt   time.Time
+		Teams       []Team
+	}
+	type IdempotencyRecord struct {
+		ID           uint      `gorm:"primaryKey"`
+		UserID       uint      `gorm:"uniqueIndex:idx_idempotency_user_key"`
+		Key          string    `gorm:"column:idempotency_key;size:255;uniqueIndex:idx_idempotency_user_key"`
+		RequestHash  string    `gorm:"size:64"`
+		StatusCode   int       `gorm:"default:0"`
+		ContentType  string    `gorm:"size:100"`
+		ResponseBody string    `gorm:"type:text"`
+		ExpiresAt    time.Time `gorm:"index"`
+		CreatedAt    time.Time
+	}
+
+	return []interface{}{
+		&Task{}, &TaskAudit{}, &User{}, &UserToken{}, &Invitation{}, &LoginAttempt{},
+		&RecoveryCode{}, &TwoFactorPolicy{}, &OIDCLoginState{}, &AccessToken{}, &Role{},
+		&Department{}, &Team{}, &TeamMember{}, &IdempotencyRecord{},
+	}
+}
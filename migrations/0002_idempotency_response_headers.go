@@ -0,0 +1,22 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// idempotencyResponseHeaders stores the headers replayed along with a
+// response to an Idempotency-Key, such as the ETag of the task it returned.
+var idempotencyResponseHeaders = Migration{
+	Version: 2,
+	Name:    "idempotency_response_headers",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().AddColumn(&idempotencyRecordHeaders{}, "ResponseHeaders")
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropColumn(&idempotencyRecordHeaders{}, "ResponseHeaders")
+	},
+}
+
+type idempotencyRecordHeaders struct {
+	ResponseHeaders string `gorm:"type:text"`
+}
+
+func (idempotencyRecordHeaders) TableName() string { return "idempotency_records" }
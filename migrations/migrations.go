@@ -0,0 +1,187 @@
+// Package migrations versions the database schema. Migrations are numbered,
+// applied in order and each can be rolled back; the versions applied so far
+// are recorded in the schema_migrations table.
+//
+// A migration must not use the types in models: they describe the current
+// schema, a migration the change from one version to the next. Declare the
+// shape a migration needs inside it instead.
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration changes the schema from Version-1 to Version (Up) and back
+// (Down). Both run in a transaction, but MySQL commits DDL statements
+// implicitly, so a migration failing there halfway may need manual repair.
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// All lists every migration in version order. Append new ones; never
+// renumber or edit a migration that has been released.
+var All = []Migration{
+	initialSchema,
+	idempotencyResponseHeaders,
+}
+
+// SchemaMigration records an applied migration.
+type SchemaMigration struct {
+	Version   uint   `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255"`
+	AppliedAt time.Time
+}
+
+// Status describes a migration known to this build or recorded in the
+// database. AppliedAt is nil for pending migrations; Unknown is set for
+// applied versions this build has no migration for.
+type Status struct {
+	Version   uint
+	Name      string
+	AppliedAt *time.Time
+	Unknown   bool
+}
+
+// ErrPending is returned by Check when the database is not at the latest
+// version.
+var ErrPending = errors.New("database migrations are pending")
+
+// applied loads the applied migrations by version. It only reads, so that
+// checking the state is safe from readiness probes; a database without
+// the schema_migrations table has none applied.
+func applied(db *gorm.DB) (map[uint]SchemaMigration, error) {
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return map[uint]SchemaMigration{}, nil
+	}
+	var rows []SchemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("load schema_migrations: %w", err)
+	}
+	done := make(map[uint]SchemaMigration, len(rows))
+	for _, row := range rows {
+		done[row.Version] = row
+	}
+	return done, nil
+}
+
+// Up applies the pending migrations in order and returns those it applied.
+// It stops at the first failure.
+func Up(db *gorm.DB) ([]Migration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, migration := range All {
+		if _, ok := done[migration.Version]; ok {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return ran, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		ran = append(ran, migration)
+	}
+	return ran, nil
+}
+
+// Down rolls back the latest steps applied migrations, newest first, and
+// returns those it rolled back.
+func Down(db *gorm.DB, steps int) ([]Migration, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for i := len(All) - 1; i >= 0 && len(ran) < steps; i-- {
+		migration := All[i]
+		if _, ok := done[migration.Version]; !ok {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, migration.Version).Error
+		})
+		if err != nil {
+			return ran, fmt.Errorf("roll back migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		ran = append(ran, migration)
+	}
+	return ran, nil
+}
+
+// Statuses reports every known migration and every applied version, in
+// version order.
+func Statuses(db *gorm.DB) ([]Status, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(All))
+	for _, migration := range All {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if row, ok := done[migration.Version]; ok {
+			status.AppliedAt = &row.AppliedAt
+			delete(done, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, row := range done {
+		statuses = append(statuses, Status{Version: row.Version, Name: row.Name, AppliedAt: &row.AppliedAt, Unknown: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Check returns an error unless every migration has been applied and the
+// database has none this build does not know about, i.e. it was migrated by
+// a newer release.
+func Check(db *gorm.DB) error {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return err
+	}
+
+	var pending, unknown []string
+	for _, status := range statuses {
+		switch {
+		case status.Unknown:
+			unknown = append(unknown, fmt.Sprint(status.Version))
+		case status.AppliedAt == nil:
+			pending = append(pending, fmt.Sprintf("%d %s", status.Version, status.Name))
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("database has migrations this build does not know (%s); it was migrated by a newer release", strings.Join(unknown, ", "))
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %s", ErrPending, strings.Join(pending, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// AccessToken is a long-lived, scoped bearer token for scripts and service
+// accounts. Only the hash of the token is stored; TokenPrefix is kept so
+// users can tell their tokens apart.
+type AccessToken struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index" json:"user_id"`
+	Name        string     `gorm:"size:100" json:"name"`
+	TokenPrefix string     `gorm:"size:16" json:"token_prefix"`
+	TokenHash   string     `gorm:"size:64;uniqueIndex" json:"-"`
+	Scopes      string     `gorm:"size:255" json:"scopes"`
+	CreatedByID uint       `json:"created_by_id"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ScopeList returns the granted scopes. They are stored space separated, as
+// in OAuth.
+func (t *AccessToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// IsUsable reports whether the token may still authenticate requests.
+func (t *AccessToken) IsUsable(now time.Time) bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || now.Before(*t.ExpiresAt)
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// IdempotencyRecord remembers the response to a request sent with an
+// Idempotency-Key header so a retried request gets the same response instead
+// of running twice. A record with StatusCode 0 is still being processed.
+// ResponseHeaders holds the replayed headers, such as ETag, as a JSON object.
+type IdempotencyRecord struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	UserID          uint      `gorm:"uniqueIndex:idx_idempotency_user_key" json:"user_id"`
+	Key             string    `gorm:"column:idempotency_key;size:255;uniqueIndex:idx_idempotency_user_key" json:"key"`
+	RequestHash     string    `gorm:"size:64" json:"-"`
+	StatusCode      int       `gorm:"default:0" json:"status_code"`
+	ContentType     string    `gorm:"size:100" json:"-"`
+	ResponseBody    string    `gorm:"type:text" json:"-"`
+	ResponseHeaders string    `gorm:"type:text" json:"-"`
+	ExpiresAt       time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+}
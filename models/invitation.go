@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Invitation lets an admin pre-provision an account with a role. The invitee
+// picks their own password when accepting it.
+type Invitation struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Email       string     `gorm:"size:255;index" json:"email"`
+	Name        string     `json:"name"`
+	Role        string     `json:"role"`
+	ManagerID   *uint      `json:"manager_id"`
+	TokenHash   string     `gorm:"size:64;uniqueIndex" json:"-"`
+	InvitedByID uint       `json:"invited_by_id"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
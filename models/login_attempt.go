@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// LoginAttempt is the audit record of a single login attempt.
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Email     string    `gorm:"size:255;index" json:"email"`
+	UserID    *uint     `gorm:"index" json:"user_id"`
+	IP        string    `gorm:"size:64;index" json:"ip"`
+	Success   bool      `json:"success"`
+	Reason    string    `gorm:"size:32" json:"reason"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
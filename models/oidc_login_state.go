@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// OIDCLoginState keeps the per-login secrets of an authorization code flow
+// between the redirect to the identity provider and the callback.
+type OIDCLoginState struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	State        string    `gorm:"size:64;uniqueIndex" json:"-"`
+	Nonce        string    `gorm:"size:64" json:"-"`
+	CodeVerifier string    `gorm:"size:128" json:"-"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Role maps a role name to the permissions it grants. admin, manager and
+// member are seeded on startup; admins can define more.
+type Role struct {
+	Name        string     `gorm:"primaryKey;size:64" json:"name"`
+	Description string     `gorm:"size:255" json:"description"`
+	Permissions StringList `gorm:"type:text" json:"permissions"`
+	BuiltIn     bool       `gorm:"default:false" json:"built_in"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// StringList is stored as a space separated string and serialized as a JSON
+// array.
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	return strings.Join(l, " "), nil
+}
+
+func (l *StringList) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+	case string:
+		*l = strings.Fields(v)
+	case []byte:
+		*l = strings.Fields(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", value)
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ type Task struct {
 	ProgressPercentage         int         `gorm:"default:0" json:"progress_percentage"`
 	CreatedByID                uint        `json:"created_by_id"`
 	AssignedToID               uint        `json:"assigned_to_id"`
+	TeamID                     *uint       `gorm:"index" json:"team_id"`
 	ExtensionRequested         bool        `gorm:"default:false" json:"extension_requested"`
 	ExtensionRequestedByID     *uint       `json:"extension_requested_by_id"`
 	ExtensionRequestedAt       *time.Time  `json:"extension_requested_at"`
@@ -27,6 +28,7 @@ type Task struct {
 	RejectedByID               *uint       `json:"rejected_by_id"`
 	RejectedAt                 *time.Time  `json:"rejected_at"`
 	RejectionReason            string      `json:"rejection_reason"`
+	Version                    uint        `gorm:"not null;default:1" json:"version"`
 	CreatedAt                  time.Time   `json:"created_at"`
 	AuditTrail                 []TaskAudit `json:"audit_trail,omitempty"`
 }
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Department groups teams for organisation and reporting only. It has no
+// members or leads of its own and plays no part in who may see or assign
+// tasks; that is decided by teams.
+type Department struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"size:100;uniqueIndex" json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	Teams       []Team    `json:"teams,omitempty"`
+}
+
+// Team is a group of users that tasks can be assigned to as a whole. Any
+// member can pick up an unassigned team task; leads see and can assign the
+// work of their members.
+type Team struct {
+	ID           uint         `gorm:"primaryKey" json:"id"`
+	Name         string       `gorm:"size:100;uniqueIndex" json:"name"`
+	Description  string       `json:"description"`
+	DepartmentID *uint        `gorm:"index" json:"department_id"`
+	CreatedAt    time.Time    `json:"created_at"`
+	Members      []TeamMember `json:"members,omitempty"`
+}
+
+type TeamMember struct {
+	TeamID    uint      `gorm:"primaryKey" json:"team_id"`
+	UserID    uint      `gorm:"primaryKey;index" json:"user_id"`
+	IsLead    bool      `gorm:"default:false" json:"is_lead"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// RecoveryCode is a single-use fallback for a lost TOTP device. Only the hash
+// of the code is stored.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index" json:"user_id"`
+	CodeHash  string     `gorm:"size:64" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TwoFactorPolicy records whether users of a role must use two-factor
+// authentication.
+type TwoFactorPolicy struct {
+	Role      string    `gorm:"primaryKey;size:64" json:"role"`
+	Required  bool      `json:"required"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -3,11 +3,34 @@ package models
 import "time"
 
 type User struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `gorm:"unique" json:"email"`
-	Password  string    `json:"-"`
-	Role      string    `json:"role"`
-	ManagerID *uint     `json:"manager_id"`
-	CreatedAt time.Time `json:"created_at"`
+	ID                 uint       `gorm:"primaryKey" json:"id"`
+	Name               string     `json:"name"`
+	Email              string     `gorm:"unique" json:"email"`
+	Password           string     `json:"-"`
+	TokenVersion       uint       `gorm:"default:0" json:"-"`
+	TOTPSecret         string     `gorm:"size:64" json:"-"`
+	TOTPLastStep       int64      `gorm:"default:0" json:"-"`
+	TwoFactorEnabledAt *time.Time `json:"two_factor_enabled_at"`
+	OIDCIssuer         *string    `gorm:"column:oidc_issuer;size:255;uniqueIndex:idx_users_oidc_identity" json:"-"`
+	OIDCSubject        *string    `gorm:"column:oidc_subject;size:255;uniqueIndex:idx_users_oidc_identity" json:"-"`
+	Role               string     `json:"role"`
+	ServiceAccount     bool       `gorm:"default:false" json:"service_account"`
+	ManagerID          *uint      `json:"manager_id"`
+	EmailVerifiedAt    *time.Time `json:"email_verified_at"`
+	CalendarTokenHash  *string    `gorm:"size:64;uniqueIndex" json:"-"`
+	DeactivatedAt      *time.Time `json:"deactivated_at"`
+	FailedLoginCount   int        `gorm:"default:0" json:"failed_login_count"`
+	LastFailedLoginAt  *time.Time `json:"last_failed_login_at"`
+	LockedUntil        *time.Time `json:"locked_until"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// IsActive reports whether the user may still sign in.
+func (u User) IsActive() bool {
+	return u.DeactivatedAt == nil
+}
+
+// HasTwoFactor reports whether the user completed TOTP enrollment.
+func (u User) HasTwoFactor() bool {
+	return u.TwoFactorEnabledAt != nil
 }
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// UserToken is a single-use token handed to a user out of band, e.g. by
+// email. Only the hash of the token is stored.
+type UserToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index" json:"user_id"`
+	Purpose   string     `gorm:"size:32" json:"purpose"`
+	TokenHash string     `gorm:"size:64;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,298 @@
+package repository
+
+import (
+	"errors"
+	"taskmanager/authz"
+	"taskmanager/constants"
+	"taskmanager/models"
+	"taskmanager/utils"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GormUserRepository struct {
+	DB *gorm.DB
+}
+
+func (r GormUserRepository) User(id uint) (*models.User, error) {
+	var user models.User
+	err := r.DB.First(&user, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ReportIDs uses the cached hierarchy, see utils.GetRecursiveReportIDs.
+func (r GormUserRepository) ReportIDs(managerID uint) []uint {
+	return utils.GetRecursiveReportIDs(managerID, r.DB)
+}
+
+func (r GormUserRepository) IsTeamMember(userID, teamID uint) bool {
+	var count int64
+	err := r.DB.Model(&models.TeamMember{}).
+		Where("team_id = ? AND user_id = ?", teamID, userID).
+		Count(&count).Error
+	return err == nil && count > 0
+}
+
+func (r GormUserRepository) LeadsMember(leadID, memberID uint) bool {
+	var count int64
+	err := r.DB.Table("team_members AS members").
+		Joins("JOIN team_members leads ON leads.team_id = members.team_id").
+		Where("leads.user_id = ? AND leads.is_lead = ? AND members.user_id = ?", leadID, true, memberID).
+		Count(&count).Error
+	return err == nil && count > 0
+}
+
+func (r GormUserRepository) RolePermissions(role string) (models.StringList, error) {
+	var stored models.Role
+	err := r.DB.Where("name = ?", role).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stored.Permissions, nil
+}
+
+func (r GormUserRepository) FindByEmail(email string) (*models.User, error) {
+	var user models.User
+	err := r.DB.Where("email = ?", email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r GormUserRepository) EmailTaken(email string) (bool, error) {
+	var count int64
+	err := r.DB.Model(&models.User{}).Where("email = ?", email).Count(&count).Error
+	return count > 0, err
+}
+
+func (r GormUserRepository) LoadReportIDs(managerID uint) ([]uint, error) {
+	return utils.LoadReportIDs(managerID, r.DB)
+}
+
+func (r GormUserRepository) Create(user *models.User) error {
+	if err := r.DB.Create(user).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrEmailTaken
+		}
+		return err
+	}
+	utils.InvalidateReportCache()
+	return nil
+}
+
+func (r GormUserRepository) Update(user *models.User) error {
+	if err := r.DB.Save(user).Error; err != nil {
+		return err
+	}
+	utils.InvalidateReportCache()
+	return nil
+}
+
+type GormRoleRepository struct {
+	DB *gorm.DB
+}
+
+func (r GormRoleRepository) Exists(name string) (bool, error) {
+	var count int64
+	err := r.DB.Model(&models.Role{}).Where("name = ?", name).Count(&count).Error
+	return count > 0, err
+}
+
+type GormTeamRepository struct {
+	DB *gorm.DB
+}
+
+func (r GormTeamRepository) Exists(id uint) (bool, error) {
+	var count int64
+	err := r.DB.Model(&models.Team{}).Where("id = ?", id).Count(&count).Error
+	return count > 0, err
+}
+
+type GormTaskRepository struct {
+	DB *gorm.DB
+}
+
+func (r GormTaskRepository) FindByID(id uint) (*models.Task, error) {
+	var task models.Task
+	err := r.DB.First(&task, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (r GormTaskRepository) ListVisible(subject authz.Subject, filter TaskFilter) ([]models.Task, error) {
+	var tasks []models.Task
+	err := ScopeVisibleTasks(r.DB, subject, filterTasks(r.DB, filter)).Find(&tasks).Error
+	return tasks, err
+}
+
+func (r GormTaskRepository) ListClaimable(subject authz.Subject, filter TaskFilter) ([]models.Task, error) {
+	var tasks []models.Task
+	err := filterTasks(r.DB, filter).
+		Where("status <> ?", constants.TaskStatusApproved).
+		Where("assigned_to_id = ? AND team_id IN (SELECT team_id FROM team_members WHERE user_id = ?)", 0, subject.UserID).
+		Order("created_at, id").
+		Find(&tasks).Error
+	return tasks, err
+}
+
+func filterTasks(db *gorm.DB, filter TaskFilter) *gorm.DB {
+	if filter.TeamID != nil {
+		return db.Where("team_id = ?", *filter.TeamID)
+	}
+	return db
+}
+
+// teamTasksCondition matches tasks of the caller's teams and tasks assigned
+// to members of teams the caller leads. Its arguments are the caller's id
+// twice, then true.
+const teamTasksCondition = `team_id IN (SELECT team_id FROM team_members WHERE user_id = ?)
+	OR assigned_to_id IN (
+		SELECT members.user_id FROM team_members members
+		JOIN team_members leads ON leads.team_id = members.team_id
+		WHERE leads.user_id = ? AND leads.is_lead = ?
+	)`
+
+// ScopeVisibleTasks restricts query to the tasks subject may see. It is the
+// SQL form of authz.Can for constants.ActionTaskView.
+func ScopeVisibleTasks(db *gorm.DB, subject authz.Subject, query *gorm.DB) *gorm.DB {
+	if subject.Has(constants.PermTaskViewAll) {
+		return query
+	}
+	if subject.Has(constants.PermTaskViewReports) {
+		reportIDs := utils.GetRecursiveReportIDs(subject.UserID, db)
+		return query.Where(
+			"assigned_to_id IN ? OR created_by_id = ? OR assigned_to_id = ? OR "+teamTasksCondition,
+			reportIDs, subject.UserID, subject.UserID, subject.UserID, subject.UserID, true,
+		)
+	}
+	return query.Where(
+		"created_by_id = ? OR assigned_to_id = ? OR "+teamTasksCondition,
+		subject.UserID, subject.UserID, subject.UserID, subject.UserID, true,
+	)
+}
+
+func (r GormTaskRepository) Create(task *models.Task) error {
+	return r.DB.Create(task).Error
+}
+
+func (r GormTaskRepository) Update(task *models.Task) error {
+	return saveTask(r.DB, task)
+}
+
+func (r GormTaskRepository) UpdateWithAudit(task *models.Task, audit *models.TaskAudit) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.Task
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "version").First(&current, task.ID).Error; err != nil {
+			return err
+		}
+		if current.Version != task.Version {
+			return ErrVersionConflict
+		}
+		if err := saveTask(tx, task); err != nil {
+			return err
+		}
+		return tx.Create(audit).Error
+	})
+}
+
+// saveTask writes every column of the task and bumps its version, unless
+// someone else saved it since it was loaded.
+func saveTask(db *gorm.DB, task *models.Task) error {
+	loaded := task.Version
+	task.Version++
+	result := db.Model(task).Where("version = ?", loaded).
+		Select("*").Omit(clause.Associations).Updates(task)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = ErrVersionConflict
+	}
+	if result.Error != nil {
+		task.Version = loaded
+	}
+	return result.Error
+}
+
+// Claim is a conditional update, which the database serializes.
+func (r GormTaskRepository) Claim(taskID, userID uint) (bool, error) {
+	result := r.DB.Model(&models.Task{}).
+		Where("id = ? AND assigned_to_id = ?", taskID, 0).
+		Updates(map[string]interface{}{
+			"assigned_to_id": userID,
+			"status": gorm.Expr("CASE WHEN status = ? THEN ? ELSE status END",
+				constants.TaskStatusCreated, constants.TaskStatusAssigned),
+			"version": gorm.Expr("version + 1"),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r GormTaskRepository) UpdateDeadlineStatus(task *models.Task) error {
+	return r.DB.Model(task).Update("deadline_status", task.DeadlineStatus).Error
+}
+
+func (r GormTaskRepository) MarkOverdue(now time.Time) (int64, error) {
+	result := r.DB.Model(&models.Task{}).
+		Where("deadline IS NOT NULL AND deadline < ? AND deadline_status <> ?", now, constants.DeadlineStatusOverdue).
+		Update("deadline_status", constants.DeadlineStatusOverdue)
+	return result.RowsAffected, result.Error
+}
+
+func (r GormTaskRepository) CountOverdue(now time.Time) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := r.DB.Model(&models.Task{}).
+		Select("status, COUNT(*) AS count").
+		Where("deadline IS NOT NULL AND deadline < ? AND status <> ?", now, constants.TaskStatusApproved).
+		Group("status").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+func (r GormTaskRepository) Delete(id uint) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("task_id = ?", id).Delete(&models.TaskAudit{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Task{}, id).Error
+	})
+}
+
+type GormAuditRepository struct {
+	DB *gorm.DB
+}
+
+func (r GormAuditRepository) ListByTasks(taskIDs []uint) ([]models.TaskAudit, error) {
+	var audits []models.TaskAudit
+	if len(taskIDs) == 0 {
+		return audits, nil
+	}
+	err := r.DB.Where("task_id IN ?", taskIDs).Order("id").Find(&audits).Error
+	return audits, err
+}
@@ -0,0 +1,400 @@
+package repository
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"taskmanager/authz"
+	"taskmanager/constants"
+	"taskmanager/models"
+	"time"
+)
+
+// MemoryStore keeps users, roles, teams, tasks and audits in memory. It backs the
+// Memory* repositories, which behave like their GORM counterparts, for fast
+// tests that need no database.
+type MemoryStore struct {
+	mu      sync.Mutex
+	users   map[uint]models.User
+	roles   map[string]models.Role
+	teams   map[uint]models.Team
+	members []models.TeamMember
+	tasks   map[uint]models.Task
+	audits  []models.TaskAudit
+	nextID  uint
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users: map[uint]models.User{},
+		roles: map[string]models.Role{},
+		teams: map[uint]models.Team{},
+		tasks: map[uint]models.Task{},
+	}
+}
+
+// AddUser stores user, assigning an id when it has none.
+func (s *MemoryStore) AddUser(user *models.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user.ID == 0 {
+		user.ID = s.newID()
+	}
+	s.users[user.ID] = *user
+}
+
+func (s *MemoryStore) AddRole(role models.Role) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles[role.Name] = role
+}
+
+// AddTeam stores team, assigning an id when it has none.
+func (s *MemoryStore) AddTeam(team *models.Team) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if team.ID == 0 {
+		team.ID = s.newID()
+	}
+	s.teams[team.ID] = *team
+}
+
+func (s *MemoryStore) AddTeamMember(member models.TeamMember) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.members = append(s.members, member)
+}
+
+func (s *MemoryStore) Users() UserRepository   { return memoryUsers{s} }
+func (s *MemoryStore) Roles() RoleRepository   { return memoryRoles{s} }
+func (s *MemoryStore) Teams() TeamRepository   { return memoryTeams{s} }
+func (s *MemoryStore) Tasks() TaskRepository   { return memoryTasks{s} }
+func (s *MemoryStore) Audits() AuditRepository { return memoryAudits{s} }
+
+// newID hands out ids from one sequence for every kind of record, which is
+// enough for tests. The caller must hold mu.
+func (s *MemoryStore) newID() uint {
+	s.nextID++
+	return s.nextID
+}
+
+type memoryUsers struct {
+	s *MemoryStore
+}
+
+func (r memoryUsers) User(id uint) (*models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	user, ok := r.s.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (r memoryUsers) FindByEmail(email string) (*models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, user := range r.s.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r memoryUsers) EmailTaken(email string) (bool, error) {
+	_, err := r.FindByEmail(email)
+	return err == nil, nil
+}
+
+func (r memoryUsers) LoadReportIDs(managerID uint) ([]uint, error) {
+	return r.ReportIDs(managerID), nil
+}
+
+func (r memoryUsers) Create(user *models.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, existing := range r.s.users {
+		if existing.Email == user.Email {
+			return ErrEmailTaken
+		}
+	}
+	user.ID = r.s.newID()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	r.s.users[user.ID] = *user
+	return nil
+}
+
+func (r memoryUsers) Update(user *models.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.users[user.ID]; !ok {
+		return ErrNotFound
+	}
+	r.s.users[user.ID] = *user
+	return nil
+}
+
+func (r memoryUsers) ReportIDs(managerID uint) []uint {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	seen := map[uint]bool{managerID: true}
+	var reportIDs []uint
+	queue := []uint{managerID}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, user := range r.s.users {
+			if user.ManagerID != nil && *user.ManagerID == current && !seen[user.ID] {
+				seen[user.ID] = true
+				reportIDs = append(reportIDs, user.ID)
+				queue = append(queue, user.ID)
+			}
+		}
+	}
+	return reportIDs
+}
+
+func (r memoryUsers) IsTeamMember(userID, teamID uint) bool {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, member := range r.s.members {
+		if member.UserID == userID && member.TeamID == teamID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r memoryUsers) LeadsMember(leadID, memberID uint) bool {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, lead := range r.s.members {
+		if lead.UserID != leadID || !lead.IsLead {
+			continue
+		}
+		for _, member := range r.s.members {
+			if member.TeamID == lead.TeamID && member.UserID == memberID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r memoryUsers) RolePermissions(role string) (models.StringList, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.roles[role].Permissions, nil
+}
+
+type memoryRoles struct {
+	s *MemoryStore
+}
+
+func (r memoryRoles) Exists(name string) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	_, ok := r.s.roles[name]
+	return ok, nil
+}
+
+type memoryTeams struct {
+	s *MemoryStore
+}
+
+func (r memoryTeams) Exists(id uint) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	_, ok := r.s.teams[id]
+	return ok, nil
+}
+
+type memoryTasks struct {
+	s *MemoryStore
+}
+
+func (r memoryTasks) FindByID(id uint) (*models.Task, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	task, ok := r.s.tasks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &task, nil
+}
+
+// sorted returns the tasks matching filter ordered by creation, then id.
+func (r memoryTasks) sorted(filter TaskFilter) []models.Task {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	tasks := make([]models.Task, 0, len(r.s.tasks))
+	for _, task := range r.s.tasks {
+		if filter.TeamID != nil && (task.TeamID == nil || *task.TeamID != *filter.TeamID) {
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].CreatedAt.Equal(tasks[j].CreatedAt) {
+			return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+	return tasks
+}
+
+func (r memoryTasks) ListVisible(subject authz.Subject, filter TaskFilter) ([]models.Task, error) {
+	users := memoryUsers{r.s}
+	var visible []models.Task
+	for _, task := range r.sorted(filter) {
+		if authz.Can(users, subject, constants.ActionTaskView, &task) {
+			visible = append(visible, task)
+		}
+	}
+	return visible, nil
+}
+
+func (r memoryTasks) ListClaimable(subject authz.Subject, filter TaskFilter) ([]models.Task, error) {
+	users := memoryUsers{r.s}
+	var claimable []models.Task
+	for _, task := range r.sorted(filter) {
+		if task.Status != constants.TaskStatusApproved && authz.Can(users, subject, constants.ActionTaskClaim, &task) {
+			claimable = append(claimable, task)
+		}
+	}
+	return claimable, nil
+}
+
+func (r memoryTasks) Create(task *models.Task) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	task.ID = r.s.newID()
+	if task.Version == 0 {
+		task.Version = 1
+	}
+	if task.CreatedAt.IsZero() {
+		task.CreatedAt = time.Now()
+	}
+	r.s.tasks[task.ID] = *task
+	return nil
+}
+
+func (r memoryTasks) Update(task *models.Task) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.update(task)
+}
+
+// update is Update for callers holding mu.
+func (r memoryTasks) update(task *models.Task) error {
+	current, ok := r.s.tasks[task.ID]
+	if !ok || current.Version != task.Version {
+		return ErrVersionConflict
+	}
+	task.Version++
+	stored := *task
+	stored.AuditTrail = nil
+	r.s.tasks[task.ID] = stored
+	return nil
+}
+
+func (r memoryTasks) UpdateWithAudit(task *models.Task, audit *models.TaskAudit) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if err := r.update(task); err != nil {
+		return err
+	}
+	audit.ID = r.s.newID()
+	if audit.CreatedAt.IsZero() {
+		audit.CreatedAt = time.Now()
+	}
+	r.s.audits = append(r.s.audits, *audit)
+	return nil
+}
+
+func (r memoryTasks) Claim(taskID, userID uint) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	task, ok := r.s.tasks[taskID]
+	if !ok || task.AssignedToID != 0 {
+		return false, nil
+	}
+	task.AssignedToID = userID
+	if task.Status == constants.TaskStatusCreated {
+		task.Status = constants.TaskStatusAssigned
+	}
+	task.Version++
+	r.s.tasks[taskID] = task
+	return true, nil
+}
+
+func (r memoryTasks) UpdateDeadlineStatus(task *models.Task) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if current, ok := r.s.tasks[task.ID]; ok {
+		current.DeadlineStatus = task.DeadlineStatus
+		r.s.tasks[task.ID] = current
+	}
+	return nil
+}
+
+func (r memoryTasks) MarkOverdue(now time.Time) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var changed int64
+	for id, task := range r.s.tasks {
+		if task.Deadline != nil && task.Deadline.Before(now) && task.DeadlineStatus != constants.DeadlineStatusOverdue {
+			task.DeadlineStatus = constants.DeadlineStatusOverdue
+			r.s.tasks[id] = task
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+func (r memoryTasks) CountOverdue(now time.Time) (map[string]int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	counts := map[string]int64{}
+	for _, task := range r.s.tasks {
+		if task.Deadline != nil && task.Deadline.Before(now) && task.Status != constants.TaskStatusApproved {
+			counts[task.Status]++
+		}
+	}
+	return counts, nil
+}
+
+func (r memoryTasks) Delete(id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	delete(r.s.tasks, id)
+	r.s.audits = slices.DeleteFunc(r.s.audits, func(audit models.TaskAudit) bool {
+		return audit.TaskID == id
+	})
+	return nil
+}
+
+type memoryAudits struct {
+	s *MemoryStore
+}
+
+func (r memoryAudits) ListByTasks(taskIDs []uint) ([]models.TaskAudit, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	wanted := map[uint]bool{}
+	for _, id := range taskIDs {
+		wanted[id] = true
+	}
+	var audits []models.TaskAudit
+	for _, audit := range r.s.audits {
+		if wanted[audit.TaskID] {
+			audits = append(audits, audit)
+		}
+	}
+	return audits, nil
+}
@@ -0,0 +1,85 @@
+// Package repository hides storage behind interfaces, so services run
+// against the database through GORM in production and against memory in
+// tests.
+package repository
+
+import (
+	"errors"
+	"taskmanager/authz"
+	"taskmanager/models"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a record does not exist.
+	ErrNotFound = errors.New("record not found")
+	// ErrVersionConflict is returned when a task changed after it was loaded.
+	ErrVersionConflict = errors.New("task version conflict")
+	// ErrEmailTaken is returned when creating a user whose email is already
+	// registered.
+	ErrEmailTaken = errors.New("email is already registered")
+)
+
+// TaskFilter narrows task listings. Nil fields match everything.
+type TaskFilter struct {
+	TeamID *uint
+}
+
+// UserRepository stores users and answers questions about their reporting
+// and team relationships.
+type UserRepository interface {
+	authz.Directory
+	FindByEmail(email string) (*models.User, error)
+	EmailTaken(email string) (bool, error)
+	// LoadReportIDs is ReportIDs without the cache. It fails instead of
+	// returning no reports, for checks where a stale answer is not
+	// acceptable.
+	LoadReportIDs(managerID uint) ([]uint, error)
+	// Create fails with ErrEmailTaken when the email is already registered.
+	Create(user *models.User) error
+	Update(user *models.User) error
+}
+
+type RoleRepository interface {
+	Exists(name string) (bool, error)
+}
+
+type TeamRepository interface {
+	Exists(id uint) (bool, error)
+}
+
+type TaskRepository interface {
+	FindByID(id uint) (*models.Task, error)
+	// ListVisible returns the tasks subject may see, without audit trails.
+	ListVisible(subject authz.Subject, filter TaskFilter) ([]models.Task, error)
+	// ListClaimable returns the unassigned, unapproved tasks of subject's
+	// teams, oldest first.
+	ListClaimable(subject authz.Subject, filter TaskFilter) ([]models.Task, error)
+	Create(task *models.Task) error
+	// Update writes every field of task and bumps its version, failing with
+	// ErrVersionConflict when the stored version no longer matches.
+	Update(task *models.Task) error
+	// UpdateWithAudit is Update plus audit, atomically and holding the
+	// task's row lock.
+	UpdateWithAudit(task *models.Task, audit *models.TaskAudit) error
+	// Claim assigns an unassigned task to userID, reporting false when it
+	// was already assigned. Of concurrent claims exactly one succeeds.
+	Claim(taskID, userID uint) (bool, error)
+	// UpdateDeadlineStatus stores the derived deadline status without
+	// bumping the version.
+	UpdateDeadlineStatus(task *models.Task) error
+	// MarkOverdue sets deadline_status to overdue on every task whose
+	// deadline is before now, without bumping versions, and returns how many
+	// changed.
+	MarkOverdue(now time.Time) (int64, error)
+	// CountOverdue counts the unapproved tasks whose deadline is before
+	// now, by status.
+	CountOverdue(now time.Time) (map[string]int64, error)
+	// Delete removes the task along with its audit trail.
+	Delete(id uint) error
+}
+
+type AuditRepository interface {
+	// ListByTasks returns the audit entries of the given tasks, oldest first.
+	ListByTasks(taskIDs []uint) ([]models.TaskAudit, error)
+}
@@ -1,43 +1,178 @@
 package routes
 
 import (
+	"log/slog"
+	"taskmanager/config"
 	"taskmanager/constants"
 	"taskmanager/controllers"
+	"taskmanager/logging"
 
+	"taskmanager/mail"
+	"taskmanager/metrics"
 	"taskmanager/middleware"
+	"taskmanager/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func SetupRouter(db *gorm.DB) *gin.Engine {
-	r := gin.Default()
+func SetupRouter(db *gorm.DB, cfg *config.Config) *gin.Engine {
+	r := gin.New()
+	// Gin trusts every proxy by default, which would let any client pick
+	// the IP that login throttling and audit records see. The list was
+	// validated with the configuration.
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		panic(err)
+	}
+	r.Use(logging.Middleware(slog.Default()), logging.Recovery(), metrics.Middleware())
+
+	healthController := controllers.HealthController{DB: db}
+	r.GET("/healthz", healthController.Live)
+	r.GET("/readyz", healthController.Ready)
+	r.GET("/metrics", middleware.AuthMiddleware(db), middleware.ScopeMiddleware(constants.ScopeMetricsRead, constants.ScopeMetricsRead), middleware.RequirePermission(db, constants.PermMetricsRead), gin.WrapH(metrics.Handler(metrics.NewRegistry(db))))
 
-	taskController := controllers.TaskController{DB: db}
+	taskController := controllers.TaskController{DB: db, Tasks: services.NewGormTaskService(db)}
 	taskRoutes := r.Group("/tasks")
-	taskRoutes.Use(middleware.AuthMiddleware())
+	taskRoutes.Use(middleware.AuthMiddleware(db), middleware.ScopeMiddleware(constants.ScopeTasksRead, constants.ScopeTasksWrite))
+	// Replays go through the route's permission check first.
+	idempotent := middleware.IdempotencyMiddleware(db)
 	{
-		taskRoutes.POST("", middleware.RoleMiddleware(constants.RoleAdmin, constants.RoleManager), taskController.CreateTask)
+		taskRoutes.POST("", middleware.RequirePermission(db, constants.PermTaskCreate), idempotent, taskController.CreateTask)
 		taskRoutes.GET("", taskController.GetTasks)
+		taskRoutes.GET("/pool", taskController.GetPool)
 		taskRoutes.GET("/:id", taskController.GetTask)
+		taskRoutes.POST("/:id/claim", idempotent, taskController.ClaimTask)
 		taskRoutes.PUT("/:id", taskController.UpdateTask)
-		taskRoutes.POST("/:id/request-extension", middleware.RoleMiddleware(constants.RoleMember), taskController.RequestExtension)
-		taskRoutes.POST("/:id/extend-deadline", middleware.RoleMiddleware(constants.RoleAdmin, constants.RoleManager), taskController.ExtendDeadline)
-		taskRoutes.POST("/:id/approve", middleware.RoleMiddleware(constants.RoleAdmin, constants.RoleManager), taskController.ApproveTask)
-		taskRoutes.POST("/:id/reject", middleware.RoleMiddleware(constants.RoleAdmin, constants.RoleManager), taskController.RejectTask)
-		taskRoutes.DELETE("/:id", middleware.RoleMiddleware(constants.RoleAdmin), taskController.DeleteTask)
+		taskRoutes.POST("/:id/request-extension", middleware.RequirePermission(db, constants.PermTaskRequestExtension), idempotent, taskController.RequestExtension)
+		taskRoutes.POST("/:id/extend-deadline", idempotent, taskController.ExtendDeadline)
+		taskRoutes.POST("/:id/approve", middleware.RequirePermission(db, constants.PermTaskApprove), idempotent, taskController.ApproveTask)
+		taskRoutes.POST("/:id/reject", middleware.RequirePermission(db, constants.PermTaskApprove), idempotent, taskController.RejectTask)
+		taskRoutes.DELETE("/:id", middleware.RequirePermission(db, constants.PermTaskDelete), taskController.DeleteTask)
 	}
 
-	authController := controllers.AuthController{DB: db}
+	mailer := mail.NewSender(cfg.Mail)
+
+	authController := controllers.AuthController{DB: db, Mailer: mailer, BaseURL: cfg.Server.BaseURL, RequireEmailVerification: cfg.Auth.RequireEmailVerification}
 	r.POST("/register", authController.Register)
 	r.POST("/login", authController.Login)
+	r.GET("/verify-email", authController.VerifyEmail)
+	r.POST("/verify-email/resend", middleware.AuthMiddleware(db), middleware.SessionOnlyMiddleware(), authController.ResendVerification)
+	r.POST("/password/forgot", authController.ForgotPassword)
+	r.POST("/password/reset", authController.ResetPassword)
+	r.POST("/password/change", middleware.AuthMiddleware(db), middleware.SessionOnlyMiddleware(), authController.ChangePassword)
+	r.POST("/login/2fa", authController.LoginTwoFactor)
+	r.POST("/login/2fa/enroll", authController.LoginStartEnrollment)
+	r.POST("/login/2fa/enroll/confirm", authController.LoginConfirmEnrollment)
+	r.GET("/.well-known/jwks.json", authController.JWKS)
+
+	twoFactorRoutes := r.Group("/2fa")
+	twoFactorRoutes.Use(middleware.AuthMiddleware(db), middleware.SessionOnlyMiddleware())
+	{
+		twoFactorRoutes.GET("", authController.GetTwoFactorStatus)
+		twoFactorRoutes.POST("/enroll", authController.StartEnrollment)
+		twoFactorRoutes.POST("/confirm", authController.ConfirmEnrollment)
+		twoFactorRoutes.POST("/disable", authController.DisableTwoFactor)
+		twoFactorRoutes.POST("/recovery-codes", authController.RegenerateRecoveryCodes)
+	}
+
+	oidcController := controllers.OIDCController{DB: db, Config: cfg.OIDC}
+	r.GET("/auth/oidc/login", oidcController.Login)
+	r.GET("/auth/oidc/callback", oidcController.Callback)
+
+	invitationController := controllers.InvitationController{DB: db, Mailer: mailer, BaseURL: cfg.Server.BaseURL}
+	r.GET("/invitations/accept", invitationController.GetInvitation)
+	r.POST("/invitations/accept", invitationController.AcceptInvitation)
 
-	userController := controllers.UserController{DB: db}
+	userController := controllers.UserController{DB: db, Users: services.NewGormUserService(db)}
 	userRoutes := r.Group("/users")
-	userRoutes.Use(middleware.AuthMiddleware(), middleware.RoleMiddleware(constants.RoleAdmin))
+	userRoutes.Use(middleware.AuthMiddleware(db), middleware.RequirePermission(db, constants.PermUserManage), middleware.ScopeMiddleware(constants.ScopeUsersRead, constants.ScopeUsersWrite))
 	{
 		userRoutes.GET("", userController.GetUsers)
+		userRoutes.POST("", userController.CreateUser)
+		userRoutes.GET("/invitations", invitationController.GetInvitations)
+		userRoutes.POST("/invitations", invitationController.CreateInvitation)
+		userRoutes.DELETE("/invitations/:id", invitationController.RevokeInvitation)
+		userRoutes.GET("/2fa-policy", authController.GetTwoFactorPolicies)
+		userRoutes.PUT("/2fa-policy/:role", authController.UpdateTwoFactorPolicy)
 		userRoutes.PUT("/:id", userController.UpdateUser)
+		userRoutes.DELETE("/:id", userController.DeleteUser)
+		userRoutes.POST("/:id/deactivate", userController.DeactivateUser)
+		userRoutes.POST("/:id/reactivate", userController.ReactivateUser)
+		userRoutes.POST("/:id/unlock", userController.UnlockUser)
+		userRoutes.GET("/:id/login-attempts", userController.GetLoginAttempts)
+		userRoutes.DELETE("/:id/2fa", authController.ResetTwoFactor)
+		userRoutes.POST("/:id/offboard", userController.OffboardUser)
+	}
+
+	accessTokenController := controllers.AccessTokenController{DB: db}
+	tokenRoutes := r.Group("/tokens")
+	tokenRoutes.Use(middleware.AuthMiddleware(db), middleware.SessionOnlyMiddleware())
+	{
+		tokenRoutes.GET("", accessTokenController.GetAccessTokens)
+		tokenRoutes.POST("", accessTokenController.CreateAccessToken)
+		tokenRoutes.DELETE("/:id", accessTokenController.RevokeAccessToken)
+	}
+
+	serviceAccountRoutes := r.Group("/service-accounts")
+	serviceAccountRoutes.Use(middleware.AuthMiddleware(db), middleware.SessionOnlyMiddleware(), middleware.RequirePermission(db, constants.PermUserManage))
+	{
+		serviceAccountRoutes.GET("", accessTokenController.GetServiceAccounts)
+		serviceAccountRoutes.POST("", accessTokenController.CreateServiceAccount)
+		serviceAccountRoutes.GET("/:id/tokens", accessTokenController.GetServiceAccountTokens)
+		serviceAccountRoutes.POST("/:id/tokens", accessTokenController.CreateServiceAccountToken)
+		serviceAccountRoutes.DELETE("/:id/tokens/:token_id", accessTokenController.RevokeServiceAccountToken)
+	}
+
+	orgController := controllers.OrgController{DB: db}
+	orgRoutes := r.Group("/org")
+	orgRoutes.Use(middleware.AuthMiddleware(db), middleware.ScopeMiddleware(constants.ScopeUsersRead, constants.ScopeUsersWrite))
+	{
+		orgRoutes.GET("/tree", orgController.GetTree)
+		orgRoutes.GET("/chain", orgController.GetChain)
+	}
+
+	teamController := controllers.TeamController{DB: db}
+	teamManage := middleware.RequirePermission(db, constants.PermTeamManage)
+	departmentRoutes := r.Group("/departments")
+	departmentRoutes.Use(middleware.AuthMiddleware(db), middleware.ScopeMiddleware(constants.ScopeUsersRead, constants.ScopeUsersWrite))
+	{
+		departmentRoutes.GET("", teamController.GetDepartments)
+		departmentRoutes.POST("", teamManage, teamController.CreateDepartment)
+		departmentRoutes.GET("/:id", teamController.GetDepartment)
+		departmentRoutes.PUT("/:id", teamManage, teamController.UpdateDepartment)
+		departmentRoutes.DELETE("/:id", teamManage, teamController.DeleteDepartment)
+	}
+
+	teamRoutes := r.Group("/teams")
+	teamRoutes.Use(middleware.AuthMiddleware(db), middleware.ScopeMiddleware(constants.ScopeUsersRead, constants.ScopeUsersWrite))
+	{
+		teamRoutes.GET("", teamController.GetTeams)
+		teamRoutes.POST("", teamManage, teamController.CreateTeam)
+		teamRoutes.GET("/:id", teamController.GetTeam)
+		teamRoutes.PUT("/:id", teamManage, teamController.UpdateTeam)
+		teamRoutes.DELETE("/:id", teamManage, teamController.DeleteTeam)
+		teamRoutes.PUT("/:id/members/:user_id", teamManage, teamController.PutTeamMember)
+		teamRoutes.DELETE("/:id/members/:user_id", teamManage, teamController.DeleteTeamMember)
+	}
+
+	roleController := controllers.RoleController{DB: db}
+	roleRoutes := r.Group("/roles")
+	roleRoutes.Use(middleware.AuthMiddleware(db), middleware.SessionOnlyMiddleware(), middleware.RequirePermission(db, constants.PermRoleManage))
+	{
+		roleRoutes.GET("", roleController.GetRoles)
+		roleRoutes.POST("", roleController.CreateRole)
+		roleRoutes.GET("/permissions", roleController.GetPermissions)
+		roleRoutes.PUT("/:name", roleController.UpdateRole)
+		roleRoutes.DELETE("/:name", roleController.DeleteRole)
+	}
+
+	calendarController := controllers.CalendarController{DB: db, BaseURL: cfg.Server.BaseURL}
+	r.GET("/calendar/feed.ics", calendarController.Feed)
+	calendarRoutes := r.Group("/calendar")
+	calendarRoutes.Use(middleware.AuthMiddleware(db), middleware.SessionOnlyMiddleware())
+	{
+		calendarRoutes.POST("/token", calendarController.RegenerateFeedToken)
+		calendarRoutes.DELETE("/token", calendarController.RevokeFeedToken)
 	}
 
 	return r
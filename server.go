@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"taskmanager/config"
+	"taskmanager/logging"
+	"taskmanager/services"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// worker is a job run in the background while the server is up: once at
+// startup, then every interval.
+type worker struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+func (w worker) loop(ctx context.Context) {
+	logger := slog.With("worker", w.name)
+	ctx = logging.NewContext(ctx, logger)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if err := w.run(ctx); err != nil {
+			logger.Error("worker run failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// backgroundWorkers returns the workers enabled in cfg.
+func backgroundWorkers(cfg *config.Config, db *gorm.DB) []worker {
+	var workers []worker
+	if interval := time.Duration(cfg.Workers.OverdueSweepInterval); interval > 0 {
+		workers = append(workers, worker{
+			name:     "overdue sweep",
+			interval: interval,
+			run: func(ctx context.Context) error {
+				changed, err := services.NewGormTaskService(db.WithContext(ctx)).SweepOverdue(time.Now())
+				if changed > 0 {
+					logging.FromContext(ctx).Info("marked tasks overdue", "count", changed)
+				}
+				return err
+			},
+		})
+	}
+	return workers
+}
+
+// newHTTPServer returns a server for handler with the timeouts from cfg.
+func newHTTPServer(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + strconv.Itoa(cfg.Port),
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+	}
+}
+
+// serve runs srv on ln, and the workers next to it, until ctx is done or the
+// server fails. It then shuts down gracefully: the listener is closed, the
+// requests in flight get shutdownTimeout to finish and the workers stop
+// after their current run. serve returns once all of that is over.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, workers []worker, shutdownTimeout time.Duration) error {
+	// Workers are not stopped by ctx directly so that they outlive the
+	// requests that may still be writing.
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(workerCtx)
+		}()
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+	slog.Info("listening", "addr", ln.Addr().String())
+
+	var err error
+	select {
+	case err = <-served:
+	case <-ctx.Done():
+		slog.Info("shutting down, waiting for requests in flight", "timeout", shutdownTimeout.String())
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err = srv.Shutdown(shutdownCtx)
+	}
+
+	stopWorkers()
+	wg.Wait()
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
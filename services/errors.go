@@ -0,0 +1,65 @@
+package services
+
+import "errors"
+
+// ErrorKind classifies a domain error so each entry point can translate it
+// (to an HTTP status, an exit code, ...) without knowing the rule behind it.
+type ErrorKind int
+
+const (
+	KindInvalid ErrorKind = iota + 1
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindPreconditionFailed
+	KindInternal
+)
+
+// Error is returned by services for every failure. Message is meant for the
+// caller; Err, when set, is the underlying cause and only meant for logs.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of a service error, or KindInternal for any other
+// error.
+func KindOf(err error) ErrorKind {
+	var serviceErr *Error
+	if errors.As(err, &serviceErr) {
+		return serviceErr.Kind
+	}
+	return KindInternal
+}
+
+func invalid(message string) error {
+	return &Error{Kind: KindInvalid, Message: message}
+}
+
+func forbidden(message string) error {
+	return &Error{Kind: KindForbidden, Message: message}
+}
+
+func notFound(message string) error {
+	return &Error{Kind: KindNotFound, Message: message}
+}
+
+func conflict(message string) error {
+	return &Error{Kind: KindConflict, Message: message}
+}
+
+func internal(message string, err error) error {
+	return &Error{Kind: KindInternal, Message: message, Err: err}
+}
@@ -0,0 +1,674 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"taskmanager/authz"
+	"taskmanager/constants"
+	"taskmanager/metrics"
+	"taskmanager/models"
+	"taskmanager/repository"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrVersionConflict is returned when a task changed after it was loaded, or
+// does not match the version the caller expected.
+var ErrVersionConflict = &Error{Kind: KindPreconditionFailed, Message: "Task was changed by someone else; reload it and try again"}
+
+// TaskService holds the task workflow rules: visibility, assignment, status
+// transitions, deadline extensions and approvals. Every method acts on behalf
+// of subject and fails with an *Error.
+type TaskService struct {
+	Tasks  repository.TaskRepository
+	Audits repository.AuditRepository
+	Users  repository.UserRepository
+	Teams  repository.TeamRepository
+
+	withContext func(ctx context.Context) *TaskService
+}
+
+// NewGormTaskService returns a TaskService backed by db.
+func NewGormTaskService(db *gorm.DB) *TaskService {
+	return &TaskService{
+		Tasks:  repository.GormTaskRepository{DB: db},
+		Audits: repository.GormAuditRepository{DB: db},
+		Users:  repository.GormUserRepository{DB: db},
+		Teams:  repository.GormTeamRepository{DB: db},
+		withContext: func(ctx context.Context) *TaskService {
+			return NewGormTaskService(db.WithContext(ctx))
+		},
+	}
+}
+
+// WithContext returns the service with its queries bound to ctx, so they are
+// logged with the request's logger. Services not backed by GORM are returned
+// as they are.
+func (s *TaskService) WithContext(ctx context.Context) *TaskService {
+	if s.withContext == nil {
+		return s
+	}
+	return s.withContext(ctx)
+}
+
+type CreateTaskInput struct {
+	Title              string     `json:"title"`
+	Description        string     `json:"description"`
+	AssignedToID       uint       `json:"assigned_to_id"`
+	TeamID             *uint      `json:"team_id"`
+	ProgressPercentage int        `json:"progress_percentage"`
+	Deadline           *time.Time `json:"deadline"`
+}
+
+// UpdateTaskInput changes the fields that are set. A TeamID of 0 removes the
+// task from its team. When ExpectedVersions is not nil the task's current
+// version must be one of them.
+type UpdateTaskInput struct {
+	Title              *string    `json:"title"`
+	Description        *string    `json:"description"`
+	AssignedToID       *uint      `json:"assigned_to_id"`
+	TeamID             *uint      `json:"team_id"`
+	Status             *string    `json:"status"`
+	ProgressPercentage *int       `json:"progress_percentage"`
+	Deadline           *time.Time `json:"deadline"`
+	ExpectedVersions   []uint     `json:"-"`
+}
+
+type DecisionInput struct {
+	Comments string `json:"comments"`
+	Reason   string `json:"reason"`
+}
+
+type RequestExtensionInput struct {
+	RequestedDeadline *time.Time `json:"requested_deadline"`
+	Reason            string     `json:"reason"`
+}
+
+type ExtendDeadlineInput struct {
+	NewDeadline *time.Time `json:"new_deadline"`
+	Comments    string     `json:"comments"`
+}
+
+// List returns the tasks subject may see, with their audit trails.
+func (s *TaskService) List(subject authz.Subject, filter repository.TaskFilter) ([]models.Task, error) {
+	tasks, err := s.Tasks.ListVisible(subject, filter)
+	if err != nil {
+		return nil, internal("Failed to load tasks", err)
+	}
+	if err := s.attachAuditTrails(tasks); err != nil {
+		return nil, err
+	}
+	if err := s.refreshDeadlineStatuses(tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Pool lists the unassigned tasks of subject's teams, oldest first.
+func (s *TaskService) Pool(subject authz.Subject, filter repository.TaskFilter) ([]models.Task, error) {
+	tasks, err := s.Tasks.ListClaimable(subject, filter)
+	if err != nil {
+		return nil, internal("Failed to load tasks", err)
+	}
+	if err := s.refreshDeadlineStatuses(tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Get returns a task subject may see, with its audit trail.
+func (s *TaskService) Get(subject authz.Subject, id uint) (*models.Task, error) {
+	task, err := s.load(subject, id)
+	if err != nil {
+		return nil, err
+	}
+	tasks := []models.Task{*task}
+	if err := s.attachAuditTrails(tasks); err != nil {
+		return nil, err
+	}
+	task = &tasks[0]
+	if err := s.refreshDeadlineStatus(task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// load finds a task and checks subject may see it.
+func (s *TaskService) load(subject authz.Subject, id uint) (*models.Task, error) {
+	task, err := s.Tasks.FindByID(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, notFound("Task not found")
+	}
+	if err != nil {
+		return nil, internal("Failed to load task", err)
+	}
+	if !authz.Can(s.Users, subject, constants.ActionTaskView, task) {
+		return nil, forbidden("Unauthorized access")
+	}
+	return task, nil
+}
+
+func (s *TaskService) attachAuditTrails(tasks []models.Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(tasks))
+	index := make(map[uint]int, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+		index[task.ID] = i
+	}
+	audits, err := s.Audits.ListByTasks(ids)
+	if err != nil {
+		return internal("Failed to load audit trail", err)
+	}
+	for _, audit := range audits {
+		task := &tasks[index[audit.TaskID]]
+		task.AuditTrail = append(task.AuditTrail, audit)
+	}
+	return nil
+}
+
+func (s *TaskService) Create(subject authz.Subject, input CreateTaskInput) (*models.Task, error) {
+	if input.ProgressPercentage < 0 || input.ProgressPercentage > 100 {
+		return nil, invalid("progress_percentage must be between 0 and 100")
+	}
+	if input.AssignedToID != 0 {
+		if err := s.checkAssign(subject, input.AssignedToID); err != nil {
+			return nil, err
+		}
+	}
+	if input.TeamID != nil {
+		if err := s.checkTeam(*input.TeamID); err != nil {
+			return nil, err
+		}
+	}
+
+	task := &models.Task{
+		Title:              input.Title,
+		Description:        input.Description,
+		AssignedToID:       input.AssignedToID,
+		TeamID:             input.TeamID,
+		ProgressPercentage: input.ProgressPercentage,
+		Deadline:           input.Deadline,
+		CreatedByID:        subject.UserID,
+		Status:             constants.TaskStatusAssigned,
+	}
+	if task.AssignedToID == 0 {
+		task.Status = constants.TaskStatusCreated
+	}
+	SetDeadlineStatus(task)
+
+	if err := s.Tasks.Create(task); err != nil {
+		return nil, internal("Failed to create task", err)
+	}
+	metrics.TasksCreated.Inc()
+	return task, nil
+}
+
+// Update applies input to the task. When the version check fails the
+// current task is returned along with ErrVersionConflict.
+func (s *TaskService) Update(subject authz.Subject, id uint, input UpdateTaskInput) (*models.Task, error) {
+	task, err := s.load(subject, id)
+	if err != nil {
+		return nil, err
+	}
+	if input.ExpectedVersions != nil && !containsVersion(input.ExpectedVersions, task.Version) {
+		return task, ErrVersionConflict
+	}
+	if err := s.refreshDeadlineStatus(task); err != nil {
+		return nil, err
+	}
+
+	if !authz.Can(s.Users, subject, constants.ActionTaskUpdate, task) {
+		return nil, forbidden("You can only update tasks assigned to you")
+	}
+	if !subject.Has(constants.PermTaskEdit) &&
+		(input.Title != nil || input.Description != nil || input.AssignedToID != nil || input.TeamID != nil || input.Deadline != nil) {
+		return nil, forbidden("You can only update progress and status of tasks assigned to you")
+	}
+	if input.TeamID != nil && *input.TeamID != 0 {
+		if err := s.checkTeam(*input.TeamID); err != nil {
+			return nil, err
+		}
+	}
+	if input.AssignedToID != nil {
+		if err := s.checkAssign(subject, *input.AssignedToID); err != nil {
+			return nil, err
+		}
+	}
+
+	if input.ProgressPercentage != nil {
+		if *input.ProgressPercentage < 0 || *input.ProgressPercentage > 100 {
+			return nil, invalid("progress_percentage must be between 0 and 100")
+		}
+		task.ProgressPercentage = *input.ProgressPercentage
+	}
+	if input.Title != nil {
+		task.Title = *input.Title
+	}
+	if input.Description != nil {
+		task.Description = *input.Description
+	}
+	if input.AssignedToID != nil {
+		task.AssignedToID = *input.AssignedToID
+		if task.Status == constants.TaskStatusCreated && task.AssignedToID != 0 {
+			task.Status = constants.TaskStatusAssigned
+		}
+	}
+	if input.TeamID != nil {
+		task.TeamID = input.TeamID
+		if *input.TeamID == 0 {
+			task.TeamID = nil
+		}
+	}
+	if input.Deadline != nil {
+		task.Deadline = input.Deadline
+		task.ExtensionRequested = false
+		task.ExtensionRequestedByID = nil
+		task.ExtensionRequestedAt = nil
+		task.ExtensionRequestedDeadline = nil
+		task.ExtensionReason = ""
+	}
+
+	if input.Status != nil {
+		if err := applyStatus(task, *input.Status); err != nil {
+			return nil, err
+		}
+	}
+
+	if task.CompletionLocked {
+		if input.Status != nil && *input.Status != constants.TaskStatusApproved {
+			return nil, invalid("Completion date is locked for approved tasks")
+		}
+	}
+
+	SetDeadlineStatus(task)
+
+	if err := s.Tasks.Update(task); err != nil {
+		return nil, asTaskWriteError(err, "Failed to update task")
+	}
+	return task, nil
+}
+
+// applyStatus moves the task to status, enforcing the transition rules.
+func applyStatus(task *models.Task, status string) error {
+	mappedStatus := normalizeStatus(status)
+	if mappedStatus == constants.TaskStatusApproved || mappedStatus == constants.TaskStatusRejected {
+		return invalid("Use /tasks/:id/approve or /tasks/:id/reject for approval decisions")
+	}
+	if !isValidStatus(mappedStatus) {
+		return invalid("Invalid task status")
+	}
+	if !isAllowedStatusTransition(task.Status, mappedStatus) {
+		return invalid("Invalid status transition")
+	}
+	if mappedStatus == constants.TaskStatusPendingApproval && task.ProgressPercentage < 100 {
+		return invalid("progress_percentage must be 100 before moving to pending_approval")
+	}
+	if task.Status == constants.TaskStatusApproved && mappedStatus != constants.TaskStatusApproved {
+		return invalid("Approved tasks are locked")
+	}
+
+	now := time.Now()
+	if mappedStatus == constants.TaskStatusPendingApproval {
+		if task.CompletedAt == nil {
+			task.CompletedAt = &now
+		}
+		task.PendingApprovalNotifiedAt = &now
+	}
+	if mappedStatus == constants.TaskStatusInProgress && task.Status == constants.TaskStatusRejected {
+		task.RejectionReason = ""
+		task.RejectedByID = nil
+		task.RejectedAt = nil
+	}
+	task.Status = mappedStatus
+	return nil
+}
+
+// Claim assigns an unassigned team task to subject. The assignment is a
+// conditional update, so of several concurrent claims exactly one succeeds
+// and the others get a conflict.
+func (s *TaskService) Claim(subject authz.Subject, id uint) (*models.Task, error) {
+	task, err := s.load(subject, id)
+	if err != nil {
+		return nil, err
+	}
+	if task.AssignedToID != 0 {
+		return nil, conflict("Task has already been claimed")
+	}
+	if !authz.Can(s.Users, subject, constants.ActionTaskClaim, task) {
+		return nil, forbidden("Only members of the task's team can claim it")
+	}
+
+	claimed, err := s.Tasks.Claim(task.ID, subject.UserID)
+	if err != nil {
+		return nil, internal("Failed to claim task", err)
+	}
+	if !claimed {
+		return nil, conflict("Task has already been claimed")
+	}
+
+	if task, err = s.Tasks.FindByID(task.ID); err != nil {
+		return nil, internal("Failed to load task", err)
+	}
+	if err := s.refreshDeadlineStatus(task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (s *TaskService) RequestExtension(subject authz.Subject, id uint, input RequestExtensionInput) (*models.Task, error) {
+	task, err := s.load(subject, id)
+	if err != nil {
+		return nil, err
+	}
+	if !authz.Can(s.Users, subject, constants.PermTaskRequestExtension, task) {
+		return nil, forbidden("Only assigned member can request extension")
+	}
+	if err := s.refreshDeadlineStatus(task); err != nil {
+		return nil, err
+	}
+
+	if task.Deadline == nil {
+		return nil, invalid("Task has no deadline")
+	}
+	if task.DeadlineStatus != constants.DeadlineStatusOverdue {
+		return nil, invalid("Extension can only be requested for overdue tasks")
+	}
+	if input.RequestedDeadline == nil {
+		return nil, invalid("requested_deadline is required")
+	}
+	if !input.RequestedDeadline.After(*task.Deadline) {
+		return nil, invalid("requested_deadline must be after current deadline")
+	}
+
+	userID := subject.UserID
+	now := time.Now()
+	task.ExtensionRequested = true
+	task.ExtensionRequestedByID = &userID
+	task.ExtensionRequestedAt = &now
+	task.ExtensionRequestedDeadline = input.RequestedDeadline
+	task.ExtensionReason = input.Reason
+
+	audit := models.TaskAudit{
+		TaskID:   task.ID,
+		Action:   "extension_requested",
+		ActorID:  userID,
+		Comments: fmt.Sprintf("requested_deadline=%s; reason=%s", input.RequestedDeadline.Format(time.RFC3339), input.Reason),
+	}
+	if err := s.Tasks.UpdateWithAudit(task, &audit); err != nil {
+		return nil, asTaskWriteError(err, "Failed to request extension")
+	}
+	metrics.ExtensionRequests.Inc()
+	return task, nil
+}
+
+func (s *TaskService) ExtendDeadline(subject authz.Subject, id uint, input ExtendDeadlineInput) (*models.Task, error) {
+	task, err := s.load(subject, id)
+	if err != nil {
+		return nil, err
+	}
+	if !authz.Can(s.Users, subject, constants.PermTaskExtendDeadline, task) {
+		return nil, forbidden("Only task assigner or admin can extend deadline")
+	}
+
+	if input.NewDeadline == nil {
+		input.NewDeadline = task.ExtensionRequestedDeadline
+	}
+	if input.NewDeadline == nil {
+		return nil, invalid("new_deadline is required")
+	}
+	if task.Deadline != nil && !input.NewDeadline.After(*task.Deadline) {
+		return nil, invalid("new_deadline must be after current deadline")
+	}
+
+	userID := subject.UserID
+	now := time.Now()
+	task.Deadline = input.NewDeadline
+	task.ExtensionRequested = false
+	task.ExtensionApprovedByID = &userID
+	task.ExtensionApprovedAt = &now
+	task.ExtensionRequestedByID = nil
+	task.ExtensionRequestedAt = nil
+	task.ExtensionRequestedDeadline = nil
+	task.ExtensionReason = ""
+	SetDeadlineStatus(task)
+
+	audit := models.TaskAudit{
+		TaskID:   task.ID,
+		Action:   "deadline_extended",
+		ActorID:  userID,
+		Comments: fmt.Sprintf("new_deadline=%s; comments=%s", input.NewDeadline.Format(time.RFC3339), input.Comments),
+	}
+	if err := s.Tasks.UpdateWithAudit(task, &audit); err != nil {
+		return nil, asTaskWriteError(err, "Failed to extend deadline")
+	}
+	metrics.DeadlineExtensions.Inc()
+	return task, nil
+}
+
+func (s *TaskService) Approve(subject authz.Subject, id uint, input DecisionInput) (*models.Task, error) {
+	task, err := s.load(subject, id)
+	if err != nil {
+		return nil, err
+	}
+	if !authz.Can(s.Users, subject, constants.PermTaskApprove, task) {
+		return nil, forbidden("Only manager/admin can approve tasks")
+	}
+	if task.Status != constants.TaskStatusPendingApproval {
+		return nil, invalid("Only pending_approval tasks can be approved")
+	}
+
+	userID := subject.UserID
+	now := time.Now()
+	task.Status = constants.TaskStatusApproved
+	task.ApprovedByID = &userID
+	task.ApprovedAt = &now
+	task.CompletionLocked = true
+	if task.CompletedAt == nil {
+		task.CompletedAt = &now
+	}
+	SetDeadlineStatus(task)
+
+	audit := models.TaskAudit{
+		TaskID:   task.ID,
+		Action:   constants.TaskStatusApproved,
+		ActorID:  userID,
+		Comments: input.Comments,
+	}
+	if err := s.Tasks.UpdateWithAudit(task, &audit); err != nil {
+		return nil, asTaskWriteError(err, "Failed to approve task")
+	}
+	metrics.TaskReviews.WithLabelValues(constants.TaskStatusApproved).Inc()
+	return task, nil
+}
+
+func (s *TaskService) Reject(subject authz.Subject, id uint, input DecisionInput) (*models.Task, error) {
+	task, err := s.load(subject, id)
+	if err != nil {
+		return nil, err
+	}
+	if !authz.Can(s.Users, subject, constants.PermTaskApprove, task) {
+		return nil, forbidden("Only manager/admin can reject tasks")
+	}
+	if task.Status != constants.TaskStatusPendingApproval {
+		return nil, invalid("Only pending_approval tasks can be rejected")
+	}
+	if input.Reason == "" {
+		return nil, invalid("rejection reason is required")
+	}
+
+	userID := subject.UserID
+	now := time.Now()
+	task.Status = constants.TaskStatusRejected
+	task.RejectedByID = &userID
+	task.RejectedAt = &now
+	task.RejectionReason = input.Reason
+	task.CompletionLocked = false
+	task.ApprovedByID = nil
+	task.ApprovedAt = nil
+	SetDeadlineStatus(task)
+
+	audit := models.TaskAudit{
+		TaskID:   task.ID,
+		Action:   constants.TaskStatusRejected,
+		ActorID:  userID,
+		Comments: input.Comments,
+	}
+	if input.Comments == "" {
+		audit.Comments = input.Reason
+	}
+	if err := s.Tasks.UpdateWithAudit(task, &audit); err != nil {
+		return nil, asTaskWriteError(err, "Failed to reject task")
+	}
+	metrics.TaskReviews.WithLabelValues(constants.TaskStatusRejected).Inc()
+	return task, nil
+}
+
+func (s *TaskService) Delete(subject authz.Subject, id uint) error {
+	task, err := s.Tasks.FindByID(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return notFound("Task not found")
+	}
+	if err != nil {
+		return internal("Failed to load task", err)
+	}
+	if !authz.Can(s.Users, subject, constants.PermTaskDelete, task) {
+		return forbidden("Unauthorized access")
+	}
+
+	if err := s.Tasks.Delete(id); err != nil {
+		return internal("Failed to delete task", err)
+	}
+	return nil
+}
+
+func (s *TaskService) checkAssign(subject authz.Subject, assigneeID uint) error {
+	canAssign, err := authz.CanAssign(s.Users, subject, assigneeID)
+	if err != nil {
+		return internal("Failed to verify assignment permissions", err)
+	}
+	if !canAssign {
+		return forbidden("You do not have permission to assign a task to this user")
+	}
+	return nil
+}
+
+func (s *TaskService) checkTeam(teamID uint) error {
+	exists, err := s.Teams.Exists(teamID)
+	if err != nil {
+		return internal("Failed to verify team", err)
+	}
+	if !exists {
+		return invalid("Team not found")
+	}
+	return nil
+}
+
+func asTaskWriteError(err error, message string) error {
+	if errors.Is(err, repository.ErrVersionConflict) {
+		return ErrVersionConflict
+	}
+	return internal(message, err)
+}
+
+func containsVersion(versions []uint, version uint) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *TaskService) refreshDeadlineStatuses(tasks []models.Task) error {
+	for i := range tasks {
+		if err := s.refreshDeadlineStatus(&tasks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SweepOverdue marks every task whose deadline has passed as overdue and
+// returns how many it changed. Reads refresh the status as well; the sweep
+// keeps it current for tasks nobody looks at.
+func (s *TaskService) SweepOverdue(now time.Time) (int64, error) {
+	changed, err := s.Tasks.MarkOverdue(now)
+	if err != nil {
+		return 0, internal("Failed to mark overdue tasks", err)
+	}
+	return changed, nil
+}
+
+func (s *TaskService) refreshDeadlineStatus(task *models.Task) error {
+	previous := task.DeadlineStatus
+	SetDeadlineStatus(task)
+	if previous == task.DeadlineStatus {
+		return nil
+	}
+	if err := s.Tasks.UpdateDeadlineStatus(task); err != nil {
+		return internal("Failed to evaluate deadline status", err)
+	}
+	return nil
+}
+
+// SetDeadlineStatus derives deadline_status from the deadline.
+func SetDeadlineStatus(task *models.Task) {
+	if task.Deadline == nil {
+		task.DeadlineStatus = constants.DeadlineStatusOnTime
+		return
+	}
+	if time.Now().After(*task.Deadline) {
+		task.DeadlineStatus = constants.DeadlineStatusOverdue
+		return
+	}
+	task.DeadlineStatus = constants.DeadlineStatusOnTime
+}
+
+func isValidStatus(status string) bool {
+	switch status {
+	case constants.TaskStatusCreated,
+		constants.TaskStatusAssigned,
+		constants.TaskStatusInProgress,
+		constants.TaskStatusPendingApproval,
+		constants.TaskStatusApproved,
+		constants.TaskStatusRejected:
+		return true
+	default:
+		return false
+	}
+}
+
+func normalizeStatus(status string) string {
+	if status == "completed" {
+		return constants.TaskStatusPendingApproval
+	}
+	return status
+}
+
+func isAllowedStatusTransition(from, to string) bool {
+	if from == to {
+		return true
+	}
+
+	switch from {
+	case constants.TaskStatusCreated:
+		return to == constants.TaskStatusAssigned
+	case constants.TaskStatusAssigned:
+		return to == constants.TaskStatusInProgress
+	case constants.TaskStatusInProgress:
+		return to == constants.TaskStatusPendingApproval
+	case constants.TaskStatusPendingApproval:
+		return to == constants.TaskStatusApproved || to == constants.TaskStatusRejected
+	case constants.TaskStatusRejected:
+		return to == constants.TaskStatusInProgress
+	case constants.TaskStatusApproved:
+		return false
+	default:
+		return false
+	}
+}
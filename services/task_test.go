@@ -0,0 +1,315 @@
+package services_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"taskmanager/authz"
+	"taskmanager/constants"
+	"taskmanager/models"
+	"taskmanager/repository"
+	"taskmanager/services"
+)
+
+type serviceEnv struct {
+	store   *repository.MemoryStore
+	service *services.TaskService
+
+	admin, mgr, mem, other authz.Subject
+}
+
+// setupServiceEnv wires a TaskService to an in-memory store holding an
+// admin, a manager with one report, and a member outside that line.
+func setupServiceEnv(t *testing.T) *serviceEnv {
+	t.Helper()
+
+	store := repository.NewMemoryStore()
+	for _, role := range authz.DefaultRoles {
+		store.AddRole(role)
+	}
+	admin := models.User{Name: "Admin", Email: "admin@example.com", Role: constants.RoleAdmin}
+	mgr := models.User{Name: "Manager", Email: "manager@example.com", Role: constants.RoleManager}
+	store.AddUser(&admin)
+	store.AddUser(&mgr)
+	mem := models.User{Name: "Member", Email: "member@example.com", Role: constants.RoleMember, ManagerID: &mgr.ID}
+	other := models.User{Name: "Other", Email: "other@example.com", Role: constants.RoleMember}
+	store.AddUser(&mem)
+	store.AddUser(&other)
+
+	return &serviceEnv{
+		store: store,
+		service: &services.TaskService{
+			Tasks:  store.Tasks(),
+			Audits: store.Audits(),
+			Users:  store.Users(),
+			Teams:  store.Teams(),
+		},
+		admin: subjectFor(t, admin),
+		mgr:   subjectFor(t, mgr),
+		mem:   subjectFor(t, mem),
+		other: subjectFor(t, other),
+	}
+}
+
+func subjectFor(t *testing.T, user models.User) authz.Subject {
+	t.Helper()
+	for _, role := range authz.DefaultRoles {
+		if role.Name == user.Role {
+			return authz.Subject{UserID: user.ID, Role: role.Name, Permissions: role.Permissions}
+		}
+	}
+	t.Fatalf("no default role %q", user.Role)
+	return authz.Subject{}
+}
+
+func wantKind(t *testing.T, err error, kind services.ErrorKind) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected error of kind %d, got nil", kind)
+	}
+	if got := services.KindOf(err); got != kind {
+		t.Fatalf("expected error of kind %d, got %d (%v)", kind, got, err)
+	}
+}
+
+func TestTaskService_AssignToUserManagers(t *testing.T) {
+	env := setupServiceEnv(t)
+	s := env.service
+
+	// Whoever manages users is off limits for managers, whatever the role
+	// is called.
+	env.store.AddRole(models.Role{Name: "ops", Permissions: models.StringList{constants.PermUserManage}})
+	mgrID := env.mgr.UserID
+	ops := models.User{Name: "Ops", Email: "ops@example.com", Role: "ops", ManagerID: &mgrID}
+	env.store.AddUser(&ops)
+
+	_, err := s.Create(env.mgr, services.CreateTaskInput{Title: "Rotate keys", AssignedToID: ops.ID})
+	wantKind(t, err, services.KindForbidden)
+	if _, err := s.Create(env.admin, services.CreateTaskInput{Title: "Rotate keys", AssignedToID: ops.ID}); err != nil {
+		t.Fatalf("admin assigns to ops: %v", err)
+	}
+
+	env.store.AddRole(models.Role{Name: "ops"})
+	if _, err := s.Create(env.mgr, services.CreateTaskInput{Title: "Rotate keys", AssignedToID: ops.ID}); err != nil {
+		t.Fatalf("manager assigns to report without user management: %v", err)
+	}
+}
+
+func TestTaskService_WorkflowAndAudit(t *testing.T) {
+	env := setupServiceEnv(t)
+	s := env.service
+
+	task, err := s.Create(env.mgr, services.CreateTaskInput{Title: "Write report", AssignedToID: env.mem.UserID})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if task.Status != constants.TaskStatusAssigned || task.Version != 1 {
+		t.Fatalf("unexpected new task: status=%s version=%d", task.Status, task.Version)
+	}
+
+	// Managers may only assign within their reporting line.
+	_, err = s.Create(env.mgr, services.CreateTaskInput{Title: "Elsewhere", AssignedToID: env.other.UserID})
+	wantKind(t, err, services.KindForbidden)
+
+	// The assignee reports progress but cannot edit the task itself.
+	title := "Renamed"
+	_, err = s.Update(env.mem, task.ID, services.UpdateTaskInput{Title: &title})
+	wantKind(t, err, services.KindForbidden)
+
+	status := constants.TaskStatusPendingApproval
+	_, err = s.Update(env.mem, task.ID, services.UpdateTaskInput{Status: &status})
+	wantKind(t, err, services.KindInvalid)
+
+	status = constants.TaskStatusInProgress
+	if _, err := s.Update(env.mem, task.ID, services.UpdateTaskInput{Status: &status}); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	progress := 100
+	status = constants.TaskStatusPendingApproval
+	task, err = s.Update(env.mem, task.ID, services.UpdateTaskInput{Status: &status, ProgressPercentage: &progress})
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if task.CompletedAt == nil {
+		t.Fatalf("expected completed_at to be set")
+	}
+
+	_, err = s.Approve(env.mem, task.ID, services.DecisionInput{})
+	wantKind(t, err, services.KindForbidden)
+	_, err = s.Reject(env.mgr, task.ID, services.DecisionInput{})
+	wantKind(t, err, services.KindInvalid)
+
+	task, err = s.Approve(env.mgr, task.ID, services.DecisionInput{Comments: "Looks good"})
+	if err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	if task.Status != constants.TaskStatusApproved || !task.CompletionLocked || task.Version != 4 {
+		t.Fatalf("unexpected approved task: status=%s locked=%v version=%d", task.Status, task.CompletionLocked, task.Version)
+	}
+
+	status = constants.TaskStatusInProgress
+	_, err = s.Update(env.mem, task.ID, services.UpdateTaskInput{Status: &status})
+	wantKind(t, err, services.KindInvalid)
+
+	got, err := s.Get(env.mem, task.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if len(got.AuditTrail) != 1 || got.AuditTrail[0].Action != constants.TaskStatusApproved || got.AuditTrail[0].Comments != "Looks good" {
+		t.Fatalf("unexpected audit trail: %+v", got.AuditTrail)
+	}
+
+	wantKind(t, s.Delete(env.mgr, task.ID), services.KindForbidden)
+	if err := s.Delete(env.admin, task.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	_, err = s.Get(env.admin, task.ID)
+	wantKind(t, err, services.KindNotFound)
+}
+
+func TestTaskService_Visibility(t *testing.T) {
+	env := setupServiceEnv(t)
+	s := env.service
+
+	mine, err := s.Create(env.mgr, services.CreateTaskInput{Title: "For report", AssignedToID: env.mem.UserID})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	theirs, err := s.Create(env.admin, services.CreateTaskInput{Title: "For other", AssignedToID: env.other.UserID})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		subject authz.Subject
+		want    []uint
+	}{
+		{"admin", env.admin, []uint{mine.ID, theirs.ID}},
+		{"manager", env.mgr, []uint{mine.ID}},
+		{"member", env.mem, []uint{mine.ID}},
+		{"other", env.other, []uint{theirs.ID}},
+	} {
+		tasks, err := s.List(tc.subject, repository.TaskFilter{})
+		if err != nil {
+			t.Fatalf("%s: list: %v", tc.name, err)
+		}
+		if len(tasks) != len(tc.want) {
+			t.Fatalf("%s: expected %d tasks, got %d", tc.name, len(tc.want), len(tasks))
+		}
+		for i, task := range tasks {
+			if task.ID != tc.want[i] {
+				t.Fatalf("%s: expected task %d at %d, got %d", tc.name, tc.want[i], i, task.ID)
+			}
+		}
+	}
+
+	_, err = s.Get(env.mgr, theirs.ID)
+	wantKind(t, err, services.KindForbidden)
+	_, err = s.Get(env.mem, 999)
+	wantKind(t, err, services.KindNotFound)
+}
+
+func TestTaskService_VersionConflicts(t *testing.T) {
+	env := setupServiceEnv(t)
+	s := env.service
+
+	task, err := s.Create(env.mgr, services.CreateTaskInput{Title: "Shared", AssignedToID: env.mem.UserID})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	title := "First"
+	if _, err := s.Update(env.mgr, task.ID, services.UpdateTaskInput{Title: &title, ExpectedVersions: []uint{1}}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	title = "Stale"
+	current, err := s.Update(env.mgr, task.ID, services.UpdateTaskInput{Title: &title, ExpectedVersions: []uint{1}})
+	if !errors.Is(err, services.ErrVersionConflict) {
+		t.Fatalf("expected version conflict, got %v", err)
+	}
+	if current == nil || current.Version != 2 || current.Title != "First" {
+		t.Fatalf("expected the current task with the conflict, got %+v", current)
+	}
+
+	// A write based on an outdated copy is refused by the repository too.
+	stale, err := env.store.Tasks().FindByID(task.ID)
+	if err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if _, err := s.Update(env.mgr, task.ID, services.UpdateTaskInput{Title: &title}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err := env.store.Tasks().Update(stale); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("expected repository version conflict, got %v", err)
+	}
+}
+
+func TestTaskService_ClaimFromPool(t *testing.T) {
+	env := setupServiceEnv(t)
+	s := env.service
+
+	team := models.Team{Name: "Support"}
+	env.store.AddTeam(&team)
+	env.store.AddTeamMember(models.TeamMember{TeamID: team.ID, UserID: env.mem.UserID})
+	env.store.AddTeamMember(models.TeamMember{TeamID: team.ID, UserID: env.mgr.UserID})
+
+	task, err := s.Create(env.admin, services.CreateTaskInput{Title: "Triage", TeamID: &team.ID})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if task.Status != constants.TaskStatusCreated {
+		t.Fatalf("expected created status, got %s", task.Status)
+	}
+
+	missing := uint(999)
+	_, err = s.Create(env.admin, services.CreateTaskInput{Title: "Nowhere", TeamID: &missing})
+	wantKind(t, err, services.KindInvalid)
+
+	pool, err := s.Pool(env.mem, repository.TaskFilter{TeamID: &team.ID})
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	if len(pool) != 1 || pool[0].ID != task.ID {
+		t.Fatalf("expected the team task in the pool, got %+v", pool)
+	}
+	if pool, _ := s.Pool(env.other, repository.TaskFilter{}); len(pool) != 0 {
+		t.Fatalf("expected an empty pool for a non-member, got %d tasks", len(pool))
+	}
+	_, err = s.Claim(env.other, task.ID)
+	wantKind(t, err, services.KindForbidden)
+
+	var wg sync.WaitGroup
+	results := make([]error, 2)
+	for i, subject := range []authz.Subject{env.mem, env.mgr} {
+		wg.Add(1)
+		go func(i int, subject authz.Subject) {
+			defer wg.Done()
+			_, results[i] = s.Claim(subject, task.ID)
+		}(i, subject)
+	}
+	wg.Wait()
+
+	won := 0
+	for _, err := range results {
+		if err == nil {
+			won++
+			continue
+		}
+		wantKind(t, err, services.KindConflict)
+	}
+	if won != 1 {
+		t.Fatalf("expected exactly one claim to succeed, got %d", won)
+	}
+
+	claimed, err := s.Get(env.admin, task.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if claimed.AssignedToID == 0 || claimed.Status != constants.TaskStatusAssigned || claimed.Version != 2 {
+		t.Fatalf("unexpected claimed task: assignee=%d status=%s version=%d", claimed.AssignedToID, claimed.Status, claimed.Version)
+	}
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"taskmanager/models"
+	"taskmanager/repository"
+	"taskmanager/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserService holds the rules for managing accounts: who may report to
+// whom, which roles exist and what a valid password is. Callers are trusted
+// administrators; checking that is up to the entry point.
+type UserService struct {
+	Users repository.UserRepository
+	Roles repository.RoleRepository
+
+	withContext func(ctx context.Context) *UserService
+}
+
+// NewGormUserService returns a UserService backed by db.
+func NewGormUserService(db *gorm.DB) *UserService {
+	return &UserService{
+		Users: repository.GormUserRepository{DB: db},
+		Roles: repository.GormRoleRepository{DB: db},
+		withContext: func(ctx context.Context) *UserService {
+			return NewGormUserService(db.WithContext(ctx))
+		},
+	}
+}
+
+// WithContext returns the service with its queries bound to ctx, so they are
+// logged with the request's logger. Services not backed by GORM are returned
+// as they are.
+func (s *UserService) WithContext(ctx context.Context) *UserService {
+	if s.withContext == nil {
+		return s
+	}
+	return s.withContext(ctx)
+}
+
+type CreateUserInput struct {
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	Role      string `json:"role"`
+	ManagerID *uint  `json:"manager_id"`
+}
+
+// UpdateUserInput replaces the user's manager, removing it when ManagerID
+// is nil, and changes the role unless Role is empty.
+type UpdateUserInput struct {
+	Role      string `json:"role"`
+	ManagerID *uint  `json:"manager_id"`
+}
+
+func (s *UserService) Get(id uint) (*models.User, error) {
+	user, err := s.Users.User(id)
+	if err != nil {
+		return nil, internal("Failed to load user", err)
+	}
+	if user == nil {
+		return nil, notFound("User not found")
+	}
+	return user, nil
+}
+
+func (s *UserService) GetByEmail(email string) (*models.User, error) {
+	user, err := s.Users.FindByEmail(normalizeEmail(email))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, notFound("User not found")
+	}
+	if err != nil {
+		return nil, internal("Failed to load user", err)
+	}
+	return user, nil
+}
+
+// Create adds an account. Accounts created by an admin are trusted, so the
+// email counts as verified.
+func (s *UserService) Create(input CreateUserInput) (*models.User, error) {
+	if input.Name == "" {
+		return nil, invalid("name is required")
+	}
+	email, err := utils.NormalizeEmail(input.Email)
+	if err != nil {
+		return nil, invalid(err.Error())
+	}
+	if err := utils.ValidatePassword(input.Password); err != nil {
+		return nil, invalid(err.Error())
+	}
+	if err := s.checkRole(input.Role, "Failed to create user"); err != nil {
+		return nil, err
+	}
+	if input.ManagerID != nil {
+		if err := s.checkManagerExists(*input.ManagerID, "Failed to create user"); err != nil {
+			return nil, err
+		}
+	}
+
+	taken, err := s.Users.EmailTaken(email)
+	if err != nil {
+		return nil, internal("Failed to create user", err)
+	}
+	if taken {
+		return nil, conflict("Email is already registered")
+	}
+
+	hashed, err := utils.HashPassword(input.Password)
+	if err != nil {
+		return nil, internal("Failed to create user", err)
+	}
+
+	now := time.Now()
+	user := &models.User{
+		Name:            input.Name,
+		Email:           email,
+		Password:        hashed,
+		Role:            input.Role,
+		ManagerID:       input.ManagerID,
+		EmailVerifiedAt: &now,
+	}
+	if err := s.Users.Create(user); err != nil {
+		if errors.Is(err, repository.ErrEmailTaken) {
+			return nil, conflict("Email is already registered")
+		}
+		return nil, internal("Failed to create user", err)
+	}
+	return user, nil
+}
+
+func (s *UserService) Update(id uint, input UpdateUserInput) (*models.User, error) {
+	user, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.ManagerID != nil {
+		if *input.ManagerID == user.ID {
+			return nil, invalid("User cannot be their own manager")
+		}
+		if err := s.checkManagerExists(*input.ManagerID, "Failed to update user"); err != nil {
+			return nil, err
+		}
+
+		// Reporting to someone in your own reporting line would close a loop.
+		reportIDs, err := s.Users.LoadReportIDs(user.ID)
+		if err != nil {
+			return nil, internal("Failed to update user", err)
+		}
+		if slices.Contains(reportIDs, *input.ManagerID) {
+			return nil, invalid("Manager reports to this user, which would create a reporting cycle")
+		}
+	}
+
+	if input.Role != "" {
+		if err := s.checkRole(input.Role, "Failed to update user"); err != nil {
+			return nil, err
+		}
+		user.Role = input.Role
+	}
+	user.ManagerID = input.ManagerID
+
+	if err := s.Users.Update(user); err != nil {
+		return nil, internal("Failed to update user", err)
+	}
+	return user, nil
+}
+
+// SetPassword replaces the user's password and signs them out everywhere.
+func (s *UserService) SetPassword(id uint, password string) (*models.User, error) {
+	user, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.ValidatePassword(password); err != nil {
+		return nil, invalid(err.Error())
+	}
+
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, internal("Failed to set password", err)
+	}
+	user.Password = hashed
+	user.TokenVersion++
+	if err := s.Users.Update(user); err != nil {
+		return nil, internal("Failed to set password", err)
+	}
+	return user, nil
+}
+
+func (s *UserService) checkRole(role, failure string) error {
+	exists, err := s.Roles.Exists(role)
+	if err != nil {
+		return internal(failure, err)
+	}
+	if !exists {
+		return invalid("Invalid role")
+	}
+	return nil
+}
+
+func (s *UserService) checkManagerExists(managerID uint, failure string) error {
+	manager, err := s.Users.User(managerID)
+	if err != nil {
+		return internal(failure, err)
+	}
+	if manager == nil {
+		return invalid("Manager not found")
+	}
+	return nil
+}
+
+// normalizeEmail lower-cases email for lookups without validating it, so
+// malformed input simply matches nobody.
+func normalizeEmail(email string) string {
+	if normalized, err := utils.NormalizeEmail(email); err == nil {
+		return normalized
+	}
+	return email
+}
@@ -0,0 +1,111 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"taskmanager/authz"
+	"taskmanager/constants"
+	"taskmanager/services"
+	"taskmanager/utils"
+)
+
+func userService(env *serviceEnv) *services.UserService {
+	for _, role := range authz.DefaultRoles {
+		env.store.AddRole(role)
+	}
+	return &services.UserService{Users: env.store.Users(), Roles: env.store.Roles()}
+}
+
+func TestUserService_CreateAndUpdate(t *testing.T) {
+	env := setupServiceEnv(t)
+	s := userService(env)
+
+	user, err := s.Create(services.CreateUserInput{Name: "New", Email: " New@Example.com ", Password: "pass1234", Role: constants.RoleMember, ManagerID: &env.mgr.UserID})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if user.Email != "new@example.com" || user.EmailVerifiedAt == nil {
+		t.Fatalf("unexpected new user: email=%q verified=%v", user.Email, user.EmailVerifiedAt)
+	}
+
+	_, err = s.Create(services.CreateUserInput{Name: "Dup", Email: "new@example.com", Password: "pass1234", Role: constants.RoleMember})
+	wantKind(t, err, services.KindConflict)
+	_, err = s.Create(services.CreateUserInput{Name: "Bad", Email: "bad@example.com", Password: "pass1234", Role: "wizard"})
+	wantKind(t, err, services.KindInvalid)
+	missing := uint(999)
+	_, err = s.Create(services.CreateUserInput{Name: "Bad", Email: "bad@example.com", Password: "pass1234", Role: constants.RoleMember, ManagerID: &missing})
+	wantKind(t, err, services.KindInvalid)
+
+	// mgr -> mem -> new: neither report may become mgr's manager.
+	_, err = s.Update(env.mgr.UserID, services.UpdateUserInput{ManagerID: &user.ID})
+	wantKind(t, err, services.KindInvalid)
+	_, err = s.Update(user.ID, services.UpdateUserInput{ManagerID: &user.ID})
+	wantKind(t, err, services.KindInvalid)
+
+	updated, err := s.Update(user.ID, services.UpdateUserInput{Role: constants.RoleManager})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if updated.Role != constants.RoleManager || updated.ManagerID != nil {
+		t.Fatalf("expected role change and manager removal, got %q %v", updated.Role, updated.ManagerID)
+	}
+
+	_, err = s.Update(999, services.UpdateUserInput{})
+	wantKind(t, err, services.KindNotFound)
+	_, err = s.GetByEmail("nobody@example.com")
+	wantKind(t, err, services.KindNotFound)
+}
+
+func TestUserService_SetPassword(t *testing.T) {
+	env := setupServiceEnv(t)
+	s := userService(env)
+
+	_, err := s.SetPassword(env.mem.UserID, "short")
+	wantKind(t, err, services.KindInvalid)
+
+	before, _ := s.Get(env.mem.UserID)
+	version := before.TokenVersion
+	user, err := s.SetPassword(env.mem.UserID, "newpass123")
+	if err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	if !utils.CheckPassword("newpass123", user.Password) {
+		t.Fatalf("expected the new password to be stored")
+	}
+	if user.TokenVersion != version+1 {
+		t.Fatalf("expected existing sessions to be revoked, token version %d -> %d", version, user.TokenVersion)
+	}
+}
+
+func TestTaskService_SweepOverdue(t *testing.T) {
+	env := setupServiceEnv(t)
+	s := env.service
+
+	tomorrow := time.Now().Add(24 * time.Hour)
+	task, err := s.Create(env.mgr, services.CreateTaskInput{Title: "Due soon", AssignedToID: env.mem.UserID, Deadline: &tomorrow})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := s.Create(env.mgr, services.CreateTaskInput{Title: "No deadline"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	if changed, err := s.SweepOverdue(time.Now()); err != nil || changed != 0 {
+		t.Fatalf("expected nothing to be overdue yet, got %d, %v", changed, err)
+	}
+	if changed, err := s.SweepOverdue(tomorrow.Add(time.Minute)); err != nil || changed != 1 {
+		t.Fatalf("expected one task to become overdue, got %d, %v", changed, err)
+	}
+	stored, err := env.store.Tasks().FindByID(task.ID)
+	if err != nil || stored.DeadlineStatus != constants.DeadlineStatusOverdue {
+		t.Fatalf("expected the task to be marked overdue, got %+v, %v", stored, err)
+	}
+	if changed, _ := s.SweepOverdue(tomorrow.Add(time.Minute)); changed != 0 {
+		t.Fatalf("expected a second sweep to change nothing, got %d", changed)
+	}
+	counts, err := env.store.Tasks().CountOverdue(tomorrow.Add(time.Minute))
+	if err != nil || len(counts) != 1 || counts[constants.TaskStatusAssigned] != 1 {
+		t.Fatalf("expected one overdue assigned task, got %v, %v", counts, err)
+	}
+}
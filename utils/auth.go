@@ -1,23 +1,14 @@
 package utils
 
 import (
-	"os"
-	"taskmanager/constants"
+	"errors"
 	"taskmanager/models"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 )
 
-var jwtSecret = []byte(func() string {
-	if secret := os.Getenv("JWT_SECRET"); secret != "" {
-		return secret
-	}
-	return "supersecretkey"
-}())
-
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword(
 		[]byte(password),
@@ -34,120 +25,63 @@ func CheckPassword(password, hash string) bool {
 	return err == nil
 }
 
+// SessionAudience is the aud claim of session tokens. Services verifying
+// tokens with the published JWKS must check it, since other token types are
+// signed with the same keys.
+const SessionAudience = "taskmanager"
+
+// challengeAudience is the aud claim of login challenge tokens, which only
+// this service accepts.
+const challengeAudience = "taskmanager-login-challenge"
+
+const (
+	sessionTokenType   = "session"
+	challengeTokenType = "challenge"
+)
+
 func GenerateJWT(user models.User) (string, error) {
 	claims := jwt.MapClaims{
+		"typ":     sessionTokenType,
+		"aud":     SessionAudience,
 		"user_id": user.ID,
 		"role":    user.Role,
+		"ver":     user.TokenVersion,
 		"exp":     time.Now().Add(time.Hour * 24).Unix(),
 	}
 
-	token := jwt.NewWithClaims(
-		jwt.SigningMethodHS256,
-		claims,
-	)
-
-	return token.SignedString(jwtSecret)
+	return signJWT(claims)
 }
 
-func GetRecursiveReportIDs(managerID uint, db *gorm.DB) []uint {
-	var reportIDs []uint
-	var users []models.User
-
-	// Find direct reports
-	db.Where("manager_id = ?", managerID).Find(&users)
-
-	for _, user := range users {
-		reportIDs = append(reportIDs, user.ID)
-		// Recursively find reports of reports
-		reportIDs = append(reportIDs, GetRecursiveReportIDs(user.ID, db)...)
-	}
-
-	return reportIDs
-}
-
-func CanAccessTask(
-	task models.Task,
-	userID uint,
-	role string,
-	db *gorm.DB,
-) bool {
-
-	if role == constants.RoleAdmin {
-		return true
-	}
-
-	if role == constants.RoleMember {
-		return task.CreatedByID == userID ||
-			task.AssignedToID == userID
-	}
-
-	if role == constants.RoleManager {
-
-		if task.CreatedByID == userID || task.AssignedToID == userID {
-			return true
-		}
-
-		reportIDs := GetRecursiveReportIDs(userID, db)
-		for _, reportID := range reportIDs {
-			if task.CreatedByID == reportID || task.AssignedToID == reportID {
-				return true
-			}
-		}
+// GenerateChallengeJWT issues a short-lived token proving that user passed
+// the password step of a login. It is only accepted by the endpoints that
+// complete the given purpose, never by AuthMiddleware.
+func GenerateChallengeJWT(user models.User, purpose string) (string, error) {
+	claims := jwt.MapClaims{
+		"typ":     challengeTokenType,
+		"aud":     challengeAudience,
+		"user_id": user.ID,
+		"purpose": purpose,
+		"ver":     user.TokenVersion,
+		"exp":     time.Now().Add(5 * time.Minute).Unix(),
 	}
 
-	return false
+	return signJWT(claims)
 }
 
-func CanAssignTask(
-	assignerID uint,
-	assignerRole string,
-	assigneeID uint,
-	db *gorm.DB,
-) (bool, error) {
-	if assigneeID == 0 {
-		return assignerRole == constants.RoleAdmin || assignerRole == constants.RoleManager, nil
-	}
-
-	// Admin can assign to anyone
-	if assignerRole == constants.RoleAdmin {
-		return true, nil
+// ParseChallengeJWT validates a token from GenerateChallengeJWT and returns
+// the user id and token version it was issued for.
+func ParseChallengeJWT(tokenString, purpose string) (uint, uint, error) {
+	token, err := parseJWT(tokenString, challengeAudience, challengeTokenType)
+	if err != nil || !token.Valid {
+		return 0, 0, errors.New("invalid challenge token")
 	}
 
-	// Fetch assignee role to check if they are admin
-	var assignee models.User
-	if err := db.First(&assignee, assigneeID).Error; err != nil {
-		return false, err
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != purpose {
+		return 0, 0, errors.New("invalid challenge token")
 	}
 
-	// No non-admin can assign to an admin
-	if assignee.Role == constants.RoleAdmin {
-		return false, nil
-	}
-
-	if assignerRole == constants.RoleManager {
-		// Manager can assign to themselves
-		if assignerID == assigneeID {
-			return true, nil
-		}
-
-		// Or to anyone in their recursive hierarchy
-		reportIDs := GetRecursiveReportIDs(assignerID, db)
-		for _, reportID := range reportIDs {
-			if assigneeID == reportID {
-				return true, nil
-			}
-		}
-		return false, nil
-	}
-
-	if assignerRole == constants.RoleMember {
-		// Members can only assign to themselves
-		return assignerID == assigneeID, nil
-	}
-
-	return false, nil
-}
-
-func JwtSecret() []byte {
-	return jwtSecret
+	userID, _ := claims["user_id"].(float64)
+	version, _ := claims["ver"].(float64)
+	return uint(userID), uint(version), nil
 }
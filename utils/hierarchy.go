@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// reportCacheTTL bounds how stale a cached reporting line can be when
+// another instance changes a manager.
+const reportCacheTTL = 30 * time.Second
+
+// reportIDsQuery walks the reporting line in one round trip. UNION (rather
+// than UNION ALL) drops rows already seen, so a manager_id cycle ends the
+// recursion instead of looping forever.
+const reportIDsQuery = `
+WITH RECURSIVE reports (id) AS (
+	SELECT id FROM users WHERE manager_id = ?
+	UNION
+	SELECT u.id FROM users u JOIN reports r ON u.manager_id = r.id
+)
+SELECT id FROM reports WHERE id <> ?`
+
+type reportCacheEntry struct {
+	ids       []uint
+	expiresAt time.Time
+}
+
+var (
+	reportCacheMu sync.Mutex
+	reportCache   = map[uint]reportCacheEntry{}
+)
+
+// GetRecursiveReportIDs returns the ids of everyone reporting to managerID,
+// directly or indirectly. Results are cached; the returned slice must not
+// be modified. On a database error it returns no reports, so callers fail
+// closed.
+func GetRecursiveReportIDs(managerID uint, db *gorm.DB) []uint {
+	now := time.Now()
+
+	reportCacheMu.Lock()
+	entry, ok := reportCache[managerID]
+	reportCacheMu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.ids
+	}
+
+	ids, err := LoadReportIDs(managerID, db)
+	if err != nil {
+		slog.Error("failed to load reports", "manager_id", managerID, "error", err)
+		return nil
+	}
+
+	reportCacheMu.Lock()
+	reportCache[managerID] = reportCacheEntry{ids: ids, expiresAt: now.Add(reportCacheTTL)}
+	reportCacheMu.Unlock()
+
+	return ids
+}
+
+// LoadReportIDs queries the reporting line of managerID, bypassing the
+// cache. Use it where a stale answer is not acceptable.
+func LoadReportIDs(managerID uint, db *gorm.DB) ([]uint, error) {
+	var ids []uint
+	err := db.Raw(reportIDsQuery, managerID, managerID).Scan(&ids).Error
+	return ids, err
+}
+
+// InvalidateReportCache must be called after any change to users.manager_id,
+// including creating or deleting users.
+func InvalidateReportCache() {
+	reportCacheMu.Lock()
+	defer reportCacheMu.Unlock()
+	reportCache = map[uint]reportCacheEntry{}
+}
+
+// managerChainQuery walks up from a user to the top of the hierarchy. As
+// with reportIDsQuery, UNION stops at a cycle.
+const managerChainQuery = `
+WITH RECURSIVE chain (id, manager_id) AS (
+	SELECT id, manager_id FROM users WHERE id = ?
+	UNION
+	SELECT u.id, u.manager_id FROM users u JOIN chain c ON u.id = c.manager_id
+)
+SELECT id, manager_id FROM chain`
+
+// LoadManagerChainIDs returns the managers above userID, nearest first.
+func LoadManagerChainIDs(userID uint, db *gorm.DB) ([]uint, error) {
+	var rows []struct {
+		ID        uint
+		ManagerID *uint
+	}
+	if err := db.Raw(managerChainQuery, userID).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	managerOf := make(map[uint]*uint, len(rows))
+	for _, row := range rows {
+		managerOf[row.ID] = row.ManagerID
+	}
+
+	var chain []uint
+	seen := map[uint]bool{userID: true}
+	for next := managerOf[userID]; next != nil && !seen[*next]; next = managerOf[*next] {
+		seen[*next] = true
+		chain = append(chain, *next)
+	}
+	return chain, nil
+}
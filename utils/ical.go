@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const icalTimeFormat = "20060102T150405Z"
+
+// ICalEntry is a single VEVENT or VTODO component of an iCalendar feed.
+type ICalEntry struct {
+	UID             string
+	Summary         string
+	Description     string
+	Due             time.Time
+	LastModified    time.Time
+	Sequence        int
+	Status          string
+	PercentComplete int
+	Todo            bool
+}
+
+// BuildICalendar renders entries as an RFC 5545 calendar named name.
+func BuildICalendar(name string, entries []ICalEntry) string {
+	var b strings.Builder
+	stamp := time.Now().UTC().Format(icalTimeFormat)
+
+	writeICalLine(&b, "BEGIN:VCALENDAR")
+	writeICalLine(&b, "VERSION:2.0")
+	writeICalLine(&b, "PRODID:-//taskmanager//Task deadlines//EN")
+	writeICalLine(&b, "CALSCALE:GREGORIAN")
+	writeICalLine(&b, "METHOD:PUBLISH")
+	writeICalLine(&b, "X-WR-CALNAME:"+escapeICalText(name))
+	writeICalLine(&b, "REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	writeICalLine(&b, "X-PUBLISHED-TTL:PT1H")
+
+	for _, entry := range entries {
+		component := "VEVENT"
+		if entry.Todo {
+			component = "VTODO"
+		}
+		due := entry.Due.UTC().Format(icalTimeFormat)
+
+		writeICalLine(&b, "BEGIN:"+component)
+		writeICalLine(&b, "UID:"+entry.UID)
+		writeICalLine(&b, "DTSTAMP:"+stamp)
+		if entry.Todo {
+			writeICalLine(&b, "DUE:"+due)
+			writeICalLine(&b, "PERCENT-COMPLETE:"+strconv.Itoa(entry.PercentComplete))
+		} else {
+			writeICalLine(&b, "DTSTART:"+due)
+			writeICalLine(&b, "TRANSP:TRANSPARENT")
+		}
+		writeICalLine(&b, "SUMMARY:"+escapeICalText(entry.Summary))
+		if entry.Description != "" {
+			writeICalLine(&b, "DESCRIPTION:"+escapeICalText(entry.Description))
+		}
+		if entry.Status != "" {
+			writeICalLine(&b, "STATUS:"+entry.Status)
+		}
+		if !entry.LastModified.IsZero() {
+			writeICalLine(&b, "LAST-MODIFIED:"+entry.LastModified.UTC().Format(icalTimeFormat))
+		}
+		writeICalLine(&b, "SEQUENCE:"+strconv.Itoa(entry.Sequence))
+		writeICalLine(&b, "END:"+component)
+	}
+
+	writeICalLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+func escapeICalText(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	)
+	return replacer.Replace(value)
+}
+
+// writeICalLine folds content lines longer than 75 octets as required by
+// RFC 5545 and terminates them with CRLF.
+func writeICalLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		// Never split a multi-byte UTF-8 sequence.
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space that counts towards the limit.
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTKey is a key used to sign or verify our JWTs. Keys loaded from a public
+// key file can only verify tokens; they keep tokens signed before a rotation
+// valid until they expire.
+type JWTKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// JWTKeySet holds every key that verifies tokens and the one that signs new
+// tokens.
+type JWTKeySet struct {
+	Active *JWTKey
+	Keys   map[string]*JWTKey
+}
+
+var (
+	jwtKeysMu sync.Mutex
+	jwtKeySet *JWTKeySet
+)
+
+// InitJWTKeys loads the signing keys and makes them the ones in use:
+//
+//   - dir: directory of PEM files named <kid>.pem holding RSA or Ed25519
+//     keys, private keys for signing and public keys for verification only
+//   - activeKID: the key that signs new tokens, required when the directory
+//     holds more than one private key
+//
+// Without dir an ephemeral key is generated, unless production is set, in
+// which case an error is returned.
+func InitJWTKeys(dir, activeKID string, production bool) error {
+	keys, err := LoadJWTKeys(dir, activeKID, production)
+	if err != nil {
+		return err
+	}
+	UseJWTKeys(keys)
+	return nil
+}
+
+// UseJWTKeys replaces the key set. Passing nil makes the next use generate
+// an ephemeral key.
+func UseJWTKeys(keys *JWTKeySet) {
+	jwtKeysMu.Lock()
+	defer jwtKeysMu.Unlock()
+	jwtKeySet = keys
+}
+
+func currentJWTKeys() (*JWTKeySet, error) {
+	jwtKeysMu.Lock()
+	defer jwtKeysMu.Unlock()
+	if jwtKeySet == nil {
+		keys, err := LoadJWTKeys("", "", false)
+		if err != nil {
+			return nil, err
+		}
+		jwtKeySet = keys
+	}
+	return jwtKeySet, nil
+}
+
+// LoadJWTKeys reads the keys in dir. An empty dir yields an ephemeral
+// Ed25519 key, which is refused in production.
+func LoadJWTKeys(dir, activeKID string, production bool) (*JWTKeySet, error) {
+	if dir == "" {
+		if production {
+			return nil, errors.New("JWT_KEYS_DIR must be set in production")
+		}
+		slog.Warn("JWT_KEYS_DIR is not set, signing tokens with an ephemeral key; tokens will not survive a restart")
+		return ephemeralJWTKeys()
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	keys := &JWTKeySet{Keys: map[string]*JWTKey{}}
+	var signing []*JWTKey
+	for _, path := range paths {
+		key, err := loadJWTKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("load JWT key %s: %w", path, err)
+		}
+		keys.Keys[key.ID] = key
+		if key.PrivateKey != nil {
+			signing = append(signing, key)
+		}
+	}
+
+	switch {
+	case activeKID != "":
+		keys.Active = keys.Keys[activeKID]
+		if keys.Active == nil || keys.Active.PrivateKey == nil {
+			return nil, fmt.Errorf("JWT_ACTIVE_KID %q does not name a private key in %s", activeKID, dir)
+		}
+	case len(signing) == 1:
+		keys.Active = signing[0]
+	case len(signing) == 0:
+		return nil, fmt.Errorf("no private JWT key found in %s", dir)
+	default:
+		return nil, fmt.Errorf("%s holds several private JWT keys, set JWT_ACTIVE_KID", dir)
+	}
+
+	return keys, nil
+}
+
+func ephemeralJWTKeys() (*JWTKeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid, _, err := GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+	key := &JWTKey{
+		ID:         "ephemeral-" + kid[:8],
+		Method:     jwt.SigningMethodEdDSA,
+		PrivateKey: private,
+		PublicKey:  public,
+	}
+	return &JWTKeySet{Active: key, Keys: map[string]*JWTKey{key.ID: key}}, nil
+}
+
+func loadJWTKey(path string) (*JWTKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	key := &JWTKey{ID: strings.TrimSuffix(filepath.Base(path), ".pem")}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.PublicKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.PublicKey = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", parsed)
+	}
+
+	if rsaKey, ok := key.PublicKey.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < 2048 {
+		return nil, errors.New("RSA keys must be at least 2048 bits")
+	}
+
+	return key, nil
+}
+
+// signJWT signs claims with the active key and sets the kid header.
+func signJWT(claims jwt.Claims) (string, error) {
+	keys, err := currentJWTKeys()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(keys.Active.Method, claims)
+	token.Header["kid"] = keys.Active.ID
+	return token.SignedString(keys.Active.PrivateKey)
+}
+
+// ParseJWT verifies a session token signed by any of our keys. The kid
+// header picks the key and the algorithm must match it. Tokens of another
+// type, such as login challenges, are rejected.
+func ParseJWT(tokenString string) (*jwt.Token, error) {
+	return parseJWT(tokenString, SessionAudience, sessionTokenType)
+}
+
+// parseJWT verifies the signature of tokenString and that it carries the
+// given audience and typ claim.
+func parseJWT(tokenString, audience, typ string) (*jwt.Token, error) {
+	keys, err := currentJWTKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(
+		tokenString,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			key := keys.Keys[kid]
+			if key == nil {
+				return nil, errors.New("unknown signing key")
+			}
+			if token.Method.Alg() != key.Method.Alg() {
+				return nil, errors.New("unexpected signing method")
+			}
+			return key.PublicKey, nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithAudience(audience),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != typ {
+		return nil, errors.New("unexpected token type")
+	}
+	return token, nil
+}
+
+// JWKS returns the public verification keys as an RFC 7517 JSON Web Key Set.
+func JWKS() (map[string]any, error) {
+	keys, err := currentJWTKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(keys.Keys))
+	for id := range keys.Keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	jwks := make([]map[string]string, 0, len(ids))
+	for _, id := range ids {
+		key := keys.Keys[id]
+		jwk := map[string]string{
+			"kid": key.ID,
+			"alg": key.Method.Alg(),
+			"use": "sig",
+		}
+		switch public := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(public)
+		}
+		jwks = append(jwks, jwk)
+	}
+
+	return map[string]any{"keys": jwks}, nil
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// AccessTokenPrefix marks personal access tokens so they can be told apart
+// from JWTs, and found by secret scanners.
+const AccessTokenPrefix = "tm_pat_"
+
+// GenerateToken returns a random URL-safe token together with the hash
+// that should be persisted in its place.
+func GenerateToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex encoded SHA-256 digest of token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAccessToken returns a new personal access token and its hash.
+func GenerateAccessToken() (string, string, error) {
+	token, _, err := GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+	token = AccessTokenPrefix + token
+	return token, HashToken(token), nil
+}
+
+// IsAccessToken reports whether a bearer token looks like a personal access
+// token rather than a JWT.
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, AccessTokenPrefix)
+}
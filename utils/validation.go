@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"unicode"
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores everything after the first 72 bytes.
+	maxPasswordLength = 72
+)
+
+// NormalizeEmail validates email and returns it trimmed and lower-cased.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return "", errors.New("email is required")
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return "", errors.New("email is invalid")
+	}
+	return email, nil
+}
+
+// ValidatePassword enforces the minimum password strength.
+func ValidatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return errors.New("password must be at least 8 characters")
+	}
+	if len(password) > maxPasswordLength {
+		return errors.New("password must be at most 72 bytes")
+	}
+
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return errors.New("password must contain at least one letter and one digit")
+	}
+	return nil
+}